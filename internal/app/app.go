@@ -2,47 +2,65 @@ package app
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
-	"github.com/gorilla/securecookie"
+	"github.com/go-chi/httprate"
 	"github.com/gorilla/sessions"
 	"github.com/grvbrk/nazrein_server/internal/auth"
 	"github.com/grvbrk/nazrein_server/internal/handlers"
 	handler_analytics "github.com/grvbrk/nazrein_server/internal/handlers/analytics"
+	"github.com/grvbrk/nazrein_server/internal/jobs"
 	"github.com/grvbrk/nazrein_server/internal/middlewares"
 	"github.com/grvbrk/nazrein_server/internal/services"
 	"github.com/grvbrk/nazrein_server/internal/store"
 	"github.com/grvbrk/nazrein_server/internal/store/admin"
 	"github.com/grvbrk/nazrein_server/internal/store/analytics"
+	"github.com/grvbrk/nazrein_server/internal/utils"
 	// "github.com/grvbrk/nazrein_server/migrations"
 )
 
-var (
-	authKey            = securecookie.GenerateRandomKey(64)
-	encryptionKey      = securecookie.GenerateRandomKey(32)
-	adminAuthKey       = securecookie.GenerateRandomKey(64)
-	adminEncryptionKey = securecookie.GenerateRandomKey(32)
+const (
+	titleVersionSyncInterval = 5 * time.Minute
+	videoChangeSyncInterval  = 5 * time.Minute
+	languageBackfillInterval = 10 * time.Minute
+	trendingRefreshInterval  = 15 * time.Minute
+	sessionCleanupInterval   = time.Hour
+	accountDeletionInterval  = time.Hour
+	rateLimitCleanupInterval = 10 * time.Minute
+	autoApprovalInterval     = time.Minute
 )
 
 type Application struct {
 	Logger *log.Logger
 	// RedisClient           *redis.Client
-	Oauth                 *auth.GoogleOauth
-	AdminOauth            *auth.AdminGoogleOauth
-	SessionStore          *sessions.CookieStore
-	db                    *sql.DB
-	DBConn                driver.Conn
-	MiddlewareHandler     *middlewares.MiddlewareHandler
-	UserHandler           *handlers.UserHandler
-	DashboardHandler      *handlers.DashboardHandler
-	VideoHandler          *handlers.VideoHandler
-	VideoRequestHandler   *handlers.VideoRequestHandler
-	BookmarkHandler       *handlers.BookmarkHandler
-	AnalyticsVideoHandler *handler_analytics.AnalyticsVideoHandler
-	AdminHandler          *handlers.AdminHandler
+	Oauth                  *auth.UserOauth
+	AdminOauth             *auth.AdminGoogleOauth
+	DevIssuer              *auth.DevIssuer
+	SessionStore           *auth.ServerSessionStore
+	db                     *sql.DB
+	DBConn                 driver.Conn
+	MiddlewareHandler      *middlewares.MiddlewareHandler
+	UserHandler            *handlers.UserHandler
+	DashboardHandler       *handlers.DashboardHandler
+	VideoHandler           *handlers.VideoHandler
+	VideoRequestHandler    *handlers.VideoRequestHandler
+	BookmarkHandler        *handlers.BookmarkHandler
+	AnalyticsVideoHandler  *handler_analytics.AnalyticsVideoHandler
+	AnalyticsSearchHandler *handler_analytics.AnalyticsSearchHandler
+	AdminHandler           *handlers.AdminHandler
+	AuditHandler           *handlers.AuditHandler
+	AutoApprovalHandler    *handlers.AutoApprovalHandler
+	SessionHandler         *handlers.SessionHandler
+	APITokenHandler        *handlers.APITokenHandler
+	IdentityHandler        *handlers.IdentityHandler
+	AccountHandler         *handlers.AccountHandler
+	Scheduler              *jobs.Scheduler
 }
 
 func NewApplication() (*Application, error) {
@@ -106,65 +124,224 @@ func NewApplication() (*Application, error) {
 		adminOptions.Domain = ""
 	}
 
-	sessionStore := sessions.NewCookieStore(authKey, encryptionKey)
-	sessionStore.Options = userOptions
+	sessionKeys, err := loadSessionKeys("SESSION_KEYS", env, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	adminSessionStore := sessions.NewCookieStore(adminAuthKey, adminEncryptionKey)
-	adminSessionStore.Options = adminOptions
+	adminSessionKeys, err := loadSessionKeys("ADMIN_SESSION_KEYS", env, adminLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	// TRUSTED_PROXIES are the addresses whose forwarding headers are
+	// believed, the private ranges by default
+	proxies, err := utils.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+
+	serverSessionStore := store.NewPostgresSessionStore(pgDB)
+	apiTokenStore := store.NewPostgresAPITokenStore(pgDB)
+
+	sessionStore := auth.NewServerSessionStore(serverSessionStore, "user_id", proxies, userOptions, auth.KeyPairs(sessionKeys)...)
+	adminSessionStore := auth.NewServerSessionStore(serverSessionStore, "admin_id", proxies, adminOptions, auth.KeyPairs(adminSessionKeys)...)
 
 	userStore := store.NewPostgresUserStore(pgDB)
+	identityStore := store.NewPostgresIdentityStore(pgDB)
 	dashboardStore := store.NewPostgresDashboardStore(pgDB)
 	videoStore := store.NewPostgresVideoStore(pgDB)
 	// redisVideoStore := store.NewRedisVideoStore(redisClient)
 	videoRequestStore := store.NewPostgresVideoRequestStore(pgDB)
+	videoWatcherStore := store.NewPostgresVideoWatcherStore(pgDB)
 	bookmarkStore := store.NewPostgresBookmarkStore(pgDB)
+	titleVersionStore := store.NewPostgresTitleVersionStore(pgDB)
+	checkpointStore := store.NewPostgresCheckpointStore(pgDB)
+	videoChangeStore := store.NewPostgresVideoChangeStore(pgDB)
+	videoLanguageStore := store.NewPostgresVideoLanguageStore(pgDB)
+	trendingStore := store.NewPostgresTrendingStore(pgDB)
 
 	analyticsVideoStore := analytics.NewClickhouseVideoStore(dbConn)
+	analyticsSearchStore := analytics.NewClickhouseSearchStore(dbConn)
 
 	adminVideoStore := admin.NewPostgresAdminVideoStore(pgDB)
 	adminUserStore := admin.NewPostgresAdminUserStore(pgDB)
 	adminVideoRequestStore := admin.NewPostgresAdminVideoRequestStore(pgDB)
+	adminAuditStore := admin.NewPostgresAdminAuditStore(pgDB)
+	adminAutoApprovalStore := admin.NewPostgresAdminAutoApprovalStore(pgDB)
+
+	youtubeClient := services.NewYouTubeClient()
 
-	oauth, err := auth.NewGoogleOauth(logger, sessionStore, userStore)
+	devAuth, err := auth.DevAuthEnabled()
 	if err != nil {
 		return nil, err
 	}
 
-	adminoauth, err := auth.NewAdminGoogleOauth(adminLogger, adminSessionStore, userStore)
+	var devIssuer *auth.DevIssuer
+	if devAuth {
+		devIssuer, err = auth.NewDevIssuer(logger)
+		if err != nil {
+			return nil, err
+		}
+
+		err = devIssuer.Seed(userStore, identityStore)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Println("AUTH_PROVIDER=dev, log in at /auth/dev/login and /auth/admin/dev/login")
+	}
+
+	providers, err := auth.LoadProviders(devIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	oauth, err := auth.NewUserOauth(logger, sessionStore, userStore, identityStore, providers)
+	if err != nil {
+		return nil, err
+	}
+
+	adminoauth, err := auth.NewAdminGoogleOauth(adminLogger, adminSessionStore, userStore, identityStore, devIssuer)
 	if err != nil {
 		return nil, err
 	}
 
 	userHandler := handlers.NewUserHandler(userStore, logger)
 	dashboardHandler := handlers.NewDashboardHandler(dashboardStore, logger)
-	videoHandler := handlers.NewVideoHandler(videoStore, logger, oauth)
-	videoRequestHandler := handlers.NewVideoRequestHandler(videoRequestStore, logger, oauth)
+	videoHandler := handlers.NewVideoHandler(videoStore, analyticsSearchStore, logger, oauth)
+	videoRequestHandler := handlers.NewVideoRequestHandler(videoRequestStore, videoWatcherStore, youtubeClient, logger, oauth)
 	bookmarkHandler := handlers.NewBookmarkHandler(videoStore, bookmarkStore, userStore, oauth, logger)
 
 	analyticsVideoHandler := handler_analytics.NewAnalyticsVideoHandler(analyticsVideoStore, logger)
+	analyticsSearchHandler := handler_analytics.NewAnalyticsSearchHandler(analyticsSearchStore, adminLogger)
+
+	adminHander := handlers.NewAdminHandler(adminVideoStore, adminUserStore, adminVideoRequestStore, youtubeClient, adminLogger, adminoauth, proxies)
 
-	adminHander := handlers.NewAdminHandler(adminVideoStore, adminUserStore, adminVideoRequestStore, adminLogger, adminoauth)
+	auditHandler := handlers.NewAuditHandler(adminAuditStore, adminLogger)
+	autoApprovalHandler := handlers.NewAutoApprovalHandler(adminAutoApprovalStore, adminUserStore, adminLogger, proxies)
 
-	middlewareHandler := middlewares.NewMiddlewareHandler(logger, adminLogger, sessionStore, adminSessionStore)
+	sessionHandler := handlers.NewSessionHandler(serverSessionStore, adminAuditStore, logger, proxies)
+	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenStore, logger)
+	identityHandler := handlers.NewIdentityHandler(identityStore, logger)
+	accountHandler := handlers.NewAccountHandler(userStore, identityStore, videoStore, videoRequestStore, bookmarkStore, serverSessionStore, apiTokenStore, logger)
+
+	rateLimitStore := store.NewPostgresRateLimitStore(pgDB)
+
+	newRateLimitCounter, err := rateLimitBackend(env, pgDB, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimits, err := middlewares.LoadRateLimits(middlewares.DefaultRateLimitPolicies, newRateLimitCounter, proxies, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	middlewareHandler := middlewares.NewMiddlewareHandler(logger, adminLogger, sessionStore, adminSessionStore, apiTokenStore, userStore, rateLimits)
+
+	scheduler := jobs.NewScheduler(logger)
+	scheduler.Add(jobs.NewTitleVersionSyncJob(analyticsVideoStore, titleVersionStore, checkpointStore, logger), titleVersionSyncInterval)
+	scheduler.Add(jobs.NewVideoChangeSyncJob(analyticsVideoStore, videoChangeStore, checkpointStore, logger), videoChangeSyncInterval)
+	scheduler.Add(jobs.NewVideoLanguageBackfillJob(videoLanguageStore, youtubeClient, logger), languageBackfillInterval)
+	scheduler.Add(jobs.NewTrendingRefreshJob(trendingStore, logger), trendingRefreshInterval)
+	scheduler.Add(jobs.NewSessionCleanupJob(serverSessionStore, logger), sessionCleanupInterval)
+	scheduler.Add(jobs.NewAccountDeletionJob(userStore, videoWatcherStore, analyticsVideoStore, logger), accountDeletionInterval)
+	scheduler.Add(jobs.NewRateLimitCleanupJob(rateLimitStore, logger), rateLimitCleanupInterval)
+	scheduler.Add(jobs.NewAutoApprovalJob(adminAutoApprovalStore, adminVideoRequestStore, adminVideoStore, youtubeClient, adminLogger), autoApprovalInterval)
 
 	app := &Application{
 		Logger: logger,
 		// RedisClient:           redisClient,
-		Oauth:                 oauth,
-		AdminOauth:            adminoauth,
-		SessionStore:          sessionStore,
-		db:                    pgDB,
-		DBConn:                dbConn,
-		MiddlewareHandler:     middlewareHandler,
-		UserHandler:           userHandler,
-		DashboardHandler:      dashboardHandler,
-		VideoHandler:          videoHandler,
-		VideoRequestHandler:   videoRequestHandler,
-		BookmarkHandler:       bookmarkHandler,
-		AnalyticsVideoHandler: analyticsVideoHandler,
-		AdminHandler:          adminHander,
+		Oauth:                  oauth,
+		AdminOauth:             adminoauth,
+		DevIssuer:              devIssuer,
+		SessionStore:           sessionStore,
+		db:                     pgDB,
+		DBConn:                 dbConn,
+		MiddlewareHandler:      middlewareHandler,
+		UserHandler:            userHandler,
+		DashboardHandler:       dashboardHandler,
+		VideoHandler:           videoHandler,
+		VideoRequestHandler:    videoRequestHandler,
+		BookmarkHandler:        bookmarkHandler,
+		AnalyticsVideoHandler:  analyticsVideoHandler,
+		AnalyticsSearchHandler: analyticsSearchHandler,
+		AdminHandler:           adminHander,
+		AuditHandler:           auditHandler,
+		AutoApprovalHandler:    autoApprovalHandler,
+		SessionHandler:         sessionHandler,
+		APITokenHandler:        apiTokenHandler,
+		IdentityHandler:        identityHandler,
+		AccountHandler:         accountHandler,
+		Scheduler:              scheduler,
 	}
 
 	return app, nil
 
 }
+
+// loadSessionKeys reads the cookie keys from name, see
+// auth.LoadSessionKeyPairs. Outside production a missing config falls back
+// to random keys, which log everyone out on restart.
+func loadSessionKeys(name string, env string, logger *log.Logger) ([]auth.SessionKeyPair, error) {
+	pairs, err := auth.LoadSessionKeyPairs(name)
+	if errors.Is(err, auth.ErrNoSessionKeys) && env != "production" {
+		logger.Printf("Warning: %s is not set, using random session keys", name)
+		return []auth.SessionKeyPair{auth.GenerateSessionKeyPair()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session keys: %w", err)
+	}
+
+	return pairs, nil
+}
+
+// rateLimitBackend picks where the rate limit counters live from
+// RATE_LIMIT_BACKEND:
+//
+//	redis     REDIS_URL, falling back to postgres while redis is down
+//	postgres  the rate_limit_counters table
+//	memory    each replica counting on its own, for development
+//
+// Without it, redis is used when REDIS_URL is set, else postgres in
+// production and memory elsewhere.
+func rateLimitBackend(env string, pgDB *sql.DB, logger *log.Logger) (func() httprate.LimitCounter, error) {
+	backend := os.Getenv("RATE_LIMIT_BACKEND")
+	if backend == "" {
+		switch {
+		case os.Getenv("REDIS_URL") != "":
+			backend = "redis"
+		case env == "production":
+			backend = "postgres"
+		default:
+			backend = "memory"
+		}
+	}
+
+	switch backend {
+	case "redis":
+		redisClient, err := services.ConnectRedis()
+		if err != nil {
+			return nil, err
+		}
+		return func() httprate.LimitCounter {
+			return middlewares.NewFallbackLimitCounter(store.NewRedisRateLimitStore(redisClient), store.NewPostgresRateLimitStore(pgDB), logger)
+		}, nil
+
+	case "postgres":
+		return func() httprate.LimitCounter {
+			return store.NewPostgresRateLimitStore(pgDB)
+		}, nil
+
+	case "memory":
+		if env == "production" {
+			logger.Println("Warning: rate limits are counted per replica with RATE_LIMIT_BACKEND=memory")
+		}
+		return func() httprate.LimitCounter {
+			return httprate.NewLocalLimitCounter(time.Minute)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("RATE_LIMIT_BACKEND must be redis, postgres or memory, got %q", backend)
+}
@@ -2,19 +2,16 @@ package auth
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/gorilla/sessions"
+	"github.com/grvbrk/nazrein_server/internal/models"
 	"github.com/grvbrk/nazrein_server/internal/store"
 	"github.com/grvbrk/nazrein_server/internal/utils"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 type AdminOAuth interface {
@@ -23,87 +20,137 @@ type AdminOAuth interface {
 	Callback(w http.ResponseWriter, r *http.Request)
 }
 
+// AdminGoogleOauth logs admins in with google, or with the dev provider when
+// it's enabled. Only accounts whose identity is linked to a user with admin
+// access get in.
 type AdminGoogleOauth struct {
-	Logger    *log.Logger
-	Config    *oauth2.Config
-	Store     *sessions.CookieStore
-	UserStore *store.PostgresUserStore
+	Logger        *log.Logger
+	Provider      Provider
+	Store         *ServerSessionStore
+	UserStore     store.UserStore
+	IdentityStore store.IdentityStore
+
+	loginStates *loginStateCookie
+	returnTo    *returnToAllowlist
 }
 
-func NewAdminGoogleOauth(logger *log.Logger, adminStore *sessions.CookieStore, userStore *store.PostgresUserStore) (*AdminGoogleOauth, error) {
+func NewAdminGoogleOauth(logger *log.Logger, adminStore *ServerSessionStore, userStore store.UserStore, identityStore store.IdentityStore, devIssuer *DevIssuer) (*AdminGoogleOauth, error) {
+	var provider Provider
+	if devIssuer != nil {
+		provider = devIssuer.Provider(fmt.Sprintf("%s/auth/admin/%s/callback", os.Getenv("NEXT_PUBLIC_BACKEND_URL"), devProviderName))
+	} else {
+		provider = newGoogleProvider(
+			os.Getenv("GOOGLE_CLIENT_ID_ADMIN"),
+			os.Getenv("GOOGLE_CLIENT_SECRET_ADMIN"),
+			fmt.Sprintf("%s/auth/admin/google/callback", os.Getenv("NEXT_PUBLIC_BACKEND_URL")),
+		)
+	}
+
 	return &AdminGoogleOauth{
-		Logger: logger,
-		Config: &oauth2.Config{
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID_ADMIN"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET_ADMIN"),
-			RedirectURL:  fmt.Sprintf("%s/auth/admin/google/callback", os.Getenv("NEXT_PUBLIC_BACKEND_URL")),
-			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.profile", "https://www.googleapis.com/auth/userinfo.email"},
-			Endpoint:     google.Endpoint,
-		},
-		Store:     adminStore,
-		UserStore: userStore,
+		Logger:        logger,
+		Provider:      provider,
+		Store:         adminStore,
+		UserStore:     userStore,
+		IdentityStore: identityStore,
+		loginStates:   newLoginStateCookie("nazrein_admin_oauth_state", adminStore),
+		returnTo:      newReturnToAllowlist(os.Getenv("ADMIN_FRONTEND_URL"), "/dashboard"),
 	}, nil
 }
 
+// Login starts the admin login. return_to is optional, see
+// returnToAllowlist for what it may point at.
 func (g *AdminGoogleOauth) Login(w http.ResponseWriter, r *http.Request) {
-	url := g.Config.AuthCodeURL("random-state-string", oauth2.AccessTypeOffline)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
-}
+	if chi.URLParam(r, "provider") != g.Provider.Name() {
+		g.Logger.Printf("Error: admin login with %q, expected %s", chi.URLParam(r, "provider"), g.Provider.Name())
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Not Found"})
+		return
+	}
 
-func (g *AdminGoogleOauth) Callback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	token, err := g.Config.Exchange(context.Background(), code)
+	returnTo, ok := g.returnTo.resolve(r.URL.Query().Get("return_to"))
+	if !ok {
+		g.Logger.Printf("Error: return_to %q is not allowed", r.URL.Query().Get("return_to"))
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	loginState, err := g.loginStates.begin(w, loginState{Provider: g.Provider.Name(), ReturnTo: returnTo})
 	if err != nil {
-		g.Logger.Println("Error exchanging admin token", err)
+		g.Logger.Println("Error starting admin login", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 		return
 	}
 
-	client := g.Config.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	url, err := g.Provider.AuthCodeURL(r.Context(), loginState.State, loginState.Verifier, loginState.Nonce)
 	if err != nil {
-		g.Logger.Println("Error getting admin info", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		g.Logger.Println("Error building admin login url", err)
+		utils.WriteJSON(w, http.StatusBadGateway, utils.Envelope{"Error": "Bad Gateway"})
 		return
 	}
 
-	defer resp.Body.Close()
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+func (g *AdminGoogleOauth) Callback(w http.ResponseWriter, r *http.Request) {
+	loginState, err := g.loginStates.finish(w, r)
+	if err != nil {
+		g.Logger.Println("Error verifying admin login state", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
 
-	var userInfo struct {
-		GoogleID string `json:"id"`
-		Email    string `json:"email"`
-		Name     string `json:"name"`
-		Image    string `json:"picture"`
+	if chi.URLParam(r, "provider") != loginState.Provider {
+		g.Logger.Printf("Error: admin callback of %q for a login with %q", chi.URLParam(r, "provider"), loginState.Provider)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&userInfo)
+	identity, err := g.Provider.Identify(context.Background(), r.URL.Query().Get("code"), loginState.Verifier, loginState.Nonce)
 	if err != nil {
-		g.Logger.Println("Error decoding user info", err)
+		g.Logger.Println("Error identifying admin", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 		return
 	}
 
-	var userId string
-	user, err := g.UserStore.GetUserByGoogleID(userInfo.GoogleID)
-	if user == nil || err == sql.ErrNoRows {
+	existing, err := g.IdentityStore.GetIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		g.Logger.Println("Error getting admin identity", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+	if existing == nil {
 		g.Logger.Println("User not found")
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
 		return
 	}
 
-	if user.Role != "ADMIN" {
-		g.Logger.Println("User not admin")
+	user, err := g.UserStore.GetUserByID(existing.UserID)
+	if err != nil || user == nil {
+		g.Logger.Println("Error getting admin user", err)
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+		return
+	}
+
+	if !user.HasPermission(models.PermissionAdminAccess) {
+		g.Logger.Printf("User %s with role %s has no admin access", user.ID, user.Role)
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
 		return
 	}
 
-	userId = user.ID.String()
+	if err := g.IdentityStore.TouchIdentity(existing.ID, identity.Email); err != nil {
+		g.Logger.Println("Error updating identity last login:", err)
+	}
+
+	session, _ := g.Store.Get(r, AdminSessionName)
+	if err := g.Store.Renew(session); err != nil {
+		g.Logger.Println("Error renewing admin session", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
 
-	session, _ := g.Store.Get(r, "nazrein_admin_session")
-	session.Values["admin_email"] = userInfo.Email
-	session.Values["admin_id"] = userId
-	session.Values["admin_name"] = userInfo.Name
-	session.Values["admin_image"] = userInfo.Image
+	session.Values["admin_email"] = user.Email
+	session.Values["admin_id"] = user.ID.String()
+	session.Values["admin_name"] = identity.Name
+	session.Values["admin_image"] = identity.Image
 
 	err = session.Save(r, w)
 	if err != nil {
@@ -112,12 +159,11 @@ func (g *AdminGoogleOauth) Callback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	redirectURL := os.Getenv("ADMIN_FRONTEND_URL") + "/dashboard"
-	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	http.Redirect(w, r, loginState.ReturnTo, http.StatusSeeOther)
 }
 
 func (g *AdminGoogleOauth) Logout(w http.ResponseWriter, r *http.Request) {
-	session, _ := g.Store.Get(r, "nazrein_admin_session")
+	session, _ := g.Store.Get(r, AdminSessionName)
 
 	for key := range session.Values {
 		delete(session.Values, key)
@@ -136,7 +182,7 @@ func (g *AdminGoogleOauth) Logout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (g *AdminGoogleOauth) AuthAdmin(w http.ResponseWriter, r *http.Request) {
-	session, err := g.Store.Get(r, "nazrein_admin_session")
+	session, err := g.Store.Get(r, AdminSessionName)
 	if err != nil {
 		g.Logger.Println("Failed to decode admin session:", err)
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
@@ -148,7 +194,8 @@ func (g *AdminGoogleOauth) AuthAdmin(w http.ResponseWriter, r *http.Request) {
 	adminName, nameOk := session.Values["admin_name"].(string)
 	adminImage, imageOk := session.Values["admin_image"].(string)
 
-	if !emailOk || !idOk || !nameOk || !imageOk || adminEmail == "" || adminIDStr == "" || adminName == "" || adminImage == "" {
+	// not every provider has a picture, so the image may be empty
+	if !emailOk || !idOk || !nameOk || !imageOk || adminEmail == "" || adminIDStr == "" || adminName == "" {
 		g.Logger.Println("Invalid or missing admin data in session")
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authenticated"})
 		return
@@ -161,12 +208,28 @@ func (g *AdminGoogleOauth) AuthAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the role is read fresh, so the admin UI offers only what the admin's
+	// permissions allow now
+	user, err := g.UserStore.GetUserByID(adminID)
+	if err != nil {
+		g.Logger.Println("Error getting admin user", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	if user == nil || !user.HasPermission(models.PermissionAdminAccess) {
+		g.Logger.Printf("Admin session of %s without admin access", adminID)
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authenticated"})
+		return
+	}
+
 	adminInfo := map[string]interface{}{
-		"id":    adminID,
-		"email": adminEmail,
-		"name":  adminName,
-		"image": adminImage,
-		"role":  "ADMIN",
+		"id":          adminID,
+		"email":       adminEmail,
+		"name":        adminName,
+		"image":       adminImage,
+		"role":        user.Role,
+		"permissions": user.Permissions,
 	}
 
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": adminInfo})
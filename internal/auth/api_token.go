@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Tokens look like nzr_<43 url safe characters>. The fixed prefix makes
+// leaked tokens easy to grep for.
+const apiTokenPrefix = "nzr_"
+
+// apiTokenDisplayLength is how much of a token is kept to tell it apart.
+const apiTokenDisplayLength = len(apiTokenPrefix) + 6
+
+// GenerateAPIToken returns a new token, the start of it to show in token
+// listings and the hash to store.
+func GenerateAPIToken() (token string, displayPrefix string, hash string, err error) {
+	random, err := randomToken(32)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api token: %w", err)
+	}
+
+	token = apiTokenPrefix + random
+	return token, token[:apiTokenDisplayLength], HashAPIToken(token), nil
+}
+
+// HashAPIToken hashes a token for storage and lookup. Tokens are random, so
+// a plain sha256 is enough, unlike with passwords.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether s has the shape of a personal access token.
+func IsAPIToken(s string) bool {
+	return strings.HasPrefix(s, apiTokenPrefix) && len(s) > apiTokenDisplayLength
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+const (
+	devProviderName = "dev"
+	devCodeMaxAge   = time.Minute
+)
+
+var ErrDevAuthInProduction = errors.New("the dev auth provider can't be enabled in production")
+
+// DevAuthEnabled reports whether AUTH_PROVIDER=dev asks for the dev provider.
+// It errors in production rather than quietly ignoring the setting.
+func DevAuthEnabled() (bool, error) {
+	if os.Getenv("AUTH_PROVIDER") != devProviderName {
+		return false, nil
+	}
+	if os.Getenv("ENV") == "production" {
+		return false, ErrDevAuthInProduction
+	}
+	return true, nil
+}
+
+type devUser struct {
+	Email string
+	Name  string
+	Role  string
+}
+
+// devUsers are seeded by DevIssuer.Seed, one per role.
+var devUsers = []devUser{
+	{Email: "dev-user@nazrein.local", Name: "Dev User", Role: "USER"},
+	{Email: "dev-pro@nazrein.local", Name: "Dev Pro", Role: "PRO"},
+	{Email: "dev-moderator@nazrein.local", Name: "Dev Moderator", Role: "MODERATOR"},
+	{Email: "dev-admin@nazrein.local", Name: "Dev Admin", Role: "ADMIN"},
+}
+
+type devCode struct {
+	email         string
+	redirectURI   string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+// DevIssuer stands in for an identity provider in development, so the
+// server runs without any OAuth client credentials. /auth/dev/authorize shows
+// a form to pick one of the seeded users, and the login continues like any
+// other, through the provider's callback.
+type DevIssuer struct {
+	Logger       *log.Logger
+	authorizeURL string
+
+	mu           sync.Mutex
+	codes        map[string]devCode
+	redirectURIs map[string]bool
+}
+
+func NewDevIssuer(logger *log.Logger) (*DevIssuer, error) {
+	if os.Getenv("ENV") == "production" {
+		return nil, ErrDevAuthInProduction
+	}
+
+	return &DevIssuer{
+		Logger:       logger,
+		authorizeURL: fmt.Sprintf("%s/auth/dev/authorize", os.Getenv("NEXT_PUBLIC_BACKEND_URL")),
+		codes:        map[string]devCode{},
+		redirectURIs: map[string]bool{},
+	}, nil
+}
+
+// Provider returns a login provider that calls back to redirectURL.
+func (d *DevIssuer) Provider(redirectURL string) Provider {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.redirectURIs[redirectURL] = true
+	return &devProvider{issuer: d, redirectURL: redirectURL}
+}
+
+// Seed creates the dev users that don't exist yet. Existing ones keep their
+// role, in case it was changed on purpose.
+func (d *DevIssuer) Seed(userStore store.UserStore, identityStore store.IdentityStore) error {
+	for _, du := range devUsers {
+		identity, err := identityStore.GetIdentity(devProviderName, du.Email)
+		if err != nil {
+			return err
+		}
+		if identity != nil {
+			continue
+		}
+
+		user, err := userStore.GetUserByEmail(du.Email)
+		if err != nil {
+			return err
+		}
+
+		if user != nil {
+			err = identityStore.CreateIdentity(&store.UserIdentity{UserID: user.ID, Provider: devProviderName, Subject: du.Email, Email: du.Email})
+		} else {
+			user = &models.User{Name: du.Name, Email: du.Email, Role: du.Role}
+			err = identityStore.CreateUserWithIdentity(user, &store.UserIdentity{Provider: devProviderName, Subject: du.Email, Email: du.Email})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to seed dev user %s: %w", du.Email, err)
+		}
+
+		d.Logger.Printf("Seeded dev user %s (%s)", du.Email, du.Role)
+	}
+
+	return nil
+}
+
+var devAuthorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Nazrein dev login</title></head>
+<body style="font-family: sans-serif; max-width: 32rem; margin: 4rem auto">
+<h1>Dev login</h1>
+<p>Pick who to log in as. This page only exists with AUTH_PROVIDER=dev.</p>
+{{range .Users}}
+<form method="post" style="margin-bottom: 0.5rem">
+<input type="hidden" name="state" value="{{$.State}}">
+<input type="hidden" name="redirect_uri" value="{{$.RedirectURI}}">
+<input type="hidden" name="code_challenge" value="{{$.CodeChallenge}}">
+<input type="hidden" name="email" value="{{.Email}}">
+<button type="submit">{{.Name}} &middot; {{.Role}} &middot; {{.Email}}</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// Authorize shows the login form on GET and, on POST, sends the browser back
+// to the callback with a one time code for the picked user.
+func (d *DevIssuer) Authorize(w http.ResponseWriter, r *http.Request) {
+	// checked again per request, in case the issuer got wired up anyway
+	if os.Getenv("ENV") == "production" {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Not Found"})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	state := r.Form.Get("state")
+	redirectURI := r.Form.Get("redirect_uri")
+	codeChallenge := r.Form.Get("code_challenge")
+
+	d.mu.Lock()
+	knownRedirect := d.redirectURIs[redirectURI]
+	d.mu.Unlock()
+
+	if state == "" || codeChallenge == "" || !knownRedirect {
+		d.Logger.Printf("Error: dev authorize with redirect_uri %q", redirectURI)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := devAuthorizeTemplate.Execute(w, map[string]interface{}{
+			"Users":         devUsers,
+			"State":         state,
+			"RedirectURI":   redirectURI,
+			"CodeChallenge": codeChallenge,
+		})
+		if err != nil {
+			d.Logger.Println("Error rendering dev login form", err)
+		}
+		return
+	}
+
+	email := r.Form.Get("email")
+	if !isDevUser(email) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		d.Logger.Println("Error generating dev code", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	d.mu.Lock()
+	for c, dc := range d.codes {
+		if time.Now().After(dc.expiresAt) {
+			delete(d.codes, c)
+		}
+	}
+	d.codes[code] = devCode{
+		email:         email,
+		redirectURI:   redirectURI,
+		codeChallenge: codeChallenge,
+		expiresAt:     time.Now().Add(devCodeMaxAge),
+	}
+	d.mu.Unlock()
+
+	query := url.Values{"code": {code}, "state": {state}}
+	http.Redirect(w, r, redirectURI+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+// redeem exchanges a code once, checking it was issued for redirectURI and
+// the PKCE verifier of the login.
+func (d *DevIssuer) redeem(code string, redirectURI string, verifier string) (string, error) {
+	d.mu.Lock()
+	dc, ok := d.codes[code]
+	delete(d.codes, code)
+	d.mu.Unlock()
+
+	if !ok || time.Now().After(dc.expiresAt) {
+		return "", errors.New("unknown or expired dev code")
+	}
+	if dc.redirectURI != redirectURI {
+		return "", errors.New("dev code was issued for another redirect uri")
+	}
+	if subtle.ConstantTimeCompare([]byte(s256Challenge(verifier)), []byte(dc.codeChallenge)) != 1 {
+		return "", errors.New("dev code verifier mismatch")
+	}
+
+	return dc.email, nil
+}
+
+func isDevUser(email string) bool {
+	for _, du := range devUsers {
+		if du.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func s256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type devProvider struct {
+	issuer      *DevIssuer
+	redirectURL string
+}
+
+func (p *devProvider) Name() string {
+	return devProviderName
+}
+
+func (p *devProvider) AuthCodeURL(ctx context.Context, state string, verifier string, nonce string) (string, error) {
+	query := url.Values{
+		"state":          {state},
+		"redirect_uri":   {p.redirectURL},
+		"code_challenge": {s256Challenge(verifier)},
+	}
+	return p.issuer.authorizeURL + "?" + query.Encode(), nil
+}
+
+func (p *devProvider) Identify(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	email, err := p.issuer.redeem(code, p.redirectURL, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, du := range devUsers {
+		if du.Email == email {
+			return &Identity{
+				Provider:      devProviderName,
+				Subject:       du.Email,
+				Email:         du.Email,
+				EmailVerified: true,
+				Name:          du.Name,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown dev user %s", email)
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+type gitHubProvider struct {
+	config *oauth2.Config
+}
+
+func newGitHubProvider(clientID string, clientSecret string, redirectURL string) *gitHubProvider {
+	return &gitHubProvider{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint:     github.Endpoint,
+		},
+	}
+}
+
+func (p *gitHubProvider) Name() string {
+	return "github"
+}
+
+func (p *gitHubProvider) AuthCodeURL(ctx context.Context, state string, verifier string, nonce string) (string, error) {
+	return p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Identify reads the user's profile. The email is the primary one from
+// /user/emails, since the profile only has the public email, if any.
+func (p *gitHubProvider) Identify(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange github code: %w", err)
+	}
+
+	client := p.config.Client(ctx, token)
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return nil, fmt.Errorf("failed to get github user: %w", err)
+	}
+	if user.ID == 0 {
+		return nil, errors.New("github user has no id")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, fmt.Errorf("failed to get github user emails: %w", err)
+	}
+
+	identity := &Identity{
+		Provider: p.Name(),
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+		Image:    user.AvatarURL,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+			break
+		}
+	}
+
+	return identity, nil
+}
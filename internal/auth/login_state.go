@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/oauth2"
+)
+
+const loginStateMaxAge = 10 * time.Minute
+
+var ErrInvalidLoginState = errors.New("invalid oauth login state")
+
+// loginState ties a callback to the login that started it. State is echoed
+// back by the provider, Verifier is the PKCE code verifier, Nonce ends up in
+// OIDC ID tokens and ReturnTo is where to send the user once they're logged
+// in. LinkUserID is set when a logged in user links another provider.
+type loginState struct {
+	State      string
+	Verifier   string
+	Nonce      string
+	Provider   string
+	ReturnTo   string
+	LinkUserID string
+	ExpiresAt  time.Time
+}
+
+// loginStateCookie keeps the loginState of a pending login in a short lived
+// cookie, signed and encrypted with the keys of the session store it belongs
+// to.
+type loginStateCookie struct {
+	name   string
+	codecs []securecookie.Codec
+	secure bool
+}
+
+// The codecs are shared with the session store, so their max age is left
+// alone and the expiry is kept in the loginState itself.
+func newLoginStateCookie(name string, store *ServerSessionStore) *loginStateCookie {
+	return &loginStateCookie{
+		name:   name,
+		codecs: store.Codecs,
+		secure: store.Options != nil && store.Options.Secure,
+	}
+}
+
+// begin stores ls with a fresh state, verifier and nonce, and returns it.
+func (c *loginStateCookie) begin(w http.ResponseWriter, ls loginState) (*loginState, error) {
+	var err error
+
+	ls.State, err = randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate state: %w", err)
+	}
+
+	ls.Nonce, err = randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ls.Verifier = oauth2.GenerateVerifier()
+	ls.ExpiresAt = time.Now().Add(loginStateMaxAge)
+
+	encoded, err := securecookie.EncodeMulti(c.name, &ls, c.codecs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode login state: %w", err)
+	}
+
+	http.SetCookie(w, c.cookie(encoded, int(loginStateMaxAge.Seconds())))
+	return &ls, nil
+}
+
+// finish reads and clears the loginState, and checks it against the state
+// the provider sent back to the callback.
+func (c *loginStateCookie) finish(w http.ResponseWriter, r *http.Request) (*loginState, error) {
+	http.SetCookie(w, c.cookie("", -1))
+
+	cookie, err := r.Cookie(c.name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing cookie", ErrInvalidLoginState)
+	}
+
+	var ls loginState
+	if err := securecookie.DecodeMulti(c.name, cookie.Value, &ls, c.codecs...); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLoginState, err)
+	}
+
+	if time.Now().After(ls.ExpiresAt) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidLoginState)
+	}
+
+	state := r.URL.Query().Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(ls.State)) != 1 {
+		return nil, fmt.Errorf("%w: state mismatch", ErrInvalidLoginState)
+	}
+
+	return &ls, nil
+}
+
+func (c *loginStateCookie) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.name,
+		Value:    value,
+		Path:     "/auth",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.secure,
+		// Lax still sends the cookie on the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// returnToAllowlist decides where a login may send the user afterwards.
+// Paths are resolved against the frontend, absolute urls must be on the
+// frontend's origin or one of ALLOWED_ORIGINS.
+type returnToAllowlist struct {
+	frontendURL string
+	defaultPath string
+	origins     map[string]bool
+}
+
+func newReturnToAllowlist(frontendURL string, defaultPath string) *returnToAllowlist {
+	origins := map[string]bool{}
+	if origin := originOf(frontendURL); origin != "" {
+		origins[origin] = true
+	}
+	for _, allowed := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin := originOf(strings.TrimSpace(allowed)); origin != "" {
+			origins[origin] = true
+		}
+	}
+
+	return &returnToAllowlist{
+		frontendURL: strings.TrimRight(frontendURL, "/"),
+		defaultPath: defaultPath,
+		origins:     origins,
+	}
+}
+
+// resolve returns the url to redirect to for returnTo. An empty returnTo
+// means the default path, anything not allowed is reported with ok false.
+func (a *returnToAllowlist) resolve(returnTo string) (string, bool) {
+	if returnTo == "" {
+		return a.frontendURL + a.defaultPath, true
+	}
+
+	// backslashes are treated as slashes by some browsers, so /\evil.com
+	// would leave the frontend
+	if strings.Contains(returnTo, `\`) {
+		return "", false
+	}
+
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return "", false
+	}
+
+	if !u.IsAbs() {
+		if u.Host != "" || !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+			return "", false
+		}
+		return a.frontendURL + returnTo, true
+	}
+
+	if !a.origins[originOf(returnTo)] {
+		return "", false
+	}
+	return u.String(), true
+}
+
+func originOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// idTokenLeeway allows for clock skew between us and the provider.
+	idTokenLeeway = time.Minute
+	// jwksRefreshInterval limits how often an unknown key id refetches the
+	// provider's keys.
+	jwksRefreshInterval = time.Minute
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// oidcProvider logs in with any OpenID Connect provider. The endpoints come
+// from the issuer's discovery document, fetched on first use so that the
+// server starts even when the provider is down.
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	httpClient   *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]jwk
+	keysFetchedAt time.Time
+}
+
+// jwk is a signing key of the provider. algorithm is the alg the provider
+// published the key for, empty when it didn't say.
+type jwk struct {
+	key       crypto.PublicKey
+	algorithm string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      audience     `json:"aud"`
+	AuthorizedBy  string       `json:"azp"`
+	ExpiresAt     int64        `json:"exp"`
+	IssuedAt      int64        `json:"iat"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Name          string       `json:"name"`
+	Picture       string       `json:"picture"`
+}
+
+// audience is a JWT aud claim, a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flexibleBool reads a boolean some providers send as a string.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = flexibleBool(v)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*b = flexibleBool(s == "true")
+	return nil
+}
+
+func newOIDCProvider(name string, issuer string, clientID string, clientSecret string, redirectURL string, scopes []string) *oidcProvider {
+	return &oidcProvider{
+		name:         name,
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		httpClient:   &http.Client{Timeout: providerRequestTimeout},
+	}
+}
+
+func (p *oidcProvider) Name() string {
+	return p.name
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state string, verifier string, nonce string) (string, error) {
+	config, _, err := p.config(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Identify reads the identity from the verified ID token, asking the
+// userinfo endpoint when the token has no email.
+func (p *oidcProvider) Identify(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	config, discovery, err := p.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.httpClient), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange %s code: %w", p.name, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: %s returned no id token", ErrInvalidIDToken, p.name)
+	}
+
+	claims, err := p.verifyIDToken(ctx, discovery, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Email == "" && discovery.UserinfoEndpoint != "" {
+		var userInfo oidcClaims
+		err := getJSON(ctx, config.Client(ctx, token), discovery.UserinfoEndpoint, &userInfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s user info: %w", p.name, err)
+		}
+		// the userinfo response is only trusted for the token's subject
+		if userInfo.Subject != claims.Subject {
+			return nil, fmt.Errorf("%s user info is for another subject", p.name)
+		}
+		claims.Email = userInfo.Email
+		claims.EmailVerified = userInfo.EmailVerified
+		if claims.Name == "" {
+			claims.Name = userInfo.Name
+		}
+		if claims.Picture == "" {
+			claims.Picture = userInfo.Picture
+		}
+	}
+
+	return &Identity{
+		Provider:      p.name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		Image:         claims.Picture,
+	}, nil
+}
+
+func (p *oidcProvider) config(ctx context.Context) (*oauth2.Config, *oidcDiscovery, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  p.redirectURL,
+		Scopes:       p.scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+	}, discovery, nil
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	err := getJSON(ctx, p.httpClient, p.issuer+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s discovery document: %w", p.name, err)
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("%s discovery document is for issuer %q, expected %q", p.name, discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery document is missing endpoints", p.name)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// verifyIDToken checks the token's signature against the provider's keys,
+// and that it was issued by the provider, to us, recently, for this login.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, rawIDToken string, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidIDToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidIDToken, err)
+	}
+
+	key, err := p.publicKey(ctx, discovery.JWKSURI, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyJWTSignature(header.Algorithm, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var claims oidcClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidIDToken, err)
+	}
+
+	now := time.Now()
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != p.issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.clientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.clientID:
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, claims.AuthorizedBy)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// publicKey returns the provider's key with id kid, refetching the keys when
+// it isn't known, as happens after the provider rotates them.
+func (p *oidcProvider) publicKey(ctx context.Context, jwksURI string, kid string) (jwk, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < jwksRefreshInterval {
+		return jwk{}, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	keys, err := fetchJWKS(ctx, p.httpClient, jwksURI)
+	p.keysFetchedAt = time.Now()
+	if err != nil {
+		return jwk{}, fmt.Errorf("failed to get %s keys: %w", p.name, err)
+	}
+	p.keys = keys
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return jwk{}, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+}
+
+// lookupKey finds kid among the keys. Tokens without a kid are accepted when
+// the provider has a single key.
+func (p *oidcProvider) lookupKey(kid string) (jwk, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func fetchJWKS(ctx context.Context, client *http.Client, url string) (map[string]jwk, error) {
+	var jwks struct {
+		Keys []struct {
+			KeyType   string `json:"kty"`
+			KeyID     string `json:"kid"`
+			Use       string `json:"use"`
+			Algorithm string `json:"alg"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+			Curve     string `json:"crv"`
+			X         string `json:"x"`
+			Y         string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, client, url, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]jwk{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.KeyType {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			key := &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+			keys[k.KeyID] = jwk{key: key, algorithm: k.Algorithm}
+
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Curve {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				continue
+			}
+			keys[k.KeyID] = jwk{key: key, algorithm: k.Algorithm}
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature supports the asymmetric algorithms providers sign ID
+// tokens with. none and the HMAC algorithms are rejected. The algorithm has
+// to fit the key: its type, its curve for ES algorithms, and the alg the
+// provider published it for, if any.
+func verifyJWTSignature(algorithm string, k jwk, signed string, signature []byte) error {
+	if k.algorithm != "" && k.algorithm != algorithm {
+		return fmt.Errorf("algorithm %q doesn't match the key's %q", algorithm, k.algorithm)
+	}
+
+	var hash crypto.Hash
+	var curve elliptic.Curve
+	switch algorithm {
+	case "RS256", "PS256":
+		hash = crypto.SHA256
+	case "RS384", "PS384":
+		hash = crypto.SHA384
+	case "RS512", "PS512":
+		hash = crypto.SHA512
+	case "ES256":
+		hash, curve = crypto.SHA256, elliptic.P256()
+	case "ES384":
+		hash, curve = crypto.SHA384, elliptic.P384()
+	default:
+		return fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		switch algorithm[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, signature, nil)
+		}
+
+	case *ecdsa.PublicKey:
+		if curve == nil || key.Curve.Params().Name != curve.Params().Name {
+			break
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("malformed signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("algorithm %q doesn't match the key", algorithm)
+}
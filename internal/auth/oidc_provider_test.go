@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSignedPart = "eyJhbGciOiJ0ZXN0In0.eyJzdWIiOiJ0ZXN0In0"
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	p256 *ecdsa.PrivateKey
+	p384 *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating rsa key: %v", err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating P-256 key: %v", err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating P-384 key: %v", err)
+	}
+
+	return testKeys{rsa: rsaKey, p256: p256, p384: p384}
+}
+
+// signJWT signs signed the way algorithm does, with hash as its digest. The
+// hash is separate so keys can sign with a digest their curve isn't meant
+// for, as a forged token would.
+func signJWT(t *testing.T, algorithm string, hash crypto.Hash, key crypto.Signer, signed string) []byte {
+	t.Helper()
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var signature []byte
+		var err error
+		if strings.HasPrefix(algorithm, "PS") {
+			signature, err = rsa.SignPSS(rand.Reader, key, hash, digest, nil)
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		}
+		if err != nil {
+			t.Fatalf("signing with rsa: %v", err)
+		}
+		return signature
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatalf("signing with ecdsa: %v", err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature
+	}
+
+	t.Fatalf("unsupported test key %T", key)
+	return nil
+}
+
+func TestVerifyJWTSignature(t *testing.T) {
+	keys := newTestKeys(t)
+
+	tests := []struct {
+		name string
+		// the token's alg header
+		algorithm string
+		// how the token was actually signed
+		hash   crypto.Hash
+		signer crypto.Signer
+		// the key it is verified with, and the alg it was published for
+		key          crypto.PublicKey
+		keyAlgorithm string
+		valid        bool
+	}{
+		{name: "RS256", algorithm: "RS256", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey, valid: true},
+		{name: "RS512", algorithm: "RS512", hash: crypto.SHA512, signer: keys.rsa, key: &keys.rsa.PublicKey, valid: true},
+		{name: "PS256", algorithm: "PS256", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey, valid: true},
+		{name: "ES256 with P-256", algorithm: "ES256", hash: crypto.SHA256, signer: keys.p256, key: &keys.p256.PublicKey, valid: true},
+		{name: "ES384 with P-384", algorithm: "ES384", hash: crypto.SHA384, signer: keys.p384, key: &keys.p384.PublicKey, valid: true},
+		{name: "alg matching the published alg", algorithm: "RS256", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey, keyAlgorithm: "RS256", valid: true},
+
+		{name: "ES256 with P-384", algorithm: "ES256", hash: crypto.SHA256, signer: keys.p384, key: &keys.p384.PublicKey},
+		{name: "ES384 with P-256", algorithm: "ES384", hash: crypto.SHA384, signer: keys.p256, key: &keys.p256.PublicKey},
+		{name: "ES256 with an rsa key", algorithm: "ES256", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey},
+		{name: "RS256 with an ec key", algorithm: "RS256", hash: crypto.SHA256, signer: keys.p256, key: &keys.p256.PublicKey},
+		{name: "PS256 with a key published for RS256", algorithm: "PS256", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey, keyAlgorithm: "RS256"},
+		{name: "ES256 with a key published for ES384", algorithm: "ES256", hash: crypto.SHA256, signer: keys.p256, key: &keys.p256.PublicKey, keyAlgorithm: "ES384"},
+		{name: "none", algorithm: "none", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey},
+		{name: "HS256", algorithm: "HS256", hash: crypto.SHA256, signer: keys.rsa, key: &keys.rsa.PublicKey},
+		{name: "signed by another key", algorithm: "ES256", hash: crypto.SHA256, signer: keys.p256, key: &keys.p384.PublicKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature := signJWT(t, tt.algorithm, tt.hash, tt.signer, testSignedPart)
+
+			err := verifyJWTSignature(tt.algorithm, jwk{key: tt.key, algorithm: tt.keyAlgorithm}, testSignedPart, signature)
+			if tt.valid && err != nil {
+				t.Errorf("verifyJWTSignature error: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("verifyJWTSignature accepted the signature")
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSignatureRejectsTampering(t *testing.T) {
+	keys := newTestKeys(t)
+	signature := signJWT(t, "ES256", crypto.SHA256, keys.p256, testSignedPart)
+
+	err := verifyJWTSignature("ES256", jwk{key: &keys.p256.PublicKey}, testSignedPart+"x", signature)
+	if err == nil {
+		t.Errorf("verifyJWTSignature accepted a tampered token")
+	}
+}
+
+func base64URLInt(n *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
+
+func testJWKS(keys testKeys, algorithms map[string]string) map[string]interface{} {
+	rsaKey := map[string]interface{}{
+		"kty": "RSA",
+		"kid": "rsa",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(keys.rsa.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(keys.rsa.E)).Bytes()),
+	}
+	p256 := map[string]interface{}{
+		"kty": "EC",
+		"kid": "p256",
+		"crv": "P-256",
+		"x":   base64URLInt(keys.p256.X, 32),
+		"y":   base64URLInt(keys.p256.Y, 32),
+	}
+	p384 := map[string]interface{}{
+		"kty": "EC",
+		"kid": "p384",
+		"crv": "P-384",
+		"x":   base64URLInt(keys.p384.X, 48),
+		"y":   base64URLInt(keys.p384.Y, 48),
+	}
+
+	jwks := []map[string]interface{}{rsaKey, p256, p384}
+	for _, key := range jwks {
+		if alg, ok := algorithms[key["kid"].(string)]; ok {
+			key["alg"] = alg
+		}
+	}
+
+	return map[string]interface{}{"keys": jwks}
+}
+
+func encodeJWTPart(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encoding token part: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	keys := newTestKeys(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(testJWKS(keys, map[string]string{"rsa": "RS256"}))
+	}))
+	defer server.Close()
+
+	const (
+		issuer   = "https://issuer.example.com"
+		clientID = "client"
+		nonce    = "nonce"
+	)
+
+	claims := map[string]interface{}{
+		"iss":   issuer,
+		"sub":   "user",
+		"aud":   clientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		kid       string
+		hash      crypto.Hash
+		signer    crypto.Signer
+		valid     bool
+	}{
+		{name: "RS256 with the rsa key", algorithm: "RS256", kid: "rsa", hash: crypto.SHA256, signer: keys.rsa, valid: true},
+		{name: "ES256 with the P-256 key", algorithm: "ES256", kid: "p256", hash: crypto.SHA256, signer: keys.p256, valid: true},
+		{name: "ES384 with the P-384 key", algorithm: "ES384", kid: "p384", hash: crypto.SHA384, signer: keys.p384, valid: true},
+		{name: "ES256 with the P-384 key", algorithm: "ES256", kid: "p384", hash: crypto.SHA256, signer: keys.p384},
+		{name: "PS256 with the key published for RS256", algorithm: "PS256", kid: "rsa", hash: crypto.SHA256, signer: keys.rsa},
+		{name: "unknown key", algorithm: "ES256", kid: "other", hash: crypto.SHA256, signer: keys.p256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newOIDCProvider("test", issuer, clientID, "secret", "https://app.example.com/callback", nil)
+			discovery := &oidcDiscovery{Issuer: issuer, JWKSURI: server.URL}
+
+			signed := encodeJWTPart(t, map[string]string{"alg": tt.algorithm, "kid": tt.kid}) + "." + encodeJWTPart(t, claims)
+			signature := signJWT(t, tt.algorithm, tt.hash, tt.signer, signed)
+			token := signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+			got, err := p.verifyIDToken(context.Background(), discovery, token, nonce)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("verifyIDToken error: %v", err)
+				}
+				if got.Subject != "user" {
+					t.Errorf("verifyIDToken subject %q, want %q", got.Subject, "user")
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("verifyIDToken error %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
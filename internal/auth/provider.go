@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const providerRequestTimeout = 10 * time.Second
+
+// Identity is who the provider says logged in. Subject is the user's stable
+// id at the provider, the email can change.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Image         string
+}
+
+// Provider is an identity provider users log in with. The state, PKCE
+// verifier and nonce come from the loginState of the login.
+type Provider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, state string, verifier string, nonce string) (string, error)
+	Identify(ctx context.Context, code string, verifier string, nonce string) (*Identity, error)
+}
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// reservedProviderNames are paths under /auth that aren't logins.
+var reservedProviderNames = map[string]bool{
+	"admin":     true,
+	"user":      true,
+	"logout":    true,
+	"providers": true,
+}
+
+// LoadProviders builds the user login providers from the environment:
+//
+//	GOOGLE_CLIENT_ID_USER, GOOGLE_CLIENT_SECRET_USER
+//	GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET
+//	OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, and optionally OIDC_NAME
+//	(default oidc) and OIDC_SCOPES (default "openid email profile")
+//
+// A provider is enabled when its client id is set. Callbacks are at
+// /auth/{name}/callback on NEXT_PUBLIC_BACKEND_URL. devIssuer, when not nil,
+// adds the dev provider.
+func LoadProviders(devIssuer *DevIssuer) ([]Provider, error) {
+	backendURL := os.Getenv("NEXT_PUBLIC_BACKEND_URL")
+	callbackURL := func(name string) string {
+		return fmt.Sprintf("%s/auth/%s/callback", backendURL, name)
+	}
+
+	var providers []Provider
+
+	if devIssuer != nil {
+		providers = append(providers, devIssuer.Provider(callbackURL(devProviderName)))
+	}
+
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID_USER"); clientID != "" {
+		providers = append(providers, newGoogleProvider(clientID, os.Getenv("GOOGLE_CLIENT_SECRET_USER"), callbackURL("google")))
+	}
+
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		providers = append(providers, newGitHubProvider(clientID, os.Getenv("GITHUB_CLIENT_SECRET"), callbackURL("github")))
+	}
+
+	if clientID := os.Getenv("OIDC_CLIENT_ID"); clientID != "" {
+		name := os.Getenv("OIDC_NAME")
+		if name == "" {
+			name = "oidc"
+		}
+		if !providerNamePattern.MatchString(name) || reservedProviderNames[name] || name == "google" || name == "github" || name == devProviderName {
+			return nil, fmt.Errorf("OIDC_NAME %q is not a usable provider name", name)
+		}
+
+		issuer := os.Getenv("OIDC_ISSUER")
+		if issuer == "" {
+			return nil, errors.New("OIDC_ISSUER is required with OIDC_CLIENT_ID")
+		}
+
+		scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers = append(providers, newOIDCProvider(name, issuer, clientID, os.Getenv("OIDC_CLIENT_SECRET"), callbackURL(name), scopes))
+	}
+
+	if len(providers) == 0 {
+		return nil, errors.New("no login providers configured")
+	}
+
+	return providers, nil
+}
+
+type googleProvider struct {
+	config *oauth2.Config
+}
+
+func newGoogleProvider(clientID string, clientSecret string, redirectURL string) *googleProvider {
+	return &googleProvider{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.profile", "https://www.googleapis.com/auth/userinfo.email"},
+			Endpoint:     google.Endpoint,
+		},
+	}
+}
+
+func (p *googleProvider) Name() string {
+	return "google"
+}
+
+func (p *googleProvider) AuthCodeURL(ctx context.Context, state string, verifier string, nonce string) (string, error) {
+	return p.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *googleProvider) Identify(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange google code: %w", err)
+	}
+
+	var userInfo struct {
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+
+	err = getJSON(ctx, p.config.Client(ctx, token), "https://www.googleapis.com/oauth2/v2/userinfo", &userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get google user info: %w", err)
+	}
+	if userInfo.ID == "" {
+		return nil, errors.New("google user info has no id")
+	}
+
+	return &Identity{
+		Provider:      p.Name(),
+		Subject:       userInfo.ID,
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.VerifiedEmail,
+		Name:          userInfo.Name,
+		Image:         userInfo.Picture,
+	}, nil
+}
+
+// getJSON decodes the JSON response of a GET to url into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, providerRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
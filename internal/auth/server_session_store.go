@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written, so
+// authenticated requests don't all turn into writes.
+const sessionTouchInterval = time.Minute
+
+const (
+	UserSessionName  = "nazrein_session"
+	AdminSessionName = "nazrein_admin_session"
+)
+
+var sessionIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ServerSessionStore is a sessions.Store keeping session values in postgres.
+// The cookie only carries the signed session id, so deleting a session's row
+// logs it out. UserIDKey names the session value holding the user's id, so
+// sessions can be listed and revoked per user. Proxies are the ones whose
+// forwarding headers give the address shown for a session.
+type ServerSessionStore struct {
+	Codecs    []securecookie.Codec
+	Options   *sessions.Options
+	UserIDKey string
+	Proxies   utils.TrustedProxies
+
+	sessionStore store.SessionStore
+}
+
+func NewServerSessionStore(sessionStore store.SessionStore, userIDKey string, proxies utils.TrustedProxies, options *sessions.Options, keyPairs ...[]byte) *ServerSessionStore {
+	s := &ServerSessionStore{
+		Codecs:       securecookie.CodecsFromPairs(keyPairs...),
+		Options:      options,
+		UserIDKey:    userIDKey,
+		Proxies:      proxies,
+		sessionStore: sessionStore,
+	}
+
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+
+	return s
+}
+
+func (s *ServerSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A missing, revoked
+// or expired session comes back as a new, empty one.
+func (s *ServerSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	stored, err := s.sessionStore.GetSession(id, name)
+	if err != nil {
+		return session, err
+	}
+	if stored == nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, stored.Data, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	session.ID = stored.ID
+	session.IsNew = false
+
+	if time.Since(stored.LastSeenAt) > sessionTouchInterval {
+		if err := s.sessionStore.TouchSession(stored.ID, r.UserAgent(), s.Proxies.ClientIP(r)); err != nil {
+			return session, err
+		}
+	}
+
+	return session, nil
+}
+
+// Save writes the session to postgres and its id to the cookie. A negative
+// MaxAge deletes the session.
+func (s *ServerSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.sessionStore.DeleteSession(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode session values: %w", err)
+	}
+
+	stored := &store.Session{
+		ID:        session.ID,
+		Name:      session.Name(),
+		UserID:    s.userID(session),
+		Data:      data,
+		UserAgent: r.UserAgent(),
+		IPAddress: s.Proxies.ClientIP(r),
+		ExpiresAt: time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+
+	updated := false
+	if stored.ID != "" {
+		updated, err = s.sessionStore.UpdateSession(stored)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sessions revoked since they were loaded get a new id rather than
+	// coming back
+	if !updated {
+		stored.ID = sessionIDEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+		if err := s.sessionStore.CreateSession(stored); err != nil {
+			return err
+		}
+		session.ID = stored.ID
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode session id: %w", err)
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Renew drops the session's row and empties it, so the next Save issues a
+// new id. Logins renew the session, so a session id planted in the browser
+// before login never becomes the logged in one.
+func (s *ServerSessionStore) Renew(session *sessions.Session) error {
+	if session.ID != "" {
+		if err := s.sessionStore.DeleteSession(session.ID); err != nil {
+			return err
+		}
+	}
+
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+	session.ID = ""
+	session.IsNew = true
+
+	return nil
+}
+
+func (s *ServerSessionStore) userID(session *sessions.Session) *uuid.UUID {
+	idStr, ok := session.Values[s.UserIDKey].(string)
+	if !ok {
+		return nil
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+var ErrNoSessionKeys = errors.New("no session keys configured")
+
+// SessionKeyPair signs and encrypts session cookies. Stores are built from a
+// list of pairs: cookies are written with the first pair and read with any
+// of them, so a key is rotated by putting a new pair in front and dropping
+// the old one once the sessions it wrote have expired.
+type SessionKeyPair struct {
+	HashKey       []byte
+	EncryptionKey []byte
+}
+
+func GenerateSessionKeyPair() SessionKeyPair {
+	return SessionKeyPair{
+		HashKey:       securecookie.GenerateRandomKey(64),
+		EncryptionKey: securecookie.GenerateRandomKey(32),
+	}
+}
+
+// String encodes the pair the way ParseSessionKeyPairs reads it, as
+// base64(hash key):base64(encryption key).
+func (p SessionKeyPair) String() string {
+	return base64.StdEncoding.EncodeToString(p.HashKey) + ":" + base64.StdEncoding.EncodeToString(p.EncryptionKey)
+}
+
+// ParseSessionKeyPairs reads pairs separated by commas or newlines, newest
+// first. Blank lines and lines starting with # are skipped.
+func ParseSessionKeyPairs(s string) ([]SessionKeyPair, error) {
+	var pairs []SessionKeyPair
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+
+			pair, err := parseSessionKeyPair(field)
+			if err != nil {
+				return nil, fmt.Errorf("session key pair %d: %w", len(pairs)+1, err)
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+
+	if len(pairs) == 0 {
+		return nil, ErrNoSessionKeys
+	}
+
+	return pairs, nil
+}
+
+func parseSessionKeyPair(s string) (SessionKeyPair, error) {
+	hashKey, encryptionKey, ok := strings.Cut(s, ":")
+	if !ok {
+		return SessionKeyPair{}, errors.New("expected hash key and encryption key separated by ':'")
+	}
+
+	var pair SessionKeyPair
+	var err error
+
+	pair.HashKey, err = base64.StdEncoding.DecodeString(hashKey)
+	if err != nil {
+		return SessionKeyPair{}, fmt.Errorf("invalid hash key: %w", err)
+	}
+	if len(pair.HashKey) < 32 {
+		return SessionKeyPair{}, fmt.Errorf("hash key must be at least 32 bytes, got %d", len(pair.HashKey))
+	}
+
+	pair.EncryptionKey, err = base64.StdEncoding.DecodeString(encryptionKey)
+	if err != nil {
+		return SessionKeyPair{}, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	switch len(pair.EncryptionKey) {
+	case 16, 24, 32:
+	default:
+		return SessionKeyPair{}, fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", len(pair.EncryptionKey))
+	}
+
+	return pair, nil
+}
+
+// LoadSessionKeyPairs reads the pairs from the name environment variable or,
+// when that is empty, from the file named by name_FILE, which is how docker
+// secrets are mounted.
+func LoadSessionKeyPairs(name string) ([]SessionKeyPair, error) {
+	if value := os.Getenv(name); value != "" {
+		pairs, err := ParseSessionKeyPairs(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return pairs, nil
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return nil, fmt.Errorf("%s: %w", name, ErrNoSessionKeys)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+
+	pairs, err := ParseSessionKeyPairs(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return pairs, nil
+}
+
+// KeyPairs flattens pairs into the arguments NewServerSessionStore takes.
+func KeyPairs(pairs []SessionKeyPair) [][]byte {
+	keys := make([][]byte, 0, len(pairs)*2)
+	for _, pair := range pairs {
+		keys = append(keys, pair.HashKey, pair.EncryptionKey)
+	}
+	return keys
+}
@@ -2,59 +2,121 @@ package auth
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/gorilla/sessions"
 	"github.com/grvbrk/nazrein_server/internal/models"
 	"github.com/grvbrk/nazrein_server/internal/store"
 	"github.com/grvbrk/nazrein_server/internal/utils"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
+// maxUserNameLength is the size of users.name.
+const maxUserNameLength = 50
+
 type Oauth interface {
 	Login(w http.ResponseWriter, r *http.Request)
 	Logout(w http.ResponseWriter, r *http.Request)
 	Callback(w http.ResponseWriter, r *http.Request)
 }
 
-type GoogleOauth struct {
-	Logger    *log.Logger
-	Config    *oauth2.Config
-	Store     *sessions.CookieStore
-	UserStore *store.PostgresUserStore
+// UserOauth logs users in with any of the configured providers, picked by
+// the {provider} url parameter.
+type UserOauth struct {
+	Logger        *log.Logger
+	Store         *ServerSessionStore
+	UserStore     store.UserStore
+	IdentityStore store.IdentityStore
+	Providers     map[string]Provider
+
+	providerNames []string
+	loginStates   *loginStateCookie
+	returnTo      *returnToAllowlist
 }
 
-func NewGoogleOauth(logger *log.Logger, store *sessions.CookieStore, userStore *store.PostgresUserStore) (*GoogleOauth, error) {
-
-	return &GoogleOauth{
-		Logger: logger,
-		Config: &oauth2.Config{
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID_USER"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET_USER"),
-			RedirectURL:  fmt.Sprintf("%s/auth/google/callback", os.Getenv("NEXT_PUBLIC_BACKEND_URL")),
-			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.profile", "https://www.googleapis.com/auth/userinfo.email"},
-			Endpoint:     google.Endpoint,
-		},
-		Store:     store,
-		UserStore: userStore,
+func NewUserOauth(logger *log.Logger, sessionStore *ServerSessionStore, userStore store.UserStore, identityStore store.IdentityStore, providers []Provider) (*UserOauth, error) {
+	byName := make(map[string]Provider, len(providers))
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		if _, ok := byName[provider.Name()]; ok {
+			return nil, fmt.Errorf("login provider %s is configured twice", provider.Name())
+		}
+		byName[provider.Name()] = provider
+		names = append(names, provider.Name())
+	}
+
+	return &UserOauth{
+		Logger:        logger,
+		Store:         sessionStore,
+		UserStore:     userStore,
+		IdentityStore: identityStore,
+		Providers:     byName,
+		providerNames: names,
+		loginStates:   newLoginStateCookie("nazrein_oauth_state", sessionStore),
+		returnTo:      newReturnToAllowlist(os.Getenv("FRONTEND_URL"), "/dashboard"),
 	}, nil
 }
 
-func (g *GoogleOauth) Login(w http.ResponseWriter, r *http.Request) {
-	url := g.Config.AuthCodeURL("random-state-string", oauth2.AccessTypeOffline)
+// ListProviders returns the names of the providers users can log in with.
+func (g *UserOauth) ListProviders(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": g.providerNames})
+}
+
+// Login starts a login with the provider. return_to is optional, see
+// returnToAllowlist for what it may point at. With link=true a logged in
+// user links the provider account to their account instead.
+func (g *UserOauth) Login(w http.ResponseWriter, r *http.Request) {
+	provider, ok := g.Providers[chi.URLParam(r, "provider")]
+	if !ok {
+		g.Logger.Printf("Error: unknown login provider %q", chi.URLParam(r, "provider"))
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Not Found"})
+		return
+	}
+
+	returnTo, ok := g.returnTo.resolve(r.URL.Query().Get("return_to"))
+	if !ok {
+		g.Logger.Printf("Error: return_to %q is not allowed", r.URL.Query().Get("return_to"))
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	state := loginState{Provider: provider.Name(), ReturnTo: returnTo}
+
+	if r.URL.Query().Get("link") == "true" {
+		userID, ok := g.sessionUserID(r)
+		if !ok {
+			g.Logger.Println("Error: linking a provider without a session")
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+			return
+		}
+		state.LinkUserID = userID
+	}
+
+	loginState, err := g.loginStates.begin(w, state)
+	if err != nil {
+		g.Logger.Println("Error starting user login", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	url, err := provider.AuthCodeURL(r.Context(), loginState.State, loginState.Verifier, loginState.Nonce)
+	if err != nil {
+		g.Logger.Println("Error building login url", err)
+		utils.WriteJSON(w, http.StatusBadGateway, utils.Envelope{"Error": "Bad Gateway"})
+		return
+	}
+
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
-func (g *GoogleOauth) Logout(w http.ResponseWriter, r *http.Request) {
-	session, _ := g.Store.Get(r, "nazrein_session")
+func (g *UserOauth) Logout(w http.ResponseWriter, r *http.Request) {
+	session, _ := g.Store.Get(r, UserSessionName)
 
 	for key := range session.Values {
 		delete(session.Values, key)
@@ -71,90 +133,229 @@ func (g *GoogleOauth) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 }
 
-func (g *GoogleOauth) Callback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	token, err := g.Config.Exchange(context.Background(), code)
+func (g *UserOauth) Callback(w http.ResponseWriter, r *http.Request) {
+	loginState, err := g.loginStates.finish(w, r)
 	if err != nil {
-		g.Logger.Println("Error exchanging user token", err)
+		g.Logger.Println("Error verifying user login state", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	provider, ok := g.Providers[chi.URLParam(r, "provider")]
+	if !ok || provider.Name() != loginState.Provider {
+		g.Logger.Printf("Error: callback of %q for a login with %q", chi.URLParam(r, "provider"), loginState.Provider)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Bad Request"})
+		return
+	}
+
+	identity, err := provider.Identify(context.Background(), r.URL.Query().Get("code"), loginState.Verifier, loginState.Nonce)
+	if err != nil {
+		g.Logger.Printf("Error identifying %s user: %v", provider.Name(), err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 		return
 	}
 
-	client := g.Config.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	if loginState.LinkUserID != "" {
+		g.link(w, r, identity, loginState)
+		return
+	}
+
+	user, err := g.resolveUser(identity)
+	if errors.Is(err, errEmailTaken) {
+		g.Logger.Printf("Error: %s login with the email of an existing account", provider.Name())
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": "An account with this email already exists, log in and link " + provider.Name() + " from your account instead"})
+		return
+	}
+	if errors.Is(err, errNoEmail) {
+		g.Logger.Printf("Error: %s login without an email", provider.Name())
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Your " + provider.Name() + " account has no email address"})
+		return
+	}
 	if err != nil {
-		g.Logger.Println("Error getting user info", err)
+		g.Logger.Println("Error resolving user", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	session, _ := g.Store.Get(r, UserSessionName)
+	if err := g.Store.Renew(session); err != nil {
+		g.Logger.Println("Error renewing session", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 		return
 	}
 
-	defer resp.Body.Close()
+	session.Values["user_id"] = user.ID.String()
+	session.Values["user_email"] = user.Email
+	session.Values["user_image"] = identity.Image
+	if identity.Image == "" {
+		session.Values["user_image"] = user.ImageSrc
+	}
+	session.Values["user_name"] = user.Name
+	session.Values["user_role"] = user.Role
 
-	var userInfo struct {
-		GoogleID string `json:"id"`
-		Email    string `json:"email"`
-		Name     string `json:"name"`
-		Image    string `json:"picture"`
+	err = session.Save(r, w)
+	if err != nil {
+		g.Logger.Println("Error saving session", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
 	}
-	err = json.NewDecoder(resp.Body).Decode(&userInfo)
+
+	http.Redirect(w, r, loginState.ReturnTo, http.StatusSeeOther)
+}
+
+var (
+	errEmailTaken = errors.New("email belongs to another account")
+	errNoEmail    = errors.New("provider returned no email")
+)
+
+// resolveUser finds the user the identity belongs to. A new identity is
+// linked to the account with the same email when the provider verified it,
+// and signs up a new user when there's no such account.
+func (g *UserOauth) resolveUser(identity *Identity) (*models.User, error) {
+	existing, err := g.IdentityStore.GetIdentity(identity.Provider, identity.Subject)
 	if err != nil {
-		g.Logger.Println("Error decoding user info", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Interal Server Error"})
+		return nil, err
 	}
 
-	var userID string
-	var userRole string
-	user, err := g.UserStore.GetUserByGoogleID(userInfo.GoogleID)
+	if existing != nil {
+		if err := g.IdentityStore.TouchIdentity(existing.ID, identity.Email); err != nil {
+			g.Logger.Println("Error updating identity last login:", err)
+		}
 
-	if user == nil || err == sql.ErrNoRows {
-		newUser := models.User{
-			GoogleID: userInfo.GoogleID,
-			Name:     userInfo.Name,
-			Email:    userInfo.Email,
-			ImageSrc: userInfo.Image,
-			Role:     "USER",
+		user, err := g.UserStore.GetUserByID(existing.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("identity %s has no user", existing.ID)
 		}
+		return user, nil
+	}
 
-		err = g.UserStore.CreateUser(&newUser)
+	if identity.Email == "" {
+		return nil, errNoEmail
+	}
+
+	user, err := g.UserStore.GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil {
+		// an unverified email could be anyone's, so it doesn't get the account
+		if !identity.EmailVerified {
+			return nil, errEmailTaken
+		}
+
+		err := g.IdentityStore.CreateIdentity(&store.UserIdentity{
+			UserID:   user.ID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		})
+		if errors.Is(err, store.ErrIdentityTaken) {
+			// the account already has another account of this provider
+			return nil, errEmailTaken
+		}
 		if err != nil {
-			g.Logger.Println("Error creating user", err)
-			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
-			return
+			return nil, err
 		}
+		return user, nil
+	}
+
+	user = &models.User{
+		Name:     newUserName(identity),
+		Email:    identity.Email,
+		ImageSrc: identity.Image,
+		Role:     "USER",
+	}
+
+	err = g.IdentityStore.CreateUserWithIdentity(user, &store.UserIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		userID = newUser.ID.String()
-		userRole = "USER"
-	} else {
-		userID = user.ID.String()
-		userRole = user.Role
+	return user, nil
+}
+
+// link adds the identity to the account of the logged in user, who must
+// still be the one that started the login.
+func (g *UserOauth) link(w http.ResponseWriter, r *http.Request, identity *Identity, loginState *loginState) {
+	userIDStr, ok := g.sessionUserID(r)
+	if !ok || userIDStr != loginState.LinkUserID {
+		g.Logger.Println("Error: session changed while linking a provider")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+		return
 	}
 
-	if err != nil && err != sql.ErrNoRows {
-		g.Logger.Println("Error getting user by google id", err)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		g.Logger.Println("Invalid user ID format in session:", err)
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+		return
+	}
+
+	existing, err := g.IdentityStore.GetIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		g.Logger.Println("Error getting identity", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 		return
 	}
 
-	session, _ := g.Store.Get(r, "nazrein_session")
-	session.Values["user_id"] = userID
-	session.Values["user_email"] = userInfo.Email
-	session.Values["user_image"] = userInfo.Image
-	session.Values["user_name"] = userInfo.Name
-	session.Values["user_role"] = userRole
+	if existing == nil {
+		err = g.IdentityStore.CreateIdentity(&store.UserIdentity{
+			UserID:   userID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		})
+	} else if existing.UserID != userID {
+		err = store.ErrIdentityTaken
+	}
 
-	err = session.Save(r, w)
+	if errors.Is(err, store.ErrIdentityTaken) {
+		g.Logger.Printf("Error: %s account is already linked", identity.Provider)
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": "This " + identity.Provider + " account is linked to another user, or you already linked another one"})
+		return
+	}
 	if err != nil {
-		g.Logger.Println("Error saving session", err)
+		g.Logger.Println("Error linking identity", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 		return
 	}
 
-	redirectURL := os.Getenv("FRONTEND_URL") + "/dashboard"
-	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	http.Redirect(w, r, loginState.ReturnTo, http.StatusSeeOther)
+}
+
+func (g *UserOauth) sessionUserID(r *http.Request) (string, bool) {
+	session, err := g.Store.Get(r, UserSessionName)
+	if err != nil || session.IsNew {
+		return "", false
+	}
+
+	userID, ok := session.Values["user_id"].(string)
+	return userID, ok && userID != ""
+}
+
+// newUserName picks a name that fits users.name for a new user.
+func newUserName(identity *Identity) string {
+	name := strings.TrimSpace(identity.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	if utf8.RuneCountInString(name) > maxUserNameLength {
+		name = string([]rune(name)[:maxUserNameLength])
+	}
+	return name
 }
 
-func (g *GoogleOauth) AuthUser(w http.ResponseWriter, r *http.Request) {
-	user, err := g.Store.Get(r, "nazrein_session")
+func (g *UserOauth) AuthUser(w http.ResponseWriter, r *http.Request) {
+	user, err := g.Store.Get(r, UserSessionName)
 	if err != nil {
 		g.Logger.Println("Error getting session", err)
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authenticated"})
@@ -167,7 +368,8 @@ func (g *GoogleOauth) AuthUser(w http.ResponseWriter, r *http.Request) {
 	userImage, imageOk := user.Values["user_image"].(string)
 	userRole, roleOk := user.Values["user_role"].(string)
 
-	if !emailOk || !idOk || !nameOk || !imageOk || !roleOk || userEmail == "" || userIDStr == "" || userName == "" || userImage == "" || userRole == "" {
+	// not every provider has a picture, so the image may be empty
+	if !emailOk || !idOk || !nameOk || !imageOk || !roleOk || userEmail == "" || userIDStr == "" || userName == "" || userRole == "" {
 		g.Logger.Println("Invalid or missing user data in session")
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authenticated"})
 		return
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/grvbrk/nazrein_server/internal/auth"
+)
+
+// Run runs the admin command named by args[0], as in `nazrein keygen`.
+func Run(args []string, out io.Writer) error {
+	switch args[0] {
+	case "keygen":
+		return keygen(args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %q, available commands: keygen", args[0])
+	}
+}
+
+// keygen prints new session key pairs as environment variables. With -rotate
+// the new pair is put in front of the configured ones, so sessions written
+// with the old keys keep working until they expire.
+func keygen(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	flags.SetOutput(out)
+	rotate := flags.Bool("rotate", false, "keep the currently configured keys after the new pair")
+	keep := flags.Int("keep", 1, "how many of the current key pairs to keep with -rotate")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keep < 0 {
+		return errors.New("-keep can't be negative")
+	}
+
+	for _, name := range []string{"SESSION_KEYS", "ADMIN_SESSION_KEYS"} {
+		pairs := []auth.SessionKeyPair{auth.GenerateSessionKeyPair()}
+
+		if *rotate {
+			current, err := auth.LoadSessionKeyPairs(name)
+			if err != nil && !errors.Is(err, auth.ErrNoSessionKeys) {
+				return err
+			}
+			if len(current) > *keep {
+				current = current[:*keep]
+			}
+			pairs = append(pairs, current...)
+		}
+
+		encoded := make([]string, len(pairs))
+		for i, pair := range pairs {
+			encoded[i] = pair.String()
+		}
+
+		fmt.Fprintf(out, "%s=%s\n", name, strings.Join(encoded, ","))
+	}
+
+	return nil
+}
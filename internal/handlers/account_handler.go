@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/grvbrk/nazrein_server/internal/middlewares"
+	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+// AccountDeletionGracePeriod is how long a deleted account is kept before
+// the account_deletion job deletes it for good.
+const AccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+type AccountHandler struct {
+	UserStore         store.UserStore
+	IdentityStore     store.IdentityStore
+	VideoStore        store.VideoStore
+	VideoRequestStore store.VideoRequestStore
+	BookmarkStore     store.BookmarkStore
+	SessionStore      store.SessionStore
+	APITokenStore     store.APITokenStore
+	Logger            *log.Logger
+}
+
+func NewAccountHandler(
+	userStore store.UserStore,
+	identityStore store.IdentityStore,
+	videoStore store.VideoStore,
+	videoRequestStore store.VideoRequestStore,
+	bookmarkStore store.BookmarkStore,
+	sessionStore store.SessionStore,
+	apiTokenStore store.APITokenStore,
+	logger *log.Logger,
+) *AccountHandler {
+	return &AccountHandler{
+		UserStore:         userStore,
+		IdentityStore:     identityStore,
+		VideoStore:        videoStore,
+		VideoRequestStore: videoRequestStore,
+		BookmarkStore:     bookmarkStore,
+		SessionStore:      sessionStore,
+		APITokenStore:     apiTokenStore,
+		Logger:            logger,
+	}
+}
+
+// accountExport is everything stored about a user. Each field is a file of
+// the zip export.
+type accountExport struct {
+	Profile    *models.User          `json:"profile"`
+	Identities []store.UserIdentity  `json:"identities"`
+	Requests   []models.VideoRequest `json:"requests"`
+	Videos     []models.Video        `json:"videos"`
+	Bookmarks  []models.Bookmark     `json:"bookmarks"`
+}
+
+// HandlerExportAccount sends the user's data as a zip of JSON files, or as a
+// single JSON document with ?format=json.
+func (ah *AccountHandler) HandlerExportAccount(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		ah.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "zip" && format != "json" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "format must be zip or json"})
+		return
+	}
+
+	export, err := ah.exportAccount(user)
+	if err != nil {
+		ah.Logger.Println("Error exporting account", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	filename := fmt.Sprintf("nazrein-export-%s", time.Now().UTC().Format("2006-01-02"))
+
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": export})
+		return
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"identities.json", export.Identities},
+		{"requests.json", export.Requests},
+		{"videos.json", export.Videos},
+		{"bookmarks.json", export.Bookmarks},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	// the status is sent, an error from here on can only be logged
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			ah.Logger.Println("Error writing account export", err)
+			return
+		}
+
+		encoder := json.NewEncoder(fw)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			ah.Logger.Println("Error writing account export", err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		ah.Logger.Println("Error writing account export", err)
+	}
+}
+
+func (ah *AccountHandler) exportAccount(user *models.User) (*accountExport, error) {
+	identities, err := ah.IdentityStore.GetIdentitiesByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := ah.VideoRequestStore.GetAllVideoRequestByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if requests == nil {
+		requests = []models.VideoRequest{}
+	}
+
+	videos, err := ah.VideoStore.GetVideosByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmarks, err := ah.BookmarkStore.GetBookmarksByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountExport{
+		Profile:    user,
+		Identities: identities,
+		Requests:   requests,
+		Videos:     videos,
+		Bookmarks:  bookmarks,
+	}, nil
+}
+
+// HandlerDeleteAccount schedules the user's account for deletion after the
+// grace period. API tokens and the user's other sessions are revoked right
+// away, the current session stays so the deletion can still be cancelled.
+func (ah *AccountHandler) HandlerDeleteAccount(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		ah.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	err := ah.UserStore.ScheduleUserDeletion(user.ID, time.Now().Add(AccountDeletionGracePeriod))
+	if err != nil {
+		ah.Logger.Println("Error scheduling account deletion", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if _, err := ah.APITokenStore.DeleteAPITokensByUserID(user.ID); err != nil {
+		ah.Logger.Println("Error revoking api tokens", err)
+	}
+
+	currentID, _ := middlewares.GetSessionIDFromContext(r)
+	if _, err := ah.SessionStore.DeleteUserSessions(user.ID, currentID); err != nil {
+		ah.Logger.Println("Error revoking sessions", err)
+	}
+
+	// read back, an earlier request may have set an earlier date
+	user, err = ah.UserStore.GetUserByID(user.ID)
+	if err != nil || user == nil {
+		ah.Logger.Println("Error getting user", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"data": map[string]interface{}{"delete_after": user.Delete_After}})
+}
+
+// HandlerRestoreAccount cancels a scheduled deletion, while its grace period
+// lasts.
+func (ah *AccountHandler) HandlerRestoreAccount(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		ah.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	cancelled, err := ah.UserStore.CancelUserDeletion(user.ID)
+	if err != nil {
+		ah.Logger.Println("Error cancelling account deletion", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if !cancelled {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "No deletion scheduled, or its grace period is over"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Success"})
+}
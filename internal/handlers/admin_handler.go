@@ -2,220 +2,468 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/grvbrk/nazrein_server/internal/auth"
+	"github.com/grvbrk/nazrein_server/internal/middlewares"
 	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/services"
+	"github.com/grvbrk/nazrein_server/internal/store"
 	"github.com/grvbrk/nazrein_server/internal/store/admin"
 	"github.com/grvbrk/nazrein_server/internal/utils"
 )
 
-type YouTubeResponse struct {
-	Items []struct {
-		Snippet struct {
-			PublishedAt time.Time `json:"publishedAt"`
-			ChannelId   string    `json:"channelId"`
-			Title       string    `json:"title"`
-			Description string    `json:"description"`
-			Thumbnails  struct {
-				Default struct {
-					URL    string `json:"url"`
-					Width  int    `json:"width"`
-					Height int    `json:"height"`
-				} `json:"default"`
-				Medium struct {
-					URL    string `json:"url"`
-					Width  int    `json:"width"`
-					Height int    `json:"height"`
-				} `json:"medium"`
-				High struct {
-					URL    string `json:"url"`
-					Width  int    `json:"width"`
-					Height int    `json:"height"`
-				} `json:"high"`
-				Standard struct {
-					URL    string `json:"url"`
-					Width  int    `json:"width"`
-					Height int    `json:"height"`
-				} `json:"standard"`
-				Maxres struct {
-					URL    string `json:"url"`
-					Width  int    `json:"width"`
-					Height int    `json:"height"`
-				} `json:"maxres"`
-			} `json:"thumbnails"`
-			ChannelTitle string `json:"channelTitle"`
-		} `json:"snippet"`
-	} `json:"items"`
-}
-
 type AdminHandler struct {
 	AdminVideoStore        admin.AdminVideoStore
 	AdminUserStore         admin.AdminUserStore
 	AdminVideoRequestStore admin.AdminVideoRequestStore
+	YouTubeClient          *services.YouTubeClient
 	Logger                 *log.Logger
 	Oauth                  *auth.AdminGoogleOauth
+	Proxies                utils.TrustedProxies
 }
 
-func NewAdminHandler(adminVideoStore admin.AdminVideoStore, adminUserStore admin.AdminUserStore, adminVideoRequestStore admin.AdminVideoRequestStore, logger *log.Logger, oauth *auth.AdminGoogleOauth) *AdminHandler {
+func NewAdminHandler(adminVideoStore admin.AdminVideoStore, adminUserStore admin.AdminUserStore, adminVideoRequestStore admin.AdminVideoRequestStore, youtubeClient *services.YouTubeClient, logger *log.Logger, oauth *auth.AdminGoogleOauth, proxies utils.TrustedProxies) *AdminHandler {
 	return &AdminHandler{
 		AdminVideoStore:        adminVideoStore,
 		AdminUserStore:         adminUserStore,
 		AdminVideoRequestStore: adminVideoRequestStore,
+		YouTubeClient:          youtubeClient,
 		Logger:                 logger,
 		Oauth:                  oauth,
+		Proxies:                proxies,
 	}
 }
 
+const (
+	defaultVideoRequestQueueLimit = 50
+	maxVideoRequestQueueLimit     = 200
+)
+
+// HandlerGetVideoRequests pages through the request queue, the requests
+// waiting for a decision oldest first unless filtered otherwise. Pass the
+// returned next_cursor as cursor for the next page.
 func (ah *AdminHandler) HandlerGetVideoRequests(w http.ResponseWriter, r *http.Request) {
-	responseArr, err := ah.AdminVideoStore.GetAllVideoRequest()
+	params, err := parseVideoRequestQueryParams(r)
 	if err != nil {
-		ah.Logger.Println("Error fetching all video requests", err)
+		ah.Logger.Printf("Error: invalid video request queue parameter: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": err.Error()})
+		return
+	}
+
+	page, err := ah.AdminVideoRequestStore.GetVideoRequests(params)
+	if err != nil {
+		ah.Logger.Println("Error fetching video requests", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"data": responseArr})
+	var nextCursor *string
+	if page.NextCursor != nil {
+		cursor := page.NextCursor.Encode()
+		nextCursor = &cursor
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": page.Requests, "next_cursor": nextCursor, "counts": page.Counts})
+
+}
+
+// parseVideoRequestQueryParams reads the queue's query parameters:
+//
+//	status      comma separated statuses, or "all"; PENDING,UNDER_REVIEW by default
+//	user_id     the requester
+//	channel_id  the channel of the video
+//	since       requests made at or after, RFC 3339 or a date
+//	until       requests made before
+//	sort        oldest (default) or newest
+//	cursor      next_cursor of the previous page
+//	limit       1 to 200, 50 by default
+func parseVideoRequestQueryParams(r *http.Request) (admin.VideoRequestQueryParams, error) {
+	q := r.URL.Query()
+
+	params := admin.VideoRequestQueryParams{
+		Statuses:  admin.DefaultVideoRequestStatuses,
+		ChannelID: q.Get("channel_id"),
+		Sort:      admin.VideoRequestSortOldest,
+		Limit:     defaultVideoRequestQueueLimit,
+	}
+
+	if value := q.Get("status"); value == "all" {
+		params.Statuses = nil
+	} else if value != "" {
+		params.Statuses = nil
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !admin.ValidateVideoRequestStatus(status) {
+				return params, fmt.Errorf("unknown status %q", status)
+			}
+			params.Statuses = append(params.Statuses, status)
+		}
+	}
+
+	if value := q.Get("user_id"); value != "" {
+		userID, err := uuid.Parse(value)
+		if err != nil {
+			return params, fmt.Errorf("user_id must be a uuid, got %q", value)
+		}
+		params.UserID = &userID
+	}
+
+	timeParams := []struct {
+		name string
+		dest **time.Time
+	}{
+		{"since", &params.Since},
+		{"until", &params.Until},
+	}
+
+	for _, tp := range timeParams {
+		value := q.Get(tp.name)
+		if value == "" {
+			continue
+		}
+
+		t, err := parseTimeParam(value)
+		if err != nil {
+			return params, fmt.Errorf("%s: %w", tp.name, err)
+		}
+		*tp.dest = &t
+	}
+
+	switch value := q.Get("sort"); value {
+	case "":
+	case admin.VideoRequestSortOldest, admin.VideoRequestSortNewest:
+		params.Sort = value
+	default:
+		return params, fmt.Errorf("sort must be oldest or newest, got %q", value)
+	}
 
+	if value := q.Get("cursor"); value != "" {
+		cursor, err := admin.DecodeVideoRequestCursor(value)
+		if err != nil {
+			return params, err
+		}
+		params.Cursor = cursor
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxVideoRequestQueueLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d, got %q", maxVideoRequestQueueLimit, value)
+		}
+		params.Limit = limit
+	}
+
+	return params, nil
 }
 
+// HandlerApproveVideoRequest accepts a request and starts tracking its video.
+// The request is given by id, in the path or as request_id in the body, and
+// everything else is read from the stored request. Approving an accepted
+// request again succeeds without doing anything.
 func (ah *AdminHandler) HandlerApproveVideoRequest(w http.ResponseWriter, r *http.Request) {
 
-	type Request struct {
-		UserID    string `json:"user_id"`
-		RequestID string `json:"request_id"`
-		Link      string `json:"link"`
-		YoutubeID string `json:"youtube_id"`
+	actor, ok := auditActor(r, ah.Proxies)
+	if !ok {
+		ah.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
 	}
 
-	var req Request
-	err := json.NewDecoder(r.Body).Decode(&req)
+	rid := chi.URLParam(r, "request_id")
+	if rid == "" {
+		type Request struct {
+			RequestID string `json:"request_id"`
+		}
+
+		var req Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			ah.Logger.Println("Error decoding request body:", err)
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+			return
+		}
+		rid = req.RequestID
+	}
+
+	requestID, err := uuid.Parse(rid)
 	if err != nil {
-		ah.Logger.Println("Error decoding request body:", err)
+		ah.Logger.Println("Error parsing request id", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
 		return
 	}
 
-	userID, err := uuid.Parse(req.UserID)
+	videoRequest, err := ah.AdminVideoRequestStore.GetVideoRequestByID(requestID)
+	if errors.Is(err, admin.ErrVideoRequestNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Video request not found"})
+		return
+	}
 	if err != nil {
-		ah.Logger.Println("Error parsing user id", err)
+		ah.Logger.Println("Error fetching video request", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
 
-	user, err := ah.AdminUserStore.GetUserByID(userID)
-	if err != nil {
-		ah.Logger.Println("Error fetching user", err)
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Bad Request"})
+	if videoRequest.Status == models.VideoRequestStatusAccepted {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Already approved"})
 		return
 	}
 
-	if user.Role == "USER" && user.Videos_Tracked >= 3 {
-		ah.Logger.Println("User has reached track limit")
-		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "User has reached track limit"})
+	ytVideos, err := ah.YouTubeClient.GetVideos([]string{videoRequest.Youtube_ID})
+	if err != nil {
+		ah.Logger.Println("Error fetching video from youtube v3 api", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
 
-	apiKey := os.Getenv("YOUTUBE_API_KEY")
-	if apiKey == "" {
-		ah.Logger.Println("Error: YOUTUBE_API_KEY environment variable is not set")
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+	ytVideo, ok := ytVideos[videoRequest.Youtube_ID]
+	if !ok {
+		ah.Logger.Println("No video found.")
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"message": "Video not found on YouTube"})
 		return
 	}
 
-	url := fmt.Sprintf(
-		"https://youtube.googleapis.com/youtube/v3/videos?part=snippet&id=%s&key=%s",
-		req.YoutubeID, apiKey,
-	)
+	details := models.Video{
+		Published_At:  ytVideo.Snippet.PublishedAt,
+		Title:         ytVideo.Snippet.Title,
+		Description:   ytVideo.Snippet.Description,
+		Thumbnail:     ytVideo.Snippet.Thumbnails.High.URL,
+		Channel_Title: ytVideo.Snippet.ChannelTitle,
+		Channel_ID:    ytVideo.Snippet.ChannelId,
+		Language:      ytVideo.Language(),
+	}
 
-	resp, err := http.Get(url)
+	approved, err := ah.AdminVideoStore.ApproveVideoRequest(requestID, &details, actor)
+	if errors.Is(err, admin.ErrVideoRequestNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Video request not found"})
+		return
+	}
+	if errors.Is(err, admin.ErrInvalidVideoRequestTransition) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrTrackLimitReached) {
+		ah.Logger.Println("User has reached track limit")
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "User has reached track limit"})
+		return
+	}
 	if err != nil {
-		ah.Logger.Println("Error fetching video from youtube v3 api", err)
+		ah.Logger.Println("Error approving video request:", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
 
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		ah.Logger.Printf("Non-OK response: %d %s\n", resp.StatusCode, resp.Status)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+	if !approved {
+		// approved by someone else in the meantime
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Already approved"})
 		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		ah.Logger.Printf("Failed to read response: %v\n", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
-		return
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"message": "Success"})
+
+}
+
+// Outcomes of the requests of a bulk action.
+const (
+	bulkResultOK               = "ok"
+	bulkResultAlreadyProcessed = "already_processed"
+	bulkResultNotFound         = "not_found"
+	bulkResultLimitReached     = "limit_reached"
+	bulkResultYouTubeError     = "youtube_error"
+	bulkResultError            = "error"
+)
+
+type bulkVideoRequestResult struct {
+	RequestID uuid.UUID `json:"request_id"`
+	Result    string    `json:"result"`
+}
+
+// HandlerBulkVideoRequests approves or rejects a batch of requests. Each
+// request is changed in its own transaction, so one failing doesn't hold up
+// the rest, and the response has the outcome of each. The videos to approve
+// are fetched from YouTube in a single call.
+func (ah *AdminHandler) HandlerBulkVideoRequests(w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		RequestIDs      []string `json:"request_ids"`
+		Action          string   `json:"action"`
+		RejectionReason string   `json:"rejection_reason"`
 	}
 
-	var ytResp YouTubeResponse
-	if err := json.Unmarshal(body, &ytResp); err != nil {
-		ah.Logger.Printf("Failed to decode JSON: %v\n", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+	actor, ok := auditActor(r, ah.Proxies)
+	if !ok {
+		ah.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
 		return
 	}
 
-	if len(ytResp.Items) == 0 {
-		ah.Logger.Println("No video found.")
+	var req Request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ah.Logger.Println("Error decoding request body:", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
 		return
 	}
 
-	video := models.Video{
-		Link:          req.Link,
-		Youtube_ID:    req.YoutubeID,
-		Published_At:  ytResp.Items[0].Snippet.PublishedAt,
-		Title:         ytResp.Items[0].Snippet.Title,
-		Description:   ytResp.Items[0].Snippet.Description,
-		Thumbnail:     ytResp.Items[0].Snippet.Thumbnails.High.URL,
-		Channel_Title: ytResp.Items[0].Snippet.ChannelTitle,
-		Channel_ID:    ytResp.Items[0].Snippet.ChannelId,
-		User_ID:       userID,
-		Is_Active:     true,
-		Created_At:    time.Now(),
-		Updated_At:    time.Now(),
+	if req.Action != "approve" && req.Action != "reject" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "action must be approve or reject"})
+		return
 	}
 
-	user_uuid, err := uuid.Parse(req.UserID)
-	if err != nil {
-		ah.Logger.Println("Error parsing user id", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+	reason := strings.TrimSpace(req.RejectionReason)
+	if req.Action == "reject" && reason == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": admin.ErrRejectionReasonRequired.Error()})
 		return
 	}
-
-	requestID, err := uuid.Parse(req.RequestID)
-	if err != nil {
-		ah.Logger.Println("Error parsing request id", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+	if req.Action == "approve" && reason != "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": admin.ErrRejectionReasonNotAllowed.Error()})
 		return
 	}
 
-	err = ah.AdminVideoStore.CreateVideo(&video, user_uuid, requestID)
-	if err != nil {
-		ah.Logger.Println("Error creating video in store:", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+	if len(req.RequestIDs) == 0 || len(req.RequestIDs) > services.YouTubeMaxBatchSize {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": fmt.Sprintf("request_ids must have 1 to %d ids", services.YouTubeMaxBatchSize)})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"message": "Success"})
+	var requestIDs []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for _, rid := range req.RequestIDs {
+		requestID, err := uuid.Parse(rid)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": fmt.Sprintf("invalid request id %q", rid)})
+			return
+		}
+		if !seen[requestID] {
+			seen[requestID] = true
+			requestIDs = append(requestIDs, requestID)
+		}
+	}
+
+	results := make([]bulkVideoRequestResult, len(requestIDs))
+	videoRequests := map[uuid.UUID]*admin.AdminVideoRequest{}
+	var youtubeIDs []string
+
+	for i, requestID := range requestIDs {
+		results[i].RequestID = requestID
+
+		videoRequest, err := ah.AdminVideoRequestStore.GetVideoRequestByID(requestID)
+		if errors.Is(err, admin.ErrVideoRequestNotFound) {
+			results[i].Result = bulkResultNotFound
+			continue
+		}
+		if err != nil {
+			ah.Logger.Println("Error fetching video request", err)
+			results[i].Result = bulkResultError
+			continue
+		}
+
+		if videoRequest.Status == models.VideoRequestStatusAccepted || videoRequest.Status == models.VideoRequestStatusRejected {
+			results[i].Result = bulkResultAlreadyProcessed
+			continue
+		}
+
+		videoRequests[requestID] = videoRequest
+		youtubeIDs = append(youtubeIDs, videoRequest.Youtube_ID)
+	}
+
+	var ytVideos map[string]services.YouTubeVideo
+	if req.Action == "approve" {
+		ytVideos, err = ah.YouTubeClient.GetVideos(youtubeIDs)
+		if err != nil {
+			// every approval gets a youtube_error below
+			ah.Logger.Println("Error fetching videos from youtube v3 api", err)
+		}
+	}
+
+	for i := range results {
+		videoRequest, ok := videoRequests[results[i].RequestID]
+		if !ok {
+			continue
+		}
+
+		if req.Action == "reject" {
+			update := admin.VideoRequestUpdate{
+				Status:          models.VideoRequestStatusRejected,
+				RejectionReason: &reason,
+				UpdatedAt:       videoRequest.Updated_At,
+			}
+
+			_, err := ah.AdminVideoRequestStore.PatchVideoRequest(videoRequest.Id, update, actor)
+			results[i].Result = ah.bulkResult(err)
+			continue
+		}
+
+		ytVideo, ok := ytVideos[videoRequest.Youtube_ID]
+		if !ok {
+			results[i].Result = bulkResultYouTubeError
+			continue
+		}
+
+		details := models.Video{
+			Published_At:  ytVideo.Snippet.PublishedAt,
+			Title:         ytVideo.Snippet.Title,
+			Description:   ytVideo.Snippet.Description,
+			Thumbnail:     ytVideo.Snippet.Thumbnails.High.URL,
+			Channel_Title: ytVideo.Snippet.ChannelTitle,
+			Channel_ID:    ytVideo.Snippet.ChannelId,
+			Language:      ytVideo.Language(),
+		}
+
+		changed, err := ah.AdminVideoStore.ApproveVideoRequest(videoRequest.Id, &details, actor)
+		if err == nil && !changed {
+			results[i].Result = bulkResultAlreadyProcessed
+			continue
+		}
+
+		results[i].Result = ah.bulkResult(err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": results})
 
 }
 
+// bulkResult is the outcome of a request a bulk action changed with err.
+// Requests changed by someone else since they were read count as processed.
+func (ah *AdminHandler) bulkResult(err error) string {
+	switch {
+	case err == nil:
+		return bulkResultOK
+	case errors.Is(err, admin.ErrVideoRequestNotFound):
+		return bulkResultNotFound
+	case errors.Is(err, admin.ErrInvalidVideoRequestTransition), errors.Is(err, admin.ErrVideoRequestStale):
+		return bulkResultAlreadyProcessed
+	case errors.Is(err, store.ErrTrackLimitReached):
+		return bulkResultLimitReached
+	default:
+		ah.Logger.Println("Error processing video request:", err)
+		return bulkResultError
+	}
+}
+
+// HandlerUpdateVideoRequest changes a request's status or rejection reason.
+// updated_at is the request's updated_at as the admin last read it, an RFC
+// 3339 timestamp such as 2025-01-02T15:04:05.123Z, compared to the
+// millisecond. The change is refused with a 409 when the request changed
+// since, or when the status can't change that way.
 func (ah *AdminHandler) HandlerUpdateVideoRequest(w http.ResponseWriter, r *http.Request) {
 	type PatchRequest struct {
-		UserID          string `json:"user_id"`
-		Status          string `json:"status"`
-		ProcessedBy     string `json:"processed_by"`
-		RejectionReason string `json:"rejection_reason"`
+		Status          string     `json:"status"`
+		RejectionReason *string    `json:"rejection_reason"`
+		UpdatedAt       *time.Time `json:"updated_at"`
+	}
+
+	actor, ok := auditActor(r, ah.Proxies)
+	if !ok {
+		ah.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
 	}
 
 	rid := chi.URLParam(r, "request_id")
@@ -228,7 +476,7 @@ func (ah *AdminHandler) HandlerUpdateVideoRequest(w http.ResponseWriter, r *http
 	requestID, err := uuid.Parse(rid)
 	if err != nil {
 		ah.Logger.Println("Error parsing request id", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Invalid request id"})
 		return
 	}
 
@@ -240,38 +488,137 @@ func (ah *AdminHandler) HandlerUpdateVideoRequest(w http.ResponseWriter, r *http
 		return
 	}
 
-	userID, err := uuid.Parse(req.UserID)
+	if req.UpdatedAt == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "updated_at is required"})
+		return
+	}
+
+	if req.Status != "" && !admin.ValidateVideoRequestStatus(req.Status) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Invalid status"})
+		return
+	}
+
+	update := admin.VideoRequestUpdate{
+		Status:          req.Status,
+		RejectionReason: req.RejectionReason,
+		UpdatedAt:       *req.UpdatedAt,
+	}
+
+	updatedAt, err := ah.AdminVideoRequestStore.PatchVideoRequest(requestID, update, actor)
+	if errors.Is(err, admin.ErrVideoRequestNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Video request not found"})
+		return
+	}
+	if errors.Is(err, admin.ErrInvalidVideoRequestTransition) || errors.Is(err, admin.ErrVideoRequestStale) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, admin.ErrRejectionReasonRequired) || errors.Is(err, admin.ErrRejectionReasonNotAllowed) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": err.Error()})
+		return
+	}
 	if err != nil {
-		ah.Logger.Println("Error parsing user id", err)
+		ah.Logger.Println("Error updating video request:", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
 
-	user, err := ah.AdminUserStore.GetUserByID(userID)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Success", "updated_at": updatedAt})
+
+}
+
+func (ah *AdminHandler) HandlerGetRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := ah.AdminUserStore.GetRoles()
 	if err != nil {
-		ah.Logger.Println("Error fetching user", err)
+		ah.Logger.Println("Error fetching roles", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": roles})
+}
+
+func (ah *AdminHandler) HandlerUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		Role string `json:"role"`
+	}
+
+	actor, ok := auditActor(r, ah.Proxies)
+	if !ok {
+		ah.Logger.Println("No admin found in context.")
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
 		return
 	}
 
-	if user.ID != userID {
-		ah.Logger.Println("User does not match")
-		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "Forbidden"})
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		ah.Logger.Println("Error parsing user id", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	// an admin demoting themselves could leave nobody able to manage roles
+	if userID == actor.ID {
+		ah.Logger.Println("Admin tried to change their own role")
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "You can't change your own role"})
 		return
 	}
 
-	err = ah.AdminVideoRequestStore.PatchVideoRequest(
-		requestID,
-		&req.Status,
-		&req.ProcessedBy,
-		&req.RejectionReason,
-	)
+	var req Request
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		ah.Logger.Println("Error updating video request:", err)
+		ah.Logger.Println("Error decoding request body:", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	roles, err := ah.AdminUserStore.GetRoles()
+	if err != nil {
+		ah.Logger.Println("Error fetching roles", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	validRole := false
+	for _, role := range roles {
+		if role.Name == req.Role {
+			validRole = true
+			break
+		}
+	}
+	if !validRole {
+		ah.Logger.Printf("Unknown role %q", req.Role)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Unknown role"})
+		return
+	}
+
+	updated, err := ah.AdminUserStore.UpdateUserRole(userID, req.Role, actor)
+	if err != nil {
+		ah.Logger.Println("Error updating user role", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
 
+	if !updated {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "User not found"})
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Success"})
+}
+
+// auditActor is the admin making the request, as the audit log records them.
+// Their address is only taken from forwarding headers the proxies set.
+func auditActor(r *http.Request, proxies utils.TrustedProxies) (admin.AuditActor, bool) {
+	currentAdmin, ok := middlewares.GetAdminFromContext(r)
+	if !ok {
+		return admin.AuditActor{}, false
+	}
 
+	return admin.AuditActor{
+		ID:        currentAdmin.ID,
+		Email:     currentAdmin.Email,
+		IPAddress: proxies.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}, true
 }
@@ -0,0 +1,124 @@
+package analytics
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grvbrk/nazrein_server/internal/store/analytics"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+const (
+	defaultSearchStatsDays  = 7
+	defaultSearchStatsLimit = 50
+)
+
+type AnalyticsSearchHandler struct {
+	AnalyticsSearchStore analytics.AnalyticsSearchStore
+	Logger               *log.Logger
+}
+
+func NewAnalyticsSearchHandler(analyticsSearchStore analytics.AnalyticsSearchStore, logger *log.Logger) *AnalyticsSearchHandler {
+	return &AnalyticsSearchHandler{
+		AnalyticsSearchStore: analyticsSearchStore,
+		Logger:               logger,
+	}
+}
+
+func (ah *AnalyticsSearchHandler) HandlerGetTopQueries(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchStatsParams(r)
+	if err != nil {
+		ah.Logger.Printf("Error: invalid search stats parameter: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	response, err := ah.AnalyticsSearchStore.GetTopQueries(params)
+	if err != nil {
+		ah.Logger.Println("Error getting top search queries from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": response})
+}
+
+func (ah *AnalyticsSearchHandler) HandlerGetZeroResultQueries(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchStatsParams(r)
+	if err != nil {
+		ah.Logger.Printf("Error: invalid search stats parameter: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	response, err := ah.AnalyticsSearchStore.GetZeroResultQueries(params)
+	if err != nil {
+		ah.Logger.Println("Error getting zero result search queries from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": response})
+}
+
+func (ah *AnalyticsSearchHandler) HandlerGetQueryTrends(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchStatsParams(r)
+	if err != nil {
+		ah.Logger.Printf("Error: invalid search stats parameter: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	response, err := ah.AnalyticsSearchStore.GetQueryTrends(params)
+	if err != nil {
+		ah.Logger.Println("Error getting search query trends from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": response})
+}
+
+// parseSearchStatsParams reads the optional days (1-365, default 7), limit
+// (1-500, default 50), endpoint, q and interval (hour or day) parameters.
+func parseSearchStatsParams(r *http.Request) (analytics.SearchStatsParams, error) {
+	q := r.URL.Query()
+
+	days := defaultSearchStatsDays
+	if value := q.Get("days"); value != "" {
+		d, err := strconv.Atoi(value)
+		if err != nil || d < 1 || d > 365 {
+			return analytics.SearchStatsParams{}, fmt.Errorf("days must be between 1 and 365, got %q", value)
+		}
+		days = d
+	}
+
+	limit := defaultSearchStatsLimit
+	if value := q.Get("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l < 1 || l > 500 {
+			return analytics.SearchStatsParams{}, fmt.Errorf("limit must be between 1 and 500, got %q", value)
+		}
+		limit = l
+	}
+
+	var endpoint analytics.SearchEndpoint
+	if value := q.Get("endpoint"); value != "" {
+		e, ok := analytics.ValidateSearchEndpoint(value)
+		if !ok {
+			return analytics.SearchStatsParams{}, fmt.Errorf("endpoint must be videos or autocomplete, got %q", value)
+		}
+		endpoint = e
+	}
+
+	return analytics.SearchStatsParams{
+		Since:    time.Now().AddDate(0, 0, -days),
+		Endpoint: endpoint,
+		Query:    q.Get("q"),
+		Limit:    limit,
+		Interval: analytics.ValidateTrendInterval(q.Get("interval")),
+	}, nil
+}
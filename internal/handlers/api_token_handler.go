@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/auth"
+	"github.com/grvbrk/nazrein_server/internal/middlewares"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+const maxAPITokensPerUser = 20
+
+type APITokenHandler struct {
+	APITokenStore store.APITokenStore
+	Logger        *log.Logger
+}
+
+func NewAPITokenHandler(apiTokenStore store.APITokenStore, logger *log.Logger) *APITokenHandler {
+	return &APITokenHandler{
+		APITokenStore: apiTokenStore,
+		Logger:        logger,
+	}
+}
+
+// HandlerCreateAPIToken creates a personal access token. The token itself
+// is only ever returned here.
+func (th *APITokenHandler) HandlerCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+
+	type Request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	var req Request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		th.Logger.Println("Error decoding request body in handler", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		th.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		th.Logger.Println("Error: token name must be 1 to 100 characters")
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Token name must be 1 to 100 characters"})
+		return
+	}
+
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range req.Scopes {
+		if !store.ValidateTokenScope(scope) {
+			th.Logger.Printf("Error: invalid token scope '%s'", scope)
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Invalid scope", "valid_scopes": store.TokenScopes})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		th.Logger.Println("Error: token has no scopes")
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "At least one scope is required", "valid_scopes": store.TokenScopes})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		th.Logger.Println("Error: token expiry is in the past")
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "expires_at must be in the future"})
+		return
+	}
+
+	existing, err := th.APITokenStore.GetAPITokensByUserID(user.ID)
+	if err != nil {
+		th.Logger.Println("Error getting api tokens from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+	if len(existing) >= maxAPITokensPerUser {
+		th.Logger.Println("User has reached the api token limit")
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "You can have at most 20 API tokens"})
+		return
+	}
+
+	plainToken, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		th.Logger.Println("Error generating api token", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	token := store.APIToken{
+		UserID:      user.ID,
+		Name:        req.Name,
+		TokenPrefix: prefix,
+		TokenHash:   hash,
+		Scopes:      scopes,
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	err = th.APITokenStore.CreateAPIToken(&token)
+	if err != nil {
+		th.Logger.Println("Error creating api token in store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"data": map[string]interface{}{
+		"token":     plainToken,
+		"api_token": token,
+	}})
+}
+
+func (th *APITokenHandler) HandlerGetAPITokens(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		th.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	tokens, err := th.APITokenStore.GetAPITokensByUserID(user.ID)
+	if err != nil {
+		th.Logger.Println("Error getting api tokens from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": tokens})
+}
+
+func (th *APITokenHandler) HandlerDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		th.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	tokenID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		th.Logger.Println("Error parsing api token id", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	deleted, err := th.APITokenStore.DeleteAPIToken(tokenID, user.ID)
+	if err != nil {
+		th.Logger.Println("Error deleting api token", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "API token not found"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Success"})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/store/admin"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+const defaultAuditLogLimit = 100
+
+type AuditHandler struct {
+	AuditStore admin.AdminAuditStore
+	Logger     *log.Logger
+}
+
+func NewAuditHandler(auditStore admin.AdminAuditStore, logger *log.Logger) *AuditHandler {
+	return &AuditHandler{
+		AuditStore: auditStore,
+		Logger:     logger,
+	}
+}
+
+// HandlerGetAuditLog lists admin audit entries, newest first. Pass the
+// returned next_before_id as before_id for the next page.
+func (ah *AuditHandler) HandlerGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	params, err := parseAuditQueryParams(r)
+	if err != nil {
+		ah.Logger.Printf("Error: invalid audit log parameter: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	entries, err := ah.AuditStore.GetAuditEntries(params)
+	if err != nil {
+		ah.Logger.Println("Error getting audit entries from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	var nextBeforeID *int64
+	if len(entries) == params.Limit {
+		nextBeforeID = &entries[len(entries)-1].ID
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": entries, "next_before_id": nextBeforeID})
+}
+
+// parseAuditQueryParams reads the optional actor_id, action, target_type,
+// target_id, since and until (date or RFC3339), before_id and limit (1-500,
+// default 100) parameters.
+func parseAuditQueryParams(r *http.Request) (admin.AuditQueryParams, error) {
+	q := r.URL.Query()
+
+	params := admin.AuditQueryParams{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Limit:      defaultAuditLogLimit,
+	}
+
+	if value := q.Get("actor_id"); value != "" {
+		actorID, err := uuid.Parse(value)
+		if err != nil {
+			return params, fmt.Errorf("actor_id must be a uuid, got %q", value)
+		}
+		params.ActorID = &actorID
+	}
+
+	timeParams := []struct {
+		name string
+		dest **time.Time
+	}{
+		{"since", &params.Since},
+		{"until", &params.Until},
+	}
+
+	for _, tp := range timeParams {
+		value := q.Get(tp.name)
+		if value == "" {
+			continue
+		}
+
+		t, err := parseTimeParam(value)
+		if err != nil {
+			return params, fmt.Errorf("%s: %w", tp.name, err)
+		}
+		*tp.dest = &t
+	}
+
+	if value := q.Get("before_id"); value != "" {
+		beforeID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || beforeID < 1 {
+			return params, fmt.Errorf("before_id must be a positive integer, got %q", value)
+		}
+		params.BeforeID = beforeID
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 500 {
+			return params, fmt.Errorf("limit must be between 1 and 500, got %q", value)
+		}
+		params.Limit = limit
+	}
+
+	return params, nil
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/store/admin"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+type AutoApprovalHandler struct {
+	AutoApprovalStore admin.AdminAutoApprovalStore
+	AdminUserStore    admin.AdminUserStore
+	Logger            *log.Logger
+	Proxies           utils.TrustedProxies
+}
+
+func NewAutoApprovalHandler(autoApprovalStore admin.AdminAutoApprovalStore, adminUserStore admin.AdminUserStore, logger *log.Logger, proxies utils.TrustedProxies) *AutoApprovalHandler {
+	return &AutoApprovalHandler{
+		AutoApprovalStore: autoApprovalStore,
+		AdminUserStore:    adminUserStore,
+		Logger:            logger,
+		Proxies:           proxies,
+	}
+}
+
+// HandlerGetAutoApprovalRules lists every rule, in the order they are tried.
+// With include_deleted=true deleted rules are listed too, for the rules that
+// approved past requests.
+func (aah *AutoApprovalHandler) HandlerGetAutoApprovalRules(w http.ResponseWriter, r *http.Request) {
+	includeDeleted := r.URL.Query().Get("include_deleted") == "true"
+
+	rules, err := aah.AutoApprovalStore.GetAutoApprovalRules(false, includeDeleted)
+	if err != nil {
+		aah.Logger.Println("Error fetching auto approval rules", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": rules})
+}
+
+// HandlerCreateAutoApprovalRule adds a rule. Rules are enabled unless enabled
+// is false, and tried from the highest priority down.
+func (aah *AutoApprovalHandler) HandlerCreateAutoApprovalRule(w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		Name       string                       `json:"name"`
+		Enabled    *bool                        `json:"enabled"`
+		Priority   int                          `json:"priority"`
+		Conditions admin.AutoApprovalConditions `json:"conditions"`
+	}
+
+	actor, ok := auditActor(r, aah.Proxies)
+	if !ok {
+		aah.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	var req Request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		aah.Logger.Println("Error decoding request body:", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	rule := admin.AutoApprovalRule{
+		Name:       req.Name,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+		Priority:   req.Priority,
+		Conditions: req.Conditions,
+	}
+
+	if err := aah.validateRule(&rule); err != nil {
+		aah.respondRuleError(w, err)
+		return
+	}
+
+	err = aah.AutoApprovalStore.CreateAutoApprovalRule(&rule, actor)
+	if err != nil {
+		aah.Logger.Println("Error creating auto approval rule", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"data": rule})
+}
+
+// HandlerUpdateAutoApprovalRule changes the fields of a rule that are given.
+// conditions replaces all of the rule's conditions.
+func (aah *AutoApprovalHandler) HandlerUpdateAutoApprovalRule(w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		Name       *string                       `json:"name"`
+		Enabled    *bool                         `json:"enabled"`
+		Priority   *int                          `json:"priority"`
+		Conditions *admin.AutoApprovalConditions `json:"conditions"`
+	}
+
+	actor, ok := auditActor(r, aah.Proxies)
+	if !ok {
+		aah.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	ruleID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		aah.Logger.Println("Error parsing rule id", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	var req Request
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		aah.Logger.Println("Error decoding request body:", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	rule, err := aah.AutoApprovalStore.GetAutoApprovalRule(ruleID)
+	if errors.Is(err, admin.ErrAutoApprovalRuleNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Rule not found"})
+		return
+	}
+	if err != nil {
+		aah.Logger.Println("Error fetching auto approval rule", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.Conditions != nil {
+		rule.Conditions = *req.Conditions
+	}
+
+	if err := aah.validateRule(rule); err != nil {
+		aah.respondRuleError(w, err)
+		return
+	}
+
+	err = aah.AutoApprovalStore.UpdateAutoApprovalRule(rule, actor)
+	if errors.Is(err, admin.ErrAutoApprovalRuleNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Rule not found"})
+		return
+	}
+	if err != nil {
+		aah.Logger.Println("Error updating auto approval rule", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": rule})
+}
+
+func (aah *AutoApprovalHandler) HandlerDeleteAutoApprovalRule(w http.ResponseWriter, r *http.Request) {
+	actor, ok := auditActor(r, aah.Proxies)
+	if !ok {
+		aah.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	ruleID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		aah.Logger.Println("Error parsing rule id", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	err = aah.AutoApprovalStore.DeleteAutoApprovalRule(ruleID, actor)
+	if errors.Is(err, admin.ErrAutoApprovalRuleNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Rule not found"})
+		return
+	}
+	if err != nil {
+		aah.Logger.Println("Error deleting auto approval rule", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateRule normalizes rule and errors with
+// admin.ErrAutoApprovalRuleInvalid when it isn't valid, including when it
+// names a role that doesn't exist.
+func (aah *AutoApprovalHandler) validateRule(rule *admin.AutoApprovalRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" || len(rule.Name) > 100 {
+		return fmt.Errorf("%w: name must be 1 to 100 characters", admin.ErrAutoApprovalRuleInvalid)
+	}
+
+	if err := rule.Conditions.Validate(); err != nil {
+		return err
+	}
+
+	if len(rule.Conditions.Roles) == 0 {
+		return nil
+	}
+
+	roles, err := aah.AdminUserStore.GetRoles()
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, role := range roles {
+		known[role.Name] = true
+	}
+
+	for _, role := range rule.Conditions.Roles {
+		if !known[role] {
+			return fmt.Errorf("%w: unknown role %q", admin.ErrAutoApprovalRuleInvalid, role)
+		}
+	}
+
+	return nil
+}
+
+func (aah *AutoApprovalHandler) respondRuleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, admin.ErrAutoApprovalRuleInvalid) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": err.Error()})
+		return
+	}
+
+	aah.Logger.Println("Error validating auto approval rule", err)
+	utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+}
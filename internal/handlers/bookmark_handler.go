@@ -16,11 +16,11 @@ type BookmarkHandler struct {
 	VideoStore    store.VideoStore
 	BookmarkStore store.BookmarkStore
 	UserStore     store.UserStore
-	Oauth         *auth.GoogleOauth
+	Oauth         *auth.UserOauth
 	Logger        *log.Logger
 }
 
-func NewBookmarkHandler(videoStore store.VideoStore, bookmarkStore store.BookmarkStore, userStore store.UserStore, oauth *auth.GoogleOauth, logger *log.Logger) *BookmarkHandler {
+func NewBookmarkHandler(videoStore store.VideoStore, bookmarkStore store.BookmarkStore, userStore store.UserStore, oauth *auth.UserOauth, logger *log.Logger) *BookmarkHandler {
 	return &BookmarkHandler{
 		VideoStore:    videoStore,
 		BookmarkStore: bookmarkStore,
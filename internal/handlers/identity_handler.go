@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/grvbrk/nazrein_server/internal/middlewares"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+type IdentityHandler struct {
+	IdentityStore store.IdentityStore
+	Logger        *log.Logger
+}
+
+func NewIdentityHandler(identityStore store.IdentityStore, logger *log.Logger) *IdentityHandler {
+	return &IdentityHandler{
+		IdentityStore: identityStore,
+		Logger:        logger,
+	}
+}
+
+// HandlerGetIdentities lists the providers linked to the user's account.
+// Others are linked with /auth/{provider}/login?link=true.
+func (ih *IdentityHandler) HandlerGetIdentities(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		ih.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	identities, err := ih.IdentityStore.GetIdentitiesByUserID(user.ID)
+	if err != nil {
+		ih.Logger.Println("Error getting identities from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": identities})
+}
+
+// HandlerDeleteIdentity unlinks a provider. The last one can't be unlinked,
+// the user couldn't log in anymore.
+func (ih *IdentityHandler) HandlerDeleteIdentity(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		ih.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	provider := chi.URLParam(r, "provider")
+	if provider == "" {
+		ih.Logger.Println("Error: provider parameter is missing")
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	identities, err := ih.IdentityStore.GetIdentitiesByUserID(user.ID)
+	if err != nil {
+		ih.Logger.Println("Error getting identities from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	linked := false
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			linked = true
+			break
+		}
+	}
+	if !linked {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Provider not linked"})
+		return
+	}
+
+	deleted, err := ih.IdentityStore.DeleteIdentity(user.ID, provider)
+	if err != nil {
+		ih.Logger.Println("Error deleting identity", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"message": "Can't unlink your only login provider"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Success"})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/auth"
+	"github.com/grvbrk/nazrein_server/internal/middlewares"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/store/admin"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+type SessionHandler struct {
+	SessionStore store.SessionStore
+	AuditStore   admin.AdminAuditStore
+	Logger       *log.Logger
+	Proxies      utils.TrustedProxies
+}
+
+func NewSessionHandler(sessionStore store.SessionStore, auditStore admin.AdminAuditStore, logger *log.Logger, proxies utils.TrustedProxies) *SessionHandler {
+	return &SessionHandler{
+		SessionStore: sessionStore,
+		AuditStore:   auditStore,
+		Logger:       logger,
+		Proxies:      proxies,
+	}
+}
+
+// HandlerGetSessions lists the logged in user's sessions, marking the one
+// the request was made with.
+func (sh *SessionHandler) HandlerGetSessions(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		sh.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	sessions, err := sh.SessionStore.GetSessionsByUserID(user.ID, auth.UserSessionName)
+	if err != nil {
+		sh.Logger.Println("Error getting sessions from store", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	currentID, _ := middlewares.GetSessionIDFromContext(r)
+	for i := range sessions {
+		sessions[i].IsCurrent = sessions[i].ID == currentID
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": sessions})
+}
+
+func (sh *SessionHandler) HandlerDeleteSession(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := middlewares.GetUserFromContext(r)
+	if !ok {
+		sh.Logger.Println("No user found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	sessionID := chi.URLParam(r, "id")
+	if sessionID == "" {
+		sh.Logger.Println("Error: id parameter is missing")
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	deleted, err := sh.SessionStore.DeleteUserSession(user.ID, sessionID)
+	if err != nil {
+		sh.Logger.Println("Error deleting session", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "Session not found"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Success"})
+}
+
+// HandlerRevokeUserSessions logs a user out everywhere, admin sessions
+// included. It's meant for compromised accounts and demoted admins.
+func (sh *SessionHandler) HandlerRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+
+	actor, ok := auditActor(r, sh.Proxies)
+	if !ok {
+		sh.Logger.Println("No admin found in context.")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		sh.Logger.Println("Error parsing user id", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	revoked, err := sh.SessionStore.DeleteUserSessions(userID, "")
+	if err != nil {
+		sh.Logger.Println("Error revoking user sessions", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	sh.Logger.Printf("Admin %s revoked %d sessions of user %s", actor.ID, revoked, userID)
+
+	after, _ := json.Marshal(map[string]int64{"revoked": revoked})
+	err = sh.AuditStore.RecordAuditEntry(actor, admin.AuditActionUserSessionsRevoke, admin.AuditTargetUser, userID.String(), nil, after)
+	if err != nil {
+		sh.Logger.Println("Error recording audit entry", err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": map[string]int64{"revoked": revoked}})
+}
@@ -1,34 +1,57 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/grvbrk/nazrein_server/internal/auth"
 	"github.com/grvbrk/nazrein_server/internal/middlewares"
 	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/store/analytics"
 	"github.com/grvbrk/nazrein_server/internal/utils"
 )
 
 // var ctx = context.Background()
 
+const searchLogTimeout = 5 * time.Second
+
 type VideoHandler struct {
-	VideoStore store.VideoStore
-	Logger     *log.Logger
-	Oauth      *auth.GoogleOauth
+	VideoStore  store.VideoStore
+	SearchStore analytics.AnalyticsSearchStore
+	Logger      *log.Logger
+	Oauth       *auth.UserOauth
 }
 
-func NewVideoHandler(videoStore store.VideoStore, logger *log.Logger, oauth *auth.GoogleOauth) *VideoHandler {
+func NewVideoHandler(videoStore store.VideoStore, searchStore analytics.AnalyticsSearchStore, logger *log.Logger, oauth *auth.UserOauth) *VideoHandler {
 	return &VideoHandler{
-		VideoStore: videoStore,
-		Logger:     logger,
-		Oauth:      oauth,
+		VideoStore:  videoStore,
+		SearchStore: searchStore,
+		Logger:      logger,
+		Oauth:       oauth,
 	}
 }
 
+// logSearch records a search in the background, so a slow or unavailable
+// clickhouse never holds up the response.
+func (vh *VideoHandler) logSearch(event analytics.SearchQueryEvent) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), searchLogTimeout)
+		defer cancel()
+
+		if err := vh.SearchStore.LogSearchQuery(ctx, event); err != nil {
+			vh.Logger.Printf("Error logging search query: %v", err)
+		}
+	}()
+}
+
 func (vh *VideoHandler) HandlerGetVideos(w http.ResponseWriter, r *http.Request) {
 	pageStr := r.URL.Query().Get("page")
 	if pageStr == "" {
@@ -94,15 +117,64 @@ func (vh *VideoHandler) HandlerGetVideos(w http.ResponseWriter, r *http.Request)
 		vh.Logger.Printf("Warning: invalid type parameter '%s', defaulting to 'video'", searchTypeStr)
 	}
 
+	// scope is optional, "history" also matches titles a video used to have
+	scopeStr := r.URL.Query().Get("scope")
+	scope := store.ValidateSearchScope(scopeStr)
+	if scopeStr != "" && string(scope) != scopeStr {
+		vh.Logger.Printf("Warning: invalid scope parameter '%s', defaulting to 'current'", scopeStr)
+	}
+
+	filters, err := parseVideoFilters(r)
+	if err != nil {
+		vh.Logger.Printf("Error: invalid filter parameter: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
+	search, err := store.ParseSearchQuery(query)
+	if err != nil {
+		vh.Logger.Printf("Error: invalid search query '%s': %v", query, err)
+
+		var syntaxErr *store.SearchSyntaxError
+		if errors.As(err, &syntaxErr) {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{
+				"message":  "Bad Request",
+				"error":    syntaxErr.Message,
+				"position": syntaxErr.Position,
+			})
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "Bad Request"})
+		return
+	}
+
 	params := store.GetVideosParams{
-		Page:   page,
-		Limit:  limit,
-		SortBy: sortBy,
-		Query:  query,
-		Type:   searchType,
+		Page:    page,
+		Limit:   limit,
+		SortBy:  sortBy,
+		Query:   query,
+		Search:  search,
+		Type:    searchType,
+		Scope:   scope,
+		Filters: filters,
 	}
 
 	user, ok := middlewares.GetUserFromContext(r)
+	if !ok && (filters.Bookmarked != nil || search.Bookmarked) {
+		vh.Logger.Println("Error: bookmarked filter requires a logged in user")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "Not Authorized"})
+		return
+	}
+
+	searchEvent := analytics.SearchQueryEvent{
+		SearchedAt:      time.Now(),
+		Query:           query,
+		Endpoint:        analytics.SearchEndpointVideos,
+		SearchType:      string(searchType),
+		IsAuthenticated: ok,
+	}
+
 	if !ok {
 		// No authenticated user - return videos without bookmark information
 		response, err := vh.VideoStore.GetVideos(params)
@@ -112,6 +184,12 @@ func (vh *VideoHandler) HandlerGetVideos(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		if strings.TrimSpace(query) != "" {
+			searchEvent.ResultCount = response.Total
+			searchEvent.Latency = time.Since(searchEvent.SearchedAt)
+			vh.logSearch(searchEvent)
+		}
+
 		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": response})
 		return
 	}
@@ -124,6 +202,12 @@ func (vh *VideoHandler) HandlerGetVideos(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if strings.TrimSpace(query) != "" {
+		searchEvent.ResultCount = response.Total
+		searchEvent.Latency = time.Since(searchEvent.SearchedAt)
+		vh.logSearch(searchEvent)
+	}
+
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": response})
 }
 
@@ -199,12 +283,91 @@ func (vh *VideoHandler) HandlerGetSimilarVideosByName(w http.ResponseWriter, r *
 		return
 	}
 
-	videos, err := vh.VideoStore.GetSimilarVideosByName(query)
+	searchedAt := time.Now()
+
+	suggestions, err := vh.VideoStore.GetSimilarVideosByName(query)
 	if err != nil {
 		vh.Logger.Println("Error getting similar videos from store", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
 		return
 	}
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": videos})
 
+	_, isAuthenticated := middlewares.GetUserFromContext(r)
+	vh.logSearch(analytics.SearchQueryEvent{
+		SearchedAt:      searchedAt,
+		Query:           query,
+		Endpoint:        analytics.SearchEndpointAutocomplete,
+		SearchType:      "autocomplete",
+		ResultCount:     len(suggestions.Videos) + len(suggestions.Channels),
+		Latency:         time.Since(searchedAt),
+		IsAuthenticated: isAuthenticated,
+	})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": suggestions})
+
+}
+
+// parseVideoFilters reads the optional listing filters from the query string.
+// Dates are either 2006-01-02 or RFC 3339, "after" bounds are inclusive and
+// "before" bounds exclusive.
+func parseVideoFilters(r *http.Request) (store.VideoFilters, error) {
+	q := r.URL.Query()
+	filters := store.VideoFilters{
+		ChannelID: q.Get("channel_id"),
+	}
+
+	timeParams := []struct {
+		name string
+		dest **time.Time
+	}{
+		{"published_after", &filters.PublishedAfter},
+		{"published_before", &filters.PublishedBefore},
+		{"tracked_after", &filters.TrackedAfter},
+		{"tracked_before", &filters.TrackedBefore},
+	}
+
+	for _, tp := range timeParams {
+		value := q.Get(tp.name)
+		if value == "" {
+			continue
+		}
+
+		t, err := parseTimeParam(value)
+		if err != nil {
+			return filters, fmt.Errorf("%s: %w", tp.name, err)
+		}
+		*tp.dest = &t
+	}
+
+	if value := q.Get("min_changes"); value != "" {
+		minChanges, err := strconv.Atoi(value)
+		if err != nil || minChanges < 0 {
+			return filters, fmt.Errorf("min_changes must be a non-negative integer, got %q", value)
+		}
+		filters.MinChanges = minChanges
+	}
+
+	if value := q.Get("changed_within_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > 365 {
+			return filters, fmt.Errorf("changed_within_days must be between 1 and 365, got %q", value)
+		}
+		filters.ChangedWithinDays = days
+	}
+
+	if value := q.Get("bookmarked"); value != "" {
+		bookmarked, err := strconv.ParseBool(value)
+		if err != nil {
+			return filters, fmt.Errorf("bookmarked must be true or false, got %q", value)
+		}
+		filters.Bookmarked = &bookmarked
+	}
+
+	return filters, nil
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -10,24 +11,31 @@ import (
 	"github.com/grvbrk/nazrein_server/internal/auth"
 	"github.com/grvbrk/nazrein_server/internal/middlewares"
 	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/services"
 	"github.com/grvbrk/nazrein_server/internal/store"
 	"github.com/grvbrk/nazrein_server/internal/utils"
 )
 
 type VideoRequestHandler struct {
 	VideoRequestStore store.VideoRequestStore
+	VideoWatcherStore store.VideoWatcherStore
+	YouTubeClient     *services.YouTubeClient
 	Logger            *log.Logger
-	Oauth             *auth.GoogleOauth
+	Oauth             *auth.UserOauth
 }
 
-func NewVideoRequestHandler(videoReqStore store.VideoRequestStore, logger *log.Logger, oauth *auth.GoogleOauth) *VideoRequestHandler {
+func NewVideoRequestHandler(videoReqStore store.VideoRequestStore, videoWatcherStore store.VideoWatcherStore, youtubeClient *services.YouTubeClient, logger *log.Logger, oauth *auth.UserOauth) *VideoRequestHandler {
 	return &VideoRequestHandler{
 		VideoRequestStore: videoReqStore,
+		VideoWatcherStore: videoWatcherStore,
+		YouTubeClient:     youtubeClient,
 		Logger:            logger,
 		Oauth:             oauth,
 	}
 }
 
+// HandlerCreateVideoRequest asks for a video to be tracked. A video that is
+// tracked already needs no review, the user just starts watching it.
 func (vrh *VideoRequestHandler) HandlerCreateVideoRequest(w http.ResponseWriter, r *http.Request) {
 	var req models.VideoRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -44,8 +52,19 @@ func (vrh *VideoRequestHandler) HandlerCreateVideoRequest(w http.ResponseWriter,
 		return
 	}
 
+	videoID, err := vrh.VideoWatcherStore.GetVideoIDByYoutubeID(req.Youtube_ID)
+	if err == nil {
+		vrh.watchTrackedVideo(w, user, videoID)
+		return
+	}
+	if !errors.Is(err, store.ErrVideoNotTracked) {
+		vrh.Logger.Println("Error finding tracked video", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
 	var totalRequests int
-	if user.Role == "USER" {
+	if !user.HasPermission(models.PermissionRequestsUnlimited) {
 		totalRequests, err = vrh.VideoRequestStore.GetTotalPendingRequestsByUserID(user.ID)
 		if err != nil {
 			vrh.Logger.Println("Error getting total requests by user id", err)
@@ -60,7 +79,23 @@ func (vrh *VideoRequestHandler) HandlerCreateVideoRequest(w http.ResponseWriter,
 		}
 	}
 
+	// the channel is looked up for the admin queue, the request still goes
+	// through when youtube can't be reached
+	req.Channel_ID = nil
+	req.Channel_Title = nil
+	ytVideos, err := vrh.YouTubeClient.GetVideos([]string{req.Youtube_ID})
+	if err != nil {
+		vrh.Logger.Println("Error fetching video from youtube v3 api", err)
+	} else if ytVideo, ok := ytVideos[req.Youtube_ID]; ok {
+		req.Channel_ID = &ytVideo.Snippet.ChannelId
+		req.Channel_Title = &ytVideo.Snippet.ChannelTitle
+	}
+
 	err = vrh.VideoRequestStore.CreateVideoRequest(&req, user.ID)
+	if errors.Is(err, store.ErrDuplicateVideoRequest) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"message": "You have already requested this video"})
+		return
+	}
 	if err != nil {
 		vrh.Logger.Println("Error creating video request in store", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
@@ -70,6 +105,29 @@ func (vrh *VideoRequestHandler) HandlerCreateVideoRequest(w http.ResponseWriter,
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"message": "Success"})
 }
 
+// watchTrackedVideo adds the user to the watchers of a tracked video, within
+// their track limit.
+func (vrh *VideoRequestHandler) watchTrackedVideo(w http.ResponseWriter, user *models.User, videoID uuid.UUID) {
+	added, err := vrh.VideoWatcherStore.WatchVideo(videoID, user.ID)
+	if errors.Is(err, store.ErrTrackLimitReached) {
+		vrh.Logger.Println("User has reached track limit")
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "You have reached your track limit"})
+		return
+	}
+	if err != nil {
+		vrh.Logger.Println("Error adding video watcher", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "Internal Server Error"})
+		return
+	}
+
+	if !added {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "You are already tracking this video", "video_id": videoID})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"message": "Video is already tracked, you are now tracking it too", "video_id": videoID})
+}
+
 func (vrh *VideoRequestHandler) HandlerDeleteVideoRequestByID(w http.ResponseWriter, r *http.Request) {
 	videoRequestID := chi.URLParam(r, "id")
 	if videoRequestID == "" {
@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/store/analytics"
+)
+
+const accountDeletionBatchSize = 50
+
+// AccountDeletionJob deletes the accounts whose deletion grace period is
+// over. The clickhouse snapshots of the videos only the user watches go
+// first: once the videos are deleted there is no telling which snapshots
+// were theirs.
+type AccountDeletionJob struct {
+	UserStore           store.UserStore
+	VideoWatcherStore   store.VideoWatcherStore
+	AnalyticsVideoStore analytics.AnalyticsVideoStore
+	Logger              *log.Logger
+}
+
+func NewAccountDeletionJob(userStore store.UserStore, videoWatcherStore store.VideoWatcherStore, analyticsVideoStore analytics.AnalyticsVideoStore, logger *log.Logger) *AccountDeletionJob {
+	return &AccountDeletionJob{
+		UserStore:           userStore,
+		VideoWatcherStore:   videoWatcherStore,
+		AnalyticsVideoStore: analyticsVideoStore,
+		Logger:              logger,
+	}
+}
+
+func (j *AccountDeletionJob) Name() string {
+	return "account_deletion"
+}
+
+// Run deletes one batch of accounts. A failed account is left for the next
+// run, both steps are safe to repeat.
+func (j *AccountDeletionJob) Run(ctx context.Context) error {
+	userIDs, err := j.UserStore.GetUsersDueForDeletion(accountDeletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := j.deleteAccount(ctx, userID); err != nil {
+			j.Logger.Printf("Error deleting account %s: %v", userID, err)
+		}
+	}
+
+	return nil
+}
+
+func (j *AccountDeletionJob) deleteAccount(ctx context.Context, userID uuid.UUID) error {
+	videoIDs, err := j.VideoWatcherStore.GetSoleWatchedVideoIDs(userID)
+	if err != nil {
+		return err
+	}
+
+	err = j.AnalyticsVideoStore.DeleteSnapshotsByVideoIDs(ctx, videoIDs)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize analytics: %w", err)
+	}
+
+	deleted, err := j.UserStore.DeleteUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if deleted {
+		j.Logger.Printf("Deleted account %s and the snapshots of its %d videos", userID, len(videoIDs))
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/services"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/store/admin"
+)
+
+const (
+	// autoApprovalRecheckInterval is how long a request no rule matched waits
+	// to be tried again. The conditions on ages are in days, so rechecking a
+	// few times a day is enough.
+	autoApprovalRecheckInterval = 6 * time.Hour
+
+	// autoApprovalRetryBackoff is the wait after a first failed approval,
+	// doubling with each failure after it.
+	autoApprovalRetryBackoff = 5 * time.Minute
+)
+
+// AutoApprovalJob tries the enabled auto approval rules on pending requests,
+// one youtube batch per run, and approves the ones a rule matches. Requests
+// no rule matches wait for an admin, and are tried again every
+// autoApprovalRecheckInterval, or when the rules change, as their requester
+// may match by then. Requests whose approval fails are retried with a
+// backoff, up to admin.MaxAutoApprovalFailures times.
+type AutoApprovalJob struct {
+	AutoApprovalStore      admin.AdminAutoApprovalStore
+	AdminVideoRequestStore admin.AdminVideoRequestStore
+	AdminVideoStore        admin.AdminVideoStore
+	YouTubeClient          *services.YouTubeClient
+	Logger                 *log.Logger
+}
+
+func NewAutoApprovalJob(autoApprovalStore admin.AdminAutoApprovalStore, adminVideoRequestStore admin.AdminVideoRequestStore, adminVideoStore admin.AdminVideoStore, youtubeClient *services.YouTubeClient, logger *log.Logger) *AutoApprovalJob {
+	return &AutoApprovalJob{
+		AutoApprovalStore:      autoApprovalStore,
+		AdminVideoRequestStore: adminVideoRequestStore,
+		AdminVideoStore:        adminVideoStore,
+		YouTubeClient:          youtubeClient,
+		Logger:                 logger,
+	}
+}
+
+func (j *AutoApprovalJob) Name() string {
+	return "auto_approval"
+}
+
+// Run tries the rules on one batch of requests. When youtube can't be
+// reached nothing is marked checked, so the batch is retried next run.
+func (j *AutoApprovalJob) Run(ctx context.Context) error {
+	rules, err := j.AutoApprovalStore.GetAutoApprovalRules(true, false)
+	if err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	candidates, err := j.AdminVideoRequestStore.GetAutoApprovalCandidates(services.YouTubeMaxBatchSize)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	youtubeIDs := make([]string, len(candidates))
+	for i, c := range candidates {
+		youtubeIDs[i] = c.YoutubeID
+	}
+
+	ytVideos, err := j.YouTubeClient.GetVideos(youtubeIDs)
+	if err != nil {
+		return err
+	}
+
+	ytChannels := map[string]services.YouTubeChannel{}
+	if needsSubscribers(rules) {
+		seen := map[string]bool{}
+		var channelIDs []string
+		for _, ytVideo := range ytVideos {
+			if channelID := ytVideo.Snippet.ChannelId; !seen[channelID] {
+				seen[channelID] = true
+				channelIDs = append(channelIDs, channelID)
+			}
+		}
+
+		ytChannels, err = j.YouTubeClient.GetChannels(channelIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+
+	for _, c := range candidates {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		ytVideo, ok := ytVideos[c.YoutubeID]
+		if !ok {
+			// gone from youtube, an admin will have to reject it
+			j.markChecked(c.RequestID, now)
+			continue
+		}
+
+		facts := admin.AutoApprovalFacts{
+			Role:             c.Role,
+			AccountCreatedAt: c.AccountCreatedAt,
+			ChannelID:        ytVideo.Snippet.ChannelId,
+			PublishedAt:      ytVideo.Snippet.PublishedAt,
+			AcceptedRequests: c.AcceptedRequests,
+			RejectedRequests: c.RejectedRequests,
+		}
+		if channel, ok := ytChannels[ytVideo.Snippet.ChannelId]; ok && !channel.Statistics.HiddenSubscriberCount {
+			subscribers := channel.Statistics.SubscriberCount
+			facts.Subscribers = &subscribers
+		}
+
+		rule := firstMatchingRule(rules, facts, now)
+		if rule == nil {
+			j.markChecked(c.RequestID, now)
+			continue
+		}
+
+		details := models.Video{
+			Published_At:  ytVideo.Snippet.PublishedAt,
+			Title:         ytVideo.Snippet.Title,
+			Description:   ytVideo.Snippet.Description,
+			Thumbnail:     ytVideo.Snippet.Thumbnails.High.URL,
+			Channel_Title: ytVideo.Snippet.ChannelTitle,
+			Channel_ID:    ytVideo.Snippet.ChannelId,
+			Language:      ytVideo.Language(),
+		}
+
+		changed, err := j.AdminVideoStore.AutoApproveVideoRequest(c.RequestID, &details, rule)
+		if errors.Is(err, store.ErrTrackLimitReached) {
+			// may fit once the user stops tracking something
+			j.Logger.Printf("Auto approval rule %q matched request %s, but its user has reached the track limit", rule.Name, c.RequestID)
+			j.markChecked(c.RequestID, now)
+			continue
+		}
+		if err != nil {
+			j.Logger.Printf("Error auto approving request %s (attempt %d of %d): %v", c.RequestID, c.Failures+1, admin.MaxAutoApprovalFailures, err)
+			j.markFailed(c, now)
+			continue
+		}
+
+		if changed {
+			j.Logger.Printf("Auto approval rule %q approved request %s", rule.Name, c.RequestID)
+		}
+	}
+
+	return nil
+}
+
+func (j *AutoApprovalJob) markChecked(requestID uuid.UUID, now time.Time) {
+	if err := j.AdminVideoRequestStore.MarkAutoApprovalChecked(requestID, now.Add(autoApprovalRecheckInterval)); err != nil {
+		j.Logger.Printf("Error marking request %s checked: %v", requestID, err)
+	}
+}
+
+// markFailed puts off the next attempt at the candidate, backing off
+// exponentially with its failures.
+func (j *AutoApprovalJob) markFailed(c admin.AutoApprovalCandidate, now time.Time) {
+	nextCheckAt := now.Add(autoApprovalRetryBackoff << c.Failures)
+	if err := j.AdminVideoRequestStore.MarkAutoApprovalFailed(c.RequestID, nextCheckAt); err != nil {
+		j.Logger.Printf("Error marking request %s failed: %v", c.RequestID, err)
+	}
+}
+
+// firstMatchingRule is the first of the rules, in priority order, whose
+// conditions facts pass.
+func firstMatchingRule(rules []admin.AutoApprovalRule, facts admin.AutoApprovalFacts, now time.Time) *admin.AutoApprovalRule {
+	for i := range rules {
+		if rules[i].Conditions.Matches(facts, now) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func needsSubscribers(rules []admin.AutoApprovalRule) bool {
+	for _, rule := range rules {
+		if rule.Conditions.NeedsSubscribers() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"github.com/grvbrk/nazrein_server/internal/store"
+)
+
+// RateLimitCleanupJob deletes the postgres rate limit counters of past
+// windows.
+type RateLimitCleanupJob struct {
+	RateLimitStore store.RateLimitStore
+	Logger         *log.Logger
+}
+
+func NewRateLimitCleanupJob(rateLimitStore store.RateLimitStore, logger *log.Logger) *RateLimitCleanupJob {
+	return &RateLimitCleanupJob{
+		RateLimitStore: rateLimitStore,
+		Logger:         logger,
+	}
+}
+
+func (j *RateLimitCleanupJob) Name() string {
+	return "rate_limit_cleanup"
+}
+
+func (j *RateLimitCleanupJob) Run(ctx context.Context) error {
+	deleted, err := j.RateLimitStore.DeleteExpiredCounters()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		j.Logger.Printf("Deleted %d expired rate limit counters", deleted)
+	}
+
+	return nil
+}
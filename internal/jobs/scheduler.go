@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Job is a unit of background work that the scheduler runs on an interval.
+// Every replica runs its own scheduler, so a job has to be safe to run
+// concurrently with itself.
+type Job interface {
+	Name() string
+	Run(ctx context.Context) error
+}
+
+type scheduledJob struct {
+	job      Job
+	interval time.Duration
+}
+
+type Scheduler struct {
+	Logger *log.Logger
+	jobs   []scheduledJob
+}
+
+func NewScheduler(logger *log.Logger) *Scheduler {
+	return &Scheduler{
+		Logger: logger,
+	}
+}
+
+func (s *Scheduler) Add(job Job, interval time.Duration) {
+	s.jobs = append(s.jobs, scheduledJob{job: job, interval: interval})
+}
+
+// Start runs every job once straight away and then on its interval, until
+// ctx is cancelled. It does not block.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, sj := range s.jobs {
+		go s.loop(ctx, sj)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, sj scheduledJob) {
+	ticker := time.NewTicker(sj.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := sj.job.Run(ctx); err != nil {
+			s.Logger.Printf("Error running job %s: %v", sj.job.Name(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
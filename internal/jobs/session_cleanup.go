@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"github.com/grvbrk/nazrein_server/internal/store"
+)
+
+// SessionCleanupJob deletes expired sessions. They are already ignored when
+// loaded, this only keeps the table small.
+type SessionCleanupJob struct {
+	SessionStore store.SessionStore
+	Logger       *log.Logger
+}
+
+func NewSessionCleanupJob(sessionStore store.SessionStore, logger *log.Logger) *SessionCleanupJob {
+	return &SessionCleanupJob{
+		SessionStore: sessionStore,
+		Logger:       logger,
+	}
+}
+
+func (j *SessionCleanupJob) Name() string {
+	return "session_cleanup"
+}
+
+func (j *SessionCleanupJob) Run(ctx context.Context) error {
+	deleted, err := j.SessionStore.DeleteExpiredSessions()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		j.Logger.Printf("Deleted %d expired sessions", deleted)
+	}
+
+	return nil
+}
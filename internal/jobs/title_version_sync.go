@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/store/analytics"
+)
+
+// Snapshots can land in clickhouse a little after the time they were taken,
+// so each sync looks back this far past its checkpoint. Upserts are
+// idempotent, so reading a title twice is harmless.
+const titleVersionSyncOverlap = time.Hour
+
+// TitleVersionSyncJob copies every title seen in clickhouse snapshots into
+// the postgres video_title_versions table, which is what history search uses.
+type TitleVersionSyncJob struct {
+	AnalyticsVideoStore analytics.AnalyticsVideoStore
+	TitleVersionStore   store.TitleVersionStore
+	CheckpointStore     store.CheckpointStore
+	Logger              *log.Logger
+}
+
+func NewTitleVersionSyncJob(analyticsVideoStore analytics.AnalyticsVideoStore, titleVersionStore store.TitleVersionStore, checkpointStore store.CheckpointStore, logger *log.Logger) *TitleVersionSyncJob {
+	return &TitleVersionSyncJob{
+		AnalyticsVideoStore: analyticsVideoStore,
+		TitleVersionStore:   titleVersionStore,
+		CheckpointStore:     checkpointStore,
+		Logger:              logger,
+	}
+}
+
+func (j *TitleVersionSyncJob) Name() string {
+	return "title_version_sync"
+}
+
+func (j *TitleVersionSyncJob) Run(ctx context.Context) error {
+	startedAt := time.Now()
+
+	syncedUntil, err := j.CheckpointStore.GetCheckpoint(j.Name())
+	if err != nil {
+		return err
+	}
+
+	since := time.Time{}
+	if !syncedUntil.IsZero() {
+		since = syncedUntil.Add(-titleVersionSyncOverlap)
+	}
+
+	titles, err := j.AnalyticsVideoStore.GetSnapshotTitlesSince(since)
+	if err != nil {
+		return err
+	}
+
+	versions := make([]store.TitleVersion, 0, len(titles))
+	for _, title := range titles {
+		videoID, err := uuid.Parse(title.VideoID)
+		if err != nil {
+			j.Logger.Printf("Skipping snapshot title with invalid video id %q: %v", title.VideoID, err)
+			continue
+		}
+
+		versions = append(versions, store.TitleVersion{
+			VideoID:     videoID,
+			Title:       title.Title,
+			FirstSeenAt: title.FirstSeenAt,
+			LastSeenAt:  title.LastSeenAt,
+		})
+	}
+
+	err = j.TitleVersionStore.UpsertTitleVersions(versions)
+	if err != nil {
+		return fmt.Errorf("failed to sync title versions: %w", err)
+	}
+
+	return j.CheckpointStore.SetCheckpoint(j.Name(), startedAt)
+}
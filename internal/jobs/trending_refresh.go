@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/grvbrk/nazrein_server/internal/store"
+)
+
+const (
+	// An event counts half as much after this long
+	trendingHalfLife = 48 * time.Hour
+
+	// Events older than this add under 1% of their weight and are skipped
+	trendingWindow = 14 * 24 * time.Hour
+)
+
+// Bookmarks weigh three visits, same as the popular sort
+var trendingWeights = store.TrendingWeights{
+	Visit:    1.0,
+	Bookmark: 3.0,
+	Change:   2.0,
+}
+
+// TrendingRefreshJob recomputes the trending score the trending sort orders by.
+type TrendingRefreshJob struct {
+	TrendingStore store.TrendingStore
+	Logger        *log.Logger
+}
+
+func NewTrendingRefreshJob(trendingStore store.TrendingStore, logger *log.Logger) *TrendingRefreshJob {
+	return &TrendingRefreshJob{
+		TrendingStore: trendingStore,
+		Logger:        logger,
+	}
+}
+
+func (j *TrendingRefreshJob) Name() string {
+	return "trending_refresh"
+}
+
+func (j *TrendingRefreshJob) Run(ctx context.Context) error {
+	refreshed, err := j.TrendingStore.RefreshTrendingScores(trendingWeights, trendingHalfLife, trendingWindow)
+	if err != nil {
+		return err
+	}
+
+	if !refreshed {
+		j.Logger.Println("Trending scores are being refreshed by another replica, skipping")
+	}
+
+	return nil
+}
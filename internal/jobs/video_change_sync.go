@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/store/analytics"
+)
+
+const (
+	// Same reasoning as titleVersionSyncOverlap, changes are keyed by video
+	// and snapshot time so reading one twice is harmless.
+	videoChangeSyncOverlap = time.Hour
+
+	// How far before the window to look for the snapshot each new snapshot
+	// is compared against. Videos are snapshotted far more often than this.
+	videoChangeSyncLookback = 24 * time.Hour
+)
+
+// VideoChangeSyncJob records every title or thumbnail change found in the
+// clickhouse snapshots into the postgres video_changes table.
+type VideoChangeSyncJob struct {
+	AnalyticsVideoStore analytics.AnalyticsVideoStore
+	VideoChangeStore    store.VideoChangeStore
+	CheckpointStore     store.CheckpointStore
+	Logger              *log.Logger
+}
+
+func NewVideoChangeSyncJob(analyticsVideoStore analytics.AnalyticsVideoStore, videoChangeStore store.VideoChangeStore, checkpointStore store.CheckpointStore, logger *log.Logger) *VideoChangeSyncJob {
+	return &VideoChangeSyncJob{
+		AnalyticsVideoStore: analyticsVideoStore,
+		VideoChangeStore:    videoChangeStore,
+		CheckpointStore:     checkpointStore,
+		Logger:              logger,
+	}
+}
+
+func (j *VideoChangeSyncJob) Name() string {
+	return "video_change_sync"
+}
+
+func (j *VideoChangeSyncJob) Run(ctx context.Context) error {
+	startedAt := time.Now()
+
+	syncedUntil, err := j.CheckpointStore.GetCheckpoint(j.Name())
+	if err != nil {
+		return err
+	}
+
+	since := time.Time{}
+	if !syncedUntil.IsZero() {
+		since = syncedUntil.Add(-videoChangeSyncOverlap)
+	}
+
+	snapshotChanges, err := j.AnalyticsVideoStore.GetSnapshotChangesSince(since, videoChangeSyncLookback)
+	if err != nil {
+		return err
+	}
+
+	changes := make([]store.VideoChange, 0, len(snapshotChanges))
+	for _, change := range snapshotChanges {
+		videoID, err := uuid.Parse(change.VideoID)
+		if err != nil {
+			j.Logger.Printf("Skipping snapshot change with invalid video id %q: %v", change.VideoID, err)
+			continue
+		}
+
+		changes = append(changes, store.VideoChange{
+			VideoID:          videoID,
+			ChangedAt:        change.ChangedAt,
+			TitleChanged:     change.TitleChanged,
+			ThumbnailChanged: change.ThumbnailChanged,
+		})
+	}
+
+	err = j.VideoChangeStore.InsertVideoChanges(changes)
+	if err != nil {
+		return fmt.Errorf("failed to sync video changes: %w", err)
+	}
+
+	return j.CheckpointStore.SetCheckpoint(j.Name(), startedAt)
+}
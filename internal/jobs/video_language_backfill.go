@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"github.com/grvbrk/nazrein_server/internal/services"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+// VideoLanguageBackfillJob looks up the language of videos tracked before
+// languages were stored, one youtube batch per run, until none are left.
+type VideoLanguageBackfillJob struct {
+	VideoLanguageStore store.VideoLanguageStore
+	YouTubeClient      *services.YouTubeClient
+	Logger             *log.Logger
+}
+
+func NewVideoLanguageBackfillJob(videoLanguageStore store.VideoLanguageStore, youtubeClient *services.YouTubeClient, logger *log.Logger) *VideoLanguageBackfillJob {
+	return &VideoLanguageBackfillJob{
+		VideoLanguageStore: videoLanguageStore,
+		YouTubeClient:      youtubeClient,
+		Logger:             logger,
+	}
+}
+
+func (j *VideoLanguageBackfillJob) Name() string {
+	return "video_language_backfill"
+}
+
+func (j *VideoLanguageBackfillJob) Run(ctx context.Context) error {
+	videos, err := j.VideoLanguageStore.GetVideosWithoutLanguage(services.YouTubeMaxBatchSize)
+	if err != nil {
+		return err
+	}
+
+	if len(videos) == 0 {
+		return nil
+	}
+
+	youtubeIDs := make([]string, 0, len(videos))
+	for _, video := range videos {
+		youtubeIDs = append(youtubeIDs, video.Youtube_ID)
+	}
+
+	ytVideos, err := j.YouTubeClient.GetVideos(youtubeIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		// Videos taken down on youtube only have the title we stored
+		language := utils.DetectLanguage(video.Title)
+		if ytVideo, ok := ytVideos[video.Youtube_ID]; ok {
+			language = ytVideo.Language()
+		}
+
+		err := j.VideoLanguageStore.SetVideoLanguage(video.ID, language)
+		if err != nil {
+			return err
+		}
+	}
+
+	j.Logger.Printf("Backfilled the language of %d videos", len(videos))
+	return nil
+}
@@ -2,14 +2,19 @@ package middlewares
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
+	"github.com/grvbrk/nazrein_server/internal/auth"
 	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/store"
 	"github.com/grvbrk/nazrein_server/internal/utils"
 )
 
@@ -17,69 +22,238 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 const AdminContextKey contextKey = "admin"
+const SessionIDContextKey contextKey = "session_id"
+const APITokenContextKey contextKey = "api_token"
+
+// apiTokenTouchInterval limits how often last_used_at is written.
+const apiTokenTouchInterval = time.Minute
 
 type MiddlewareHandler struct {
 	Logger            *log.Logger
 	AdminLogger       *log.Logger
-	SessionStore      *sessions.CookieStore
-	AdminSessionStore *sessions.CookieStore
+	SessionStore      sessions.Store
+	AdminSessionStore sessions.Store
+	APITokenStore     store.APITokenStore
+	UserStore         store.UserStore
+	RateLimits        *RateLimits
 }
 
-func NewMiddlewareHandler(logger *log.Logger, adminLogger *log.Logger, store *sessions.CookieStore, adminStore *sessions.CookieStore) *MiddlewareHandler {
+func NewMiddlewareHandler(logger *log.Logger, adminLogger *log.Logger, store sessions.Store, adminStore sessions.Store, apiTokenStore store.APITokenStore, userStore store.UserStore, rateLimits *RateLimits) *MiddlewareHandler {
 	return &MiddlewareHandler{
 		Logger:            logger,
 		AdminLogger:       adminLogger,
 		SessionStore:      store,
 		AdminSessionStore: adminStore,
+		APITokenStore:     apiTokenStore,
+		UserStore:         userStore,
+		RateLimits:        rateLimits,
 	}
 }
 
+// Authenticate accepts either a personal access token in an
+// `Authorization: Bearer` header or the session cookie.
 func (mh *MiddlewareHandler) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		session, err := mh.SessionStore.Get(r, "nazrein_session")
+		ctx, err := mh.authenticate(r)
 		if err != nil {
-			mh.Logger.Println("Error getting session in auth middleware:", err)
+			mh.Logger.Println("Error authenticating user:", err)
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authorized"})
 			return
 		}
 
-		if session.IsNew {
-			mh.Logger.Println("New session found in auth middleware (not authenticated)")
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authorized"})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// OptionalAuthenticate puts the user in the context when the request carries
+// a valid session, and lets anonymous requests through untouched. A bearer
+// token that doesn't check out is still rejected, since a script sending one
+// expects to be authenticated.
+func (mh *MiddlewareHandler) OptionalAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ctx, err := mh.authenticate(r)
+		if err != nil {
+			if hasAuthorizationHeader(r) {
+				mh.Logger.Println("Error authenticating user:", err)
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authorized"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		userEmail, emailOk := session.Values["user_email"].(string)
-		userIDStr, idOk := session.Values["user_id"].(string)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		if !emailOk || !idOk || userEmail == "" || userIDStr == "" {
-			mh.Logger.Println("Invalid or missing user data in session")
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authorized"})
+// RequireScope lets token authenticated requests through only when their
+// token has scope. Session authenticated requests have every scope.
+func (mh *MiddlewareHandler) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			token, ok := GetAPITokenFromContext(r)
+			if ok && !token.HasScope(scope) {
+				mh.Logger.Printf("API token %s is missing scope %s", token.ID, scope)
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "Insufficient scope"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rejects token authenticated requests, for endpoints that
+// manage the account's credentials.
+func (mh *MiddlewareHandler) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if _, ok := GetAPITokenFromContext(r); ok {
+			mh.Logger.Println("API token used on a session only endpoint")
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "Not available with an API token"})
 			return
 		}
 
-		userID, err := uuid.Parse(userIDStr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission lets the request through only when the authenticated
+// user, or admin on admin routes, has permission through their role.
+func (mh *MiddlewareHandler) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			user, ok := GetUserFromContext(r)
+			if !ok {
+				user, ok = GetAdminFromContext(r)
+			}
+			if !ok {
+				mh.Logger.Println("No user found in context.")
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authorized"})
+				return
+			}
+
+			if !user.HasPermission(permission) {
+				mh.Logger.Printf("User %s with role %s is missing permission %s", user.ID, user.Role, permission)
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "Forbidden"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasAuthorizationHeader(r *http.Request) bool {
+	return r.Header.Get("Authorization") != ""
+}
+
+// authenticate returns the request context with the authenticated user, and
+// the token or session they authenticated with.
+func (mh *MiddlewareHandler) authenticate(r *http.Request) (context.Context, error) {
+	if hasAuthorizationHeader(r) {
+		user, token, err := mh.userFromToken(r)
 		if err != nil {
-			mh.Logger.Println("Invalid user ID format in session:", err)
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Not Authorized"})
-			return
+			return nil, err
 		}
 
-		user := &models.User{
-			ID:    userID,
-			Email: userEmail,
+		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		ctx = context.WithValue(ctx, APITokenContextKey, token)
+		return ctx, nil
+	}
+
+	user, sessionID, err := mh.userFromSession(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, user)
+	ctx = context.WithValue(ctx, SessionIDContextKey, sessionID)
+	return ctx, nil
+}
+
+func (mh *MiddlewareHandler) userFromToken(r *http.Request) (*models.User, *store.APIToken, error) {
+	scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	value = strings.TrimSpace(value)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || !auth.IsAPIToken(value) {
+		return nil, nil, errors.New("malformed authorization header")
+	}
+
+	token, err := mh.APITokenStore.GetAPITokenByHash(auth.HashAPIToken(value))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting api token: %w", err)
+	}
+	if token == nil {
+		return nil, nil, errors.New("unknown or expired api token")
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > apiTokenTouchInterval {
+		if err := mh.APITokenStore.TouchAPIToken(token.ID); err != nil {
+			mh.Logger.Println("Error updating api token last use:", err)
 		}
+	}
 
-		ctx := context.WithValue(r.Context(), UserContextKey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	user, err := mh.loadUser(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, token, nil
+}
+
+func (mh *MiddlewareHandler) userFromSession(r *http.Request) (*models.User, string, error) {
+	session, err := mh.SessionStore.Get(r, auth.UserSessionName)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting session: %w", err)
+	}
+
+	if session.IsNew {
+		return nil, "", errors.New("new session found (not authenticated)")
+	}
+
+	userEmail, emailOk := session.Values["user_email"].(string)
+	userIDStr, idOk := session.Values["user_id"].(string)
+
+	if !emailOk || !idOk || userEmail == "" || userIDStr == "" {
+		return nil, "", errors.New("invalid or missing user data in session")
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid user ID format in session: %w", err)
+	}
+
+	user, err := mh.loadUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, session.ID, nil
+}
+
+// loadUser reads the user from the database on every request, so that a
+// change of role takes effect right away.
+func (mh *MiddlewareHandler) loadUser(userID uuid.UUID) (*models.User, error) {
+	user, err := mh.UserStore.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %s no longer exists", userID)
+	}
+
+	return user, nil
 }
 
 func (mh *MiddlewareHandler) AuthenticateAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		session, err := mh.AdminSessionStore.Get(r, "nazrein_admin_session")
+		session, err := mh.AdminSessionStore.Get(r, auth.AdminSessionName)
 		if err != nil {
 			mh.AdminLogger.Println("Error getting admin session in auth middleware:", err)
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Admin access required"})
@@ -108,9 +282,17 @@ func (mh *MiddlewareHandler) AuthenticateAdmin(next http.Handler) http.Handler {
 			return
 		}
 
-		admin := &models.User{
-			ID:    adminID,
-			Email: adminEmail,
+		admin, err := mh.loadUser(adminID)
+		if err != nil {
+			mh.AdminLogger.Println("Error loading admin in auth middleware:", err)
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Admin access required"})
+			return
+		}
+
+		if !admin.HasPermission(models.PermissionAdminAccess) {
+			mh.AdminLogger.Printf("User %s with role %s has no admin access", admin.ID, admin.Role)
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "Admin access required"})
+			return
 		}
 
 		ctx := context.WithValue(r.Context(), AdminContextKey, admin)
@@ -190,3 +372,13 @@ func GetAdminFromContext(r *http.Request) (*models.User, bool) {
 	user, ok := r.Context().Value(AdminContextKey).(*models.User)
 	return user, ok
 }
+
+func GetAPITokenFromContext(r *http.Request) (*store.APIToken, bool) {
+	token, ok := r.Context().Value(APITokenContextKey).(*store.APIToken)
+	return token, ok
+}
+
+func GetSessionIDFromContext(r *http.Request) (string, bool) {
+	sessionID, ok := r.Context().Value(SessionIDContextKey).(string)
+	return sessionID, ok
+}
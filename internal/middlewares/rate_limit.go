@@ -0,0 +1,289 @@
+package middlewares
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/httprate"
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+// Rate limit groups, one per part of the router. Each has its own counters,
+// so a client spending its api budget still gets through to /auth.
+const (
+	RateLimitGlobal = "global"
+	RateLimitAuth   = "auth"
+	RateLimitPublic = "public"
+	RateLimitAPI    = "api"
+	RateLimitAdmin  = "admin"
+)
+
+// RateLimitPolicy allows Limit requests per Window to each client. Logged in
+// clients whose role is in RoleLimits get that limit instead.
+type RateLimitPolicy struct {
+	Limit      int
+	Window     time.Duration
+	RoleLimits map[string]int
+}
+
+// DefaultRateLimitPolicies are the limits without any RATE_LIMIT_* settings.
+var DefaultRateLimitPolicies = map[string]RateLimitPolicy{
+	RateLimitGlobal: {Limit: 200, Window: time.Minute},
+	RateLimitAuth:   {Limit: 100, Window: time.Minute},
+	RateLimitPublic: {Limit: 100, Window: time.Minute, RoleLimits: map[string]int{"PRO": 300, "MODERATOR": 300, "ADMIN": 1000}},
+	RateLimitAPI:    {Limit: 100, Window: time.Minute, RoleLimits: map[string]int{"PRO": 300, "MODERATOR": 300, "ADMIN": 1000}},
+	RateLimitAdmin:  {Limit: 100, Window: time.Minute},
+}
+
+// RateLimits limits each client per group, see RateLimit.
+type RateLimits struct {
+	Logger   *log.Logger
+	proxies  utils.TrustedProxies
+	limiters map[string]*rateLimiter
+}
+
+// LoadRateLimits builds the limiters of the policies, counting with the
+// counters newCounter makes, one per group, and keying anonymous clients by
+// the address the proxies give. Overrides come from the environment:
+//
+//	RATE_LIMIT_<GROUP>=100/1m           limit and window of the group
+//	RATE_LIMIT_<GROUP>_ROLES=PRO=300    comma separated limits per role
+func LoadRateLimits(policies map[string]RateLimitPolicy, newCounter func() httprate.LimitCounter, proxies utils.TrustedProxies, logger *log.Logger) (*RateLimits, error) {
+	rl := &RateLimits{
+		Logger:   logger,
+		proxies:  proxies,
+		limiters: map[string]*rateLimiter{},
+	}
+
+	for group, policy := range policies {
+		policy, err := policyFromEnv(group, policy)
+		if err != nil {
+			return nil, err
+		}
+
+		counter := newCounter()
+		counter.Config(policy.Limit, policy.Window)
+
+		rl.limiters[group] = &rateLimiter{
+			group:   group,
+			policy:  policy,
+			counter: counter,
+		}
+	}
+
+	return rl, nil
+}
+
+func policyFromEnv(group string, policy RateLimitPolicy) (RateLimitPolicy, error) {
+	name := "RATE_LIMIT_" + strings.ToUpper(group)
+
+	if value := os.Getenv(name); value != "" {
+		limitStr, windowStr, _ := strings.Cut(value, "/")
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return policy, fmt.Errorf("%s: limit must be a positive integer, got %q", name, value)
+		}
+		policy.Limit = limit
+
+		if windowStr != "" {
+			window, err := time.ParseDuration(windowStr)
+			if err != nil || window < time.Second {
+				return policy, fmt.Errorf("%s: window must be a duration of at least 1s, got %q", name, value)
+			}
+			policy.Window = window
+		}
+	}
+
+	if value := os.Getenv(name + "_ROLES"); value != "" {
+		roleLimits := map[string]int{}
+		for _, entry := range strings.Split(value, ",") {
+			role, limitStr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			limit, err := strconv.Atoi(limitStr)
+			if !ok || role == "" || err != nil || limit < 1 {
+				return policy, fmt.Errorf("%s_ROLES: expected ROLE=limit entries, got %q", name, entry)
+			}
+			roleLimits[strings.ToUpper(role)] = limit
+		}
+		policy.RoleLimits = roleLimits
+	}
+
+	return policy, nil
+}
+
+// RateLimit limits the requests of each client to the group's policy. The
+// client is the api token when one is used, else the logged in user or
+// admin, else the address, so the limit has to come after the
+// authentication middleware of the group to tell users apart. Responses
+// carry the RateLimit-* headers of the IETF ratelimit headers draft.
+func (mh *MiddlewareHandler) RateLimit(group string) func(http.Handler) http.Handler {
+	limiter, ok := mh.RateLimits.limiters[group]
+	if !ok {
+		panic(fmt.Sprintf("no rate limit policy for group %q", group))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			key, role := mh.rateLimitClient(r)
+
+			limit := limiter.policy.Limit
+			if roleLimit, ok := limiter.policy.RoleLimits[role]; ok {
+				limit = roleLimit
+			}
+
+			allowed, remaining, reset, err := limiter.take(key, limit)
+			if err != nil {
+				// a broken counter shouldn't take the api down with it
+				mh.RateLimits.Logger.Printf("Error rate limiting group %s: %v", group, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			resetSeconds := int(math.Ceil(reset.Seconds()))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit, int(limiter.policy.Window.Seconds())))
+
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(resetSeconds))
+				utils.WriteJSON(w, http.StatusTooManyRequests, utils.Envelope{"message": "Too Many Requests"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitClient is who a request is counted against, and their role.
+func (mh *MiddlewareHandler) rateLimitClient(r *http.Request) (string, string) {
+	if token, ok := GetAPITokenFromContext(r); ok {
+		role := ""
+		if user, ok := GetUserFromContext(r); ok {
+			role = user.Role
+		}
+		return "token:" + token.ID.String(), role
+	}
+
+	if user, ok := GetUserFromContext(r); ok {
+		return "user:" + user.ID.String(), user.Role
+	}
+
+	if admin, ok := GetAdminFromContext(r); ok {
+		return "user:" + admin.ID.String(), admin.Role
+	}
+
+	return "ip:" + mh.RateLimits.proxies.ClientIP(r), ""
+}
+
+// rateLimiter counts requests over a sliding window: the count of the
+// current window plus the part of the previous one still inside the window.
+// Reading and counting aren't atomic, with a shared counter that can't be
+// had cheaply, so concurrent requests can go a few over the limit.
+type rateLimiter struct {
+	group   string
+	policy  RateLimitPolicy
+	counter httprate.LimitCounter
+}
+
+// take counts a request of key if it's within limit. It returns whether it
+// was, what's left, and when the current window ends.
+func (l *rateLimiter) take(key string, limit int) (bool, int, time.Duration, error) {
+	now := time.Now().UTC()
+	currentWindow := now.Truncate(l.policy.Window)
+	previousWindow := currentWindow.Add(-l.policy.Window)
+	reset := currentWindow.Add(l.policy.Window).Sub(now)
+
+	key = l.group + ":" + key
+
+	current, previous, err := l.counter.Get(key, currentWindow, previousWindow)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	elapsed := now.Sub(currentWindow)
+	rate := float64(previous)*float64(l.policy.Window-elapsed)/float64(l.policy.Window) + float64(current)
+	used := int(math.Round(rate))
+
+	if used+1 > limit {
+		return false, 0, reset, nil
+	}
+
+	if err := l.counter.Increment(key, currentWindow); err != nil {
+		return false, 0, 0, err
+	}
+
+	return true, limit - used - 1, reset, nil
+}
+
+// fallbackCooldown is how long the fallback counts after the primary failed,
+// before the primary is tried again.
+const fallbackCooldown = 30 * time.Second
+
+// FallbackLimitCounter counts with primary, and with fallback while primary
+// is failing. Counts don't carry over between the two, so limits restart
+// when switching, which beats not limiting at all.
+type FallbackLimitCounter struct {
+	Logger    *log.Logger
+	primary   httprate.LimitCounter
+	fallback  httprate.LimitCounter
+	downUntil atomic.Int64
+}
+
+func NewFallbackLimitCounter(primary httprate.LimitCounter, fallback httprate.LimitCounter, logger *log.Logger) *FallbackLimitCounter {
+	return &FallbackLimitCounter{
+		Logger:   logger,
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+func (c *FallbackLimitCounter) Config(requestLimit int, windowLength time.Duration) {
+	c.primary.Config(requestLimit, windowLength)
+	c.fallback.Config(requestLimit, windowLength)
+}
+
+// current is the counter to use, primary unless it failed recently.
+func (c *FallbackLimitCounter) current() httprate.LimitCounter {
+	if time.Now().UnixNano() < c.downUntil.Load() {
+		return c.fallback
+	}
+	return c.primary
+}
+
+func (c *FallbackLimitCounter) failed(err error) {
+	c.Logger.Printf("Error with the rate limit counter, falling back for %s: %v", fallbackCooldown, err)
+	c.downUntil.Store(time.Now().Add(fallbackCooldown).UnixNano())
+}
+
+func (c *FallbackLimitCounter) Increment(key string, currentWindow time.Time) error {
+	return c.IncrementBy(key, currentWindow, 1)
+}
+
+func (c *FallbackLimitCounter) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	counter := c.current()
+	err := counter.IncrementBy(key, currentWindow, amount)
+	if err != nil && counter == c.primary {
+		c.failed(err)
+		return c.fallback.IncrementBy(key, currentWindow, amount)
+	}
+	return err
+}
+
+func (c *FallbackLimitCounter) Get(key string, currentWindow time.Time, previousWindow time.Time) (int, int, error) {
+	counter := c.current()
+	current, previous, err := counter.Get(key, currentWindow, previousWindow)
+	if err != nil && counter == c.primary {
+		c.failed(err)
+		return c.fallback.Get(key, currentWindow, previousWindow)
+	}
+	return current, previous, err
+}
@@ -0,0 +1,33 @@
+package models
+
+// Permissions are granted to roles in the role_permissions table, see
+// migrations 00013, 00015 and 00019.
+const (
+	PermissionRequestsCreate     = "requests:create"
+	PermissionRequestsUnlimited  = "requests:unlimited"
+	PermissionRequestsReview     = "requests:review"
+	PermissionBookmarksWrite     = "bookmarks:write"
+	PermissionAdminAccess        = "admin:access"
+	PermissionAnalyticsRead      = "analytics:read"
+	PermissionSessionsRevoke     = "sessions:revoke"
+	PermissionUsersManage        = "users:manage"
+	PermissionAuditRead          = "audit:read"
+	PermissionAutoApprovalManage = "auto_approval:manage"
+)
+
+// HasPermission reports whether the user's role grants permission. Only
+// users loaded with their permissions, as the auth middlewares do, have any.
+func (u *User) HasPermission(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
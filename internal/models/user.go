@@ -6,14 +6,19 @@ import (
 	"github.com/google/uuid"
 )
 
+// MaxTrackedVideos is how many videos a user without
+// PermissionRequestsUnlimited can have tracked.
+const MaxTrackedVideos = 3
+
 type User struct {
-	ID             uuid.UUID `json:"id"`
-	GoogleID       string    `json:"google_id"`
-	Name           string    `json:"name"`
-	Email          string    `json:"email"`
-	ImageSrc       string    `json:"image"`
-	Role           string    `json:"role"`
-	Videos_Tracked int       `json:"videos_tracked"`
-	Created_At     time.Time `json:"created_at"`
-	Updated_At     time.Time `json:"updated_at"`
+	ID             uuid.UUID  `json:"id"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email"`
+	ImageSrc       string     `json:"image"`
+	Role           string     `json:"role"`
+	Permissions    []string   `json:"permissions,omitempty"`
+	Videos_Tracked int        `json:"videos_tracked"`
+	Delete_After   *time.Time `json:"delete_after,omitempty"`
+	Created_At     time.Time  `json:"created_at"`
+	Updated_At     time.Time  `json:"updated_at"`
 }
@@ -16,9 +16,12 @@ type Video struct {
 	Youtube_ID    string    `json:"youtube_id"`
 	Channel_Title string    `json:"channel_title"`
 	Channel_ID    string    `json:"channel_id"`
-	User_ID       uuid.UUID `json:"user_id"`
-	Is_Active     bool      `json:"is_active"`
-	Visits        int       `json:"visits"`
-	Created_At    time.Time `json:"created_at"`
-	Updated_At    time.Time `json:"updated_at"`
+	Language      string    `json:"language,omitempty"`
+	// who asked for the video first, nil once their account is deleted.
+	// Everyone tracking it is in video_watchers.
+	User_ID    *uuid.UUID `json:"user_id"`
+	Is_Active  bool       `json:"is_active"`
+	Visits     int        `json:"visits"`
+	Created_At time.Time  `json:"created_at"`
+	Updated_At time.Time  `json:"updated_at"`
 }
@@ -6,11 +6,21 @@ import (
 	"github.com/google/uuid"
 )
 
+// Video request statuses, see the video_request_status enum.
+const (
+	VideoRequestStatusPending     = "PENDING"
+	VideoRequestStatusUnderReview = "UNDER_REVIEW"
+	VideoRequestStatusAccepted    = "ACCEPTED"
+	VideoRequestStatusRejected    = "REJECTED"
+)
+
 type VideoRequest struct {
 	Id              uuid.UUID  `json:"id"`
 	Status          string     `json:"status"`
 	Link            string     `json:"link"`
 	Youtube_ID      string     `json:"youtube_id"`
+	Channel_ID      *string    `json:"channel_id"`
+	Channel_Title   *string    `json:"channel_title"`
 	UserId          string     `json:"user_id"`
 	ProcessedBy     *uuid.UUID `json:"processed_by"`
 	ProcessedAt     *time.Time `json:"processed_at"`
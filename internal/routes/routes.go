@@ -1,87 +1,157 @@
 package routes
 
 import (
-	"time"
-
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/httprate"
 	"github.com/grvbrk/nazrein_server/internal/app"
+	"github.com/grvbrk/nazrein_server/internal/middlewares"
+	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/store"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
 
-	r.Use(httprate.LimitAll(200, time.Minute))
+	r.Use(app.MiddlewareHandler.RateLimit(middlewares.RateLimitGlobal))
 	r.Use(app.MiddlewareHandler.RequestLogger)
 	r.Use(app.MiddlewareHandler.Security)
 
 	r.Route("/auth", func(r chi.Router) {
 
-		r.Use(httprate.LimitAll(100, time.Minute))
+		r.Use(app.MiddlewareHandler.RateLimit(middlewares.RateLimitAuth))
 
 		// Auth routes without CORS
-		r.Get("/google/login", app.Oauth.Login)
+		r.Get("/logout", app.Oauth.Logout)
 		r.Get("/google/logout", app.Oauth.Logout)
-		r.Get("/google/callback", app.Oauth.Callback)
+		r.Get("/{provider}/login", app.Oauth.Login)
+		r.Get("/{provider}/callback", app.Oauth.Callback)
 
-		r.Get("/admin/google/login", app.AdminOauth.Login)
 		r.Get("/admin/google/logout", app.AdminOauth.Logout)
-		r.Get("/admin/google/callback", app.AdminOauth.Callback)
+		r.Get("/admin/{provider}/login", app.AdminOauth.Login)
+		r.Get("/admin/{provider}/callback", app.AdminOauth.Callback)
+
+		// the fake identity provider of AUTH_PROVIDER=dev, never in production
+		if app.DevIssuer != nil {
+			r.Get("/dev/authorize", app.DevIssuer.Authorize)
+			r.Post("/dev/authorize", app.DevIssuer.Authorize)
+		}
 
 		// Auth routes with CORS
 		r.Group(func(r chi.Router) {
 			r.Use(app.MiddlewareHandler.Cors)
 			r.Get("/user", app.Oauth.AuthUser)
+			r.Get("/providers", app.Oauth.ListProviders)
 			r.Get("/admin", app.AdminOauth.AuthAdmin)
 		})
 	})
 
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Use(httprate.LimitAll(100, time.Minute))
 		r.Use(app.MiddlewareHandler.Cors)
 
 		// public routes
 		r.Route("/public", func(r chi.Router) {
+			r.Use(app.MiddlewareHandler.OptionalAuthenticate)
+			r.Use(app.MiddlewareHandler.RateLimit(middlewares.RateLimitPublic))
+
 			r.Get("/videos", app.VideoHandler.HandlerGetVideos)
 			r.Get("/videos/{id}", app.VideoHandler.HandlerGetVideoByID)
 			r.Get("/videos/autocomplete", app.VideoHandler.HandlerGetSimilarVideosByName)
 			r.Get("/videos/analytics/{id}", app.AnalyticsVideoHandler.HandlerGetVideoAnalyticsByID)
 		})
 
-		// auth routes
+		// auth routes, open to sessions and to api tokens with the scope
 		r.Group(func(r chi.Router) {
 			r.Use(app.MiddlewareHandler.Authenticate)
+			r.Use(app.MiddlewareHandler.RateLimit(middlewares.RateLimitAPI))
+
+			read := app.MiddlewareHandler.RequireScope(store.TokenScopeRead)
+			bookmarksWrite := app.MiddlewareHandler.RequireScope(store.TokenScopeBookmarksWrite)
+			requestsWrite := app.MiddlewareHandler.RequireScope(store.TokenScopeRequestsWrite)
+			canRequest := app.MiddlewareHandler.RequirePermission(models.PermissionRequestsCreate)
+			canBookmark := app.MiddlewareHandler.RequirePermission(models.PermissionBookmarksWrite)
 
 			r.Route("/dashboard", func(r chi.Router) {
-				r.Get("/metrics", app.DashboardHandler.HandlerGetDashboardMetrics)
+				r.With(read).Get("/metrics", app.DashboardHandler.HandlerGetDashboardMetrics)
 			})
 
-			r.Get("/videos", app.VideoHandler.HandlerGetVideosByUserID)
-			r.Get("/videos/bookmarks", app.VideoHandler.HandlerGetBookmarkedVideosByUserID)
+			r.With(read).Get("/videos", app.VideoHandler.HandlerGetVideosByUserID)
+			r.With(read).Get("/videos/bookmarks", app.VideoHandler.HandlerGetBookmarkedVideosByUserID)
 
 			r.Route("/request", func(r chi.Router) {
-				r.Get("/", app.VideoRequestHandler.HandlerGetAllVideoRequestsByUserID)
-				r.Post("/", app.VideoRequestHandler.HandlerCreateVideoRequest)
-				r.Delete("/{id}", app.VideoRequestHandler.HandlerDeleteVideoRequestByID)
+				r.With(read).Get("/", app.VideoRequestHandler.HandlerGetAllVideoRequestsByUserID)
+				r.With(requestsWrite, canRequest).Post("/", app.VideoRequestHandler.HandlerCreateVideoRequest)
+				r.With(requestsWrite).Delete("/{id}", app.VideoRequestHandler.HandlerDeleteVideoRequestByID)
 			})
 
 			r.Route("/bookmark", func(r chi.Router) {
-				r.Post("/{id}", app.BookmarkHandler.HandlerCreateBookmark)
-				r.Delete("/{id}", app.BookmarkHandler.HandlerDeleteBookmark)
+				r.With(bookmarksWrite, canBookmark).Post("/{id}", app.BookmarkHandler.HandlerCreateBookmark)
+				r.With(bookmarksWrite).Delete("/{id}", app.BookmarkHandler.HandlerDeleteBookmark)
+			})
+
+			// credential management needs the browser session
+			r.Group(func(r chi.Router) {
+				r.Use(app.MiddlewareHandler.RequireSession)
+
+				r.Route("/sessions", func(r chi.Router) {
+					r.Get("/", app.SessionHandler.HandlerGetSessions)
+					r.Delete("/{id}", app.SessionHandler.HandlerDeleteSession)
+				})
+
+				r.Route("/me", func(r chi.Router) {
+					r.Get("/export", app.AccountHandler.HandlerExportAccount)
+					r.Delete("/", app.AccountHandler.HandlerDeleteAccount)
+					r.Post("/restore", app.AccountHandler.HandlerRestoreAccount)
+				})
+
+				r.Route("/identities", func(r chi.Router) {
+					r.Get("/", app.IdentityHandler.HandlerGetIdentities)
+					r.Delete("/{provider}", app.IdentityHandler.HandlerDeleteIdentity)
+				})
+
+				r.Route("/tokens", func(r chi.Router) {
+					r.Get("/", app.APITokenHandler.HandlerGetAPITokens)
+					r.Post("/", app.APITokenHandler.HandlerCreateAPIToken)
+					r.Delete("/{id}", app.APITokenHandler.HandlerDeleteAPIToken)
+				})
 			})
 		})
 	})
 
 	r.Route("/admin", func(r chi.Router) {
-		r.Use(httprate.LimitAll(100, time.Minute))
 		r.Use(app.MiddlewareHandler.Cors)
 		r.Use(app.MiddlewareHandler.AuthenticateAdmin)
+		r.Use(app.MiddlewareHandler.RateLimit(middlewares.RateLimitAdmin))
 
 		r.Route("/request", func(r chi.Router) {
+			r.Use(app.MiddlewareHandler.RequirePermission(models.PermissionRequestsReview))
+
 			r.Get("/", app.AdminHandler.HandlerGetVideoRequests)
 			r.Post("/", app.AdminHandler.HandlerApproveVideoRequest)
+			r.Post("/bulk", app.AdminHandler.HandlerBulkVideoRequests)
+			r.Post("/{request_id}/approve", app.AdminHandler.HandlerApproveVideoRequest)
 			r.Patch("/{request_id}", app.AdminHandler.HandlerUpdateVideoRequest)
 		})
+
+		r.With(app.MiddlewareHandler.RequirePermission(models.PermissionUsersManage)).Get("/roles", app.AdminHandler.HandlerGetRoles)
+		r.With(app.MiddlewareHandler.RequirePermission(models.PermissionUsersManage)).Patch("/users/{id}/role", app.AdminHandler.HandlerUpdateUserRole)
+		r.With(app.MiddlewareHandler.RequirePermission(models.PermissionSessionsRevoke)).Delete("/users/{id}/sessions", app.SessionHandler.HandlerRevokeUserSessions)
+		r.With(app.MiddlewareHandler.RequirePermission(models.PermissionAuditRead)).Get("/audit", app.AuditHandler.HandlerGetAuditLog)
+
+		r.Route("/auto-approval/rules", func(r chi.Router) {
+			r.Use(app.MiddlewareHandler.RequirePermission(models.PermissionAutoApprovalManage))
+
+			r.Get("/", app.AutoApprovalHandler.HandlerGetAutoApprovalRules)
+			r.Post("/", app.AutoApprovalHandler.HandlerCreateAutoApprovalRule)
+			r.Patch("/{id}", app.AutoApprovalHandler.HandlerUpdateAutoApprovalRule)
+			r.Delete("/{id}", app.AutoApprovalHandler.HandlerDeleteAutoApprovalRule)
+		})
+
+		r.Route("/analytics/search", func(r chi.Router) {
+			r.Use(app.MiddlewareHandler.RequirePermission(models.PermissionAnalyticsRead))
+
+			r.Get("/top", app.AnalyticsSearchHandler.HandlerGetTopQueries)
+			r.Get("/zero-results", app.AnalyticsSearchHandler.HandlerGetZeroResultQueries)
+			r.Get("/trends", app.AnalyticsSearchHandler.HandlerGetQueryTrends)
+		})
 	})
 
 	return r
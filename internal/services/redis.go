@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ConnectRedis opens a client for REDIS_URL, e.g. redis://localhost:6379/0.
+// An unreachable redis isn't an error, the client keeps reconnecting and its
+// users are expected to cope with it being down.
+func ConnectRedis() (*redis.Client, error) {
+	opts, err := redis.ParseURL(os.Getenv("REDIS_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	// fail fast, redis is on the request path
+	opts.DialTimeout = time.Second
+	opts.ReadTimeout = 500 * time.Millisecond
+	opts.WriteTimeout = 500 * time.Millisecond
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		fmt.Printf("Redis not reachable yet: %v\n", err)
+	} else {
+		fmt.Println("Connected to Redis!")
+	}
+
+	return client, nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grvbrk/nazrein_server/internal/utils"
+)
+
+// The videos.list endpoint takes at most this many ids per call
+const YouTubeMaxBatchSize = 50
+
+var ErrYouTubeAPIKeyMissing = errors.New("YOUTUBE_API_KEY environment variable is not set")
+
+type YouTubeThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type YouTubeVideo struct {
+	Id      string `json:"id"`
+	Snippet struct {
+		PublishedAt time.Time `json:"publishedAt"`
+		ChannelId   string    `json:"channelId"`
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		Thumbnails  struct {
+			Default  YouTubeThumbnail `json:"default"`
+			Medium   YouTubeThumbnail `json:"medium"`
+			High     YouTubeThumbnail `json:"high"`
+			Standard YouTubeThumbnail `json:"standard"`
+			Maxres   YouTubeThumbnail `json:"maxres"`
+		} `json:"thumbnails"`
+		ChannelTitle         string `json:"channelTitle"`
+		DefaultLanguage      string `json:"defaultLanguage"`
+		DefaultAudioLanguage string `json:"defaultAudioLanguage"`
+	} `json:"snippet"`
+}
+
+type YouTubeResponse struct {
+	Items []YouTubeVideo `json:"items"`
+}
+
+type YouTubeChannel struct {
+	Id         string `json:"id"`
+	Statistics struct {
+		// youtube sends the counts as strings
+		SubscriberCount       int64 `json:"subscriberCount,string"`
+		HiddenSubscriberCount bool  `json:"hiddenSubscriberCount"`
+		VideoCount            int64 `json:"videoCount,string"`
+	} `json:"statistics"`
+}
+
+type YouTubeChannelResponse struct {
+	Items []YouTubeChannel `json:"items"`
+}
+
+// Language returns the video's language as a BCP 47 tag. The uploader's
+// metadata wins, otherwise it is guessed from the script of the title and
+// description, and "und" is returned when that doesn't settle it either.
+func (v YouTubeVideo) Language() string {
+	if v.Snippet.DefaultLanguage != "" {
+		return v.Snippet.DefaultLanguage
+	}
+	if v.Snippet.DefaultAudioLanguage != "" {
+		return v.Snippet.DefaultAudioLanguage
+	}
+	return utils.DetectLanguage(v.Snippet.Title + " " + v.Snippet.Description)
+}
+
+type YouTubeClient struct {
+	HTTPClient *http.Client
+}
+
+func NewYouTubeClient() *YouTubeClient {
+	return &YouTubeClient{
+		HTTPClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// GetVideos fetches the snippets of up to YouTubeMaxBatchSize videos in one
+// call, keyed by youtube id. Ids youtube doesn't know are missing from the map.
+func (c *YouTubeClient) GetVideos(youtubeIDs []string) (map[string]YouTubeVideo, error) {
+	videos := map[string]YouTubeVideo{}
+
+	var ytResp YouTubeResponse
+	if err := c.list("videos", "snippet", youtubeIDs, &ytResp); err != nil {
+		return nil, err
+	}
+
+	for _, item := range ytResp.Items {
+		videos[item.Id] = item
+	}
+
+	return videos, nil
+}
+
+// GetChannels fetches the statistics of up to YouTubeMaxBatchSize channels in
+// one call, keyed by channel id. Ids youtube doesn't know are missing from
+// the map.
+func (c *YouTubeClient) GetChannels(channelIDs []string) (map[string]YouTubeChannel, error) {
+	channels := map[string]YouTubeChannel{}
+
+	var ytResp YouTubeChannelResponse
+	if err := c.list("channels", "statistics", channelIDs, &ytResp); err != nil {
+		return nil, err
+	}
+
+	for _, item := range ytResp.Items {
+		channels[item.Id] = item
+	}
+
+	return channels, nil
+}
+
+// list calls the list endpoint of resource for ids and decodes the response
+// into out, which is left alone when there are no ids.
+func (c *YouTubeClient) list(resource string, part string, ids []string, out interface{}) error {
+	if len(ids) > YouTubeMaxBatchSize {
+		return fmt.Errorf("at most %d %s can be fetched at once, got %d", YouTubeMaxBatchSize, resource, len(ids))
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	apiKey := os.Getenv("YOUTUBE_API_KEY")
+	if apiKey == "" {
+		return ErrYouTubeAPIKeyMissing
+	}
+
+	query := url.Values{}
+	query.Set("part", part)
+	query.Set("id", strings.Join(ids, ","))
+	query.Set("key", apiKey)
+
+	resp, err := c.HTTPClient.Get("https://youtube.googleapis.com/youtube/v3/" + resource + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s from youtube v3 api: %w", resource, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-OK response from youtube v3 api: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read youtube response: %w", err)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode youtube response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audited actions, as <target type>.<what happened>.
+const (
+	AuditActionVideoRequestApprove     = "video_request.approve"
+	AuditActionVideoRequestAutoApprove = "video_request.auto_approve"
+	AuditActionVideoRequestReject      = "video_request.reject"
+	AuditActionVideoRequestUpdate      = "video_request.update"
+	AuditActionUserRoleUpdate          = "user.role_update"
+	AuditActionUserSessionsRevoke      = "user.sessions_revoke"
+	AuditActionAutoApprovalRuleCreate  = "auto_approval_rule.create"
+	AuditActionAutoApprovalRuleUpdate  = "auto_approval_rule.update"
+	AuditActionAutoApprovalRuleDelete  = "auto_approval_rule.delete"
+)
+
+const (
+	AuditTargetVideoRequest     = "video_request"
+	AuditTargetUser             = "user"
+	AuditTargetAutoApprovalRule = "auto_approval_rule"
+)
+
+// AuditActor is the admin making a change, and where from.
+type AuditActor struct {
+	ID        uuid.UUID
+	Email     string
+	IPAddress string
+	UserAgent string
+}
+
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	ActorID    uuid.UUID       `json:"actor_id"`
+	ActorEmail string          `json:"actor_email"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IPAddress  string          `json:"ip_address"`
+	UserAgent  string          `json:"user_agent"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditQueryParams filters the audit log. Zero values don't filter. Entries
+// come newest first, BeforeID continues from the last id of a page.
+type AuditQueryParams struct {
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Until      *time.Time
+	BeforeID   int64
+	Limit      int
+}
+
+type AdminPostgresAuditStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAdminAuditStore(db *sql.DB) *AdminPostgresAuditStore {
+	return &AdminPostgresAuditStore{db: db}
+}
+
+type AdminAuditStore interface {
+	RecordAuditEntry(actor AuditActor, action string, targetType string, targetID string, before json.RawMessage, after json.RawMessage) error
+	GetAuditEntries(params AuditQueryParams) ([]AuditEntry, error)
+}
+
+// execer is what audit entries are written with, the db or the transaction
+// of the change they record.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordAuditEntry appends an entry. Stores call it inside the transaction
+// of the change, so that a change is never made without its entry.
+func recordAuditEntry(e execer, actor AuditActor, action string, targetType string, targetID string, before json.RawMessage, after json.RawMessage) error {
+	query := `
+		INSERT INTO admin_audit_log (actor_id, actor_email, action, target_type, target_id, before, after, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7::jsonb, NULLIF($8, ''), NULLIF($9, ''))
+	`
+
+	_, err := e.Exec(query, actor.ID, actor.Email, action, targetType, targetID,
+		nullableJSON(before), nullableJSON(after), actor.IPAddress, actor.UserAgent)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
+
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// RecordAuditEntry appends an entry for a change made outside the admin
+// stores.
+func (a *AdminPostgresAuditStore) RecordAuditEntry(actor AuditActor, action string, targetType string, targetID string, before json.RawMessage, after json.RawMessage) error {
+	return recordAuditEntry(a.db, actor, action, targetType, targetID, before, after)
+}
+
+func (a *AdminPostgresAuditStore) GetAuditEntries(params AuditQueryParams) ([]AuditEntry, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if params.ActorID != nil {
+		addCondition("actor_id = $%d", *params.ActorID)
+	}
+	if params.Action != "" {
+		addCondition("action = $%d", params.Action)
+	}
+	if params.TargetType != "" {
+		addCondition("target_type = $%d", params.TargetType)
+	}
+	if params.TargetID != "" {
+		addCondition("target_id = $%d", params.TargetID)
+	}
+	if params.Since != nil {
+		addCondition("created_at >= $%d", *params.Since)
+	}
+	if params.Until != nil {
+		addCondition("created_at < $%d", *params.Until)
+	}
+	if params.BeforeID > 0 {
+		addCondition("id < $%d", params.BeforeID)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, params.Limit)
+	query := fmt.Sprintf(`
+		SELECT id, actor_id, actor_email, action, target_type, target_id, before, after, ip_address, user_agent, created_at
+		FROM admin_audit_log
+		%s
+		ORDER BY id DESC
+		LIMIT $%d
+	`, where, len(args))
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+		var ipAddress, userAgent sql.NullString
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.ActorEmail,
+			&entry.Action,
+			&entry.TargetType,
+			&entry.TargetID,
+			&before,
+			&after,
+			&ipAddress,
+			&userAgent,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+
+		if before != nil {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != nil {
+			entry.After = json.RawMessage(after)
+		}
+		entry.IPAddress = ipAddress.String
+		entry.UserAgent = userAgent.String
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over audit entries: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,451 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAutoApprovalRuleNotFound = errors.New("auto approval rule not found")
+	ErrAutoApprovalRuleInvalid  = errors.New("invalid auto approval rule")
+)
+
+// AutoApprovalActor is who automatic decisions are audited as. It isn't a
+// user, the rule that fired is on the request.
+var AutoApprovalActor = AuditActor{ID: uuid.Nil, Email: "auto-approval"}
+
+// AutoApprovalConditions are the checks of a rule. A request has to pass all
+// the ones that are set.
+type AutoApprovalConditions struct {
+	// the requester has one of these roles
+	Roles []string `json:"roles,omitempty"`
+	// the requester signed up at least this many days ago
+	MinAccountAgeDays *int `json:"min_account_age_days,omitempty"`
+	// the video is from one of these channels
+	ChannelIDs []string `json:"channel_ids,omitempty"`
+	// the channel shows at least this many subscribers
+	MinSubscribers *int64 `json:"min_subscribers,omitempty"`
+	// the video was published at least this many days ago
+	MinVideoAgeDays *int `json:"min_video_age_days,omitempty"`
+	// at least this share, 0 to 1, of the requester's accepted and rejected
+	// requests were accepted, out of at least MinDecidedRequests of them
+	MinApprovalRate    *float64 `json:"min_approval_rate,omitempty"`
+	MinDecidedRequests int      `json:"min_decided_requests,omitempty"`
+}
+
+// AutoApprovalFacts is what conditions are checked against.
+type AutoApprovalFacts struct {
+	Role             string
+	AccountCreatedAt time.Time
+	ChannelID        string
+	// nil when the channel hides it
+	Subscribers      *int64
+	PublishedAt      time.Time
+	AcceptedRequests int
+	RejectedRequests int
+}
+
+// Validate normalizes the conditions and errors with
+// ErrAutoApprovalRuleInvalid unless they make sense. A rule without
+// conditions would approve everything, so one is needed.
+func (c *AutoApprovalConditions) Validate() error {
+	roles := []string{}
+	for _, role := range c.Roles {
+		role = strings.ToUpper(strings.TrimSpace(role))
+		if role == "" {
+			return fmt.Errorf("%w: empty role", ErrAutoApprovalRuleInvalid)
+		}
+		roles = append(roles, role)
+	}
+	c.Roles = roles
+
+	channelIDs := []string{}
+	for _, channelID := range c.ChannelIDs {
+		channelID = strings.TrimSpace(channelID)
+		if channelID == "" {
+			return fmt.Errorf("%w: empty channel id", ErrAutoApprovalRuleInvalid)
+		}
+		channelIDs = append(channelIDs, channelID)
+	}
+	c.ChannelIDs = channelIDs
+
+	if c.MinAccountAgeDays != nil && *c.MinAccountAgeDays < 0 {
+		return fmt.Errorf("%w: min_account_age_days can't be negative", ErrAutoApprovalRuleInvalid)
+	}
+	if c.MinSubscribers != nil && *c.MinSubscribers < 0 {
+		return fmt.Errorf("%w: min_subscribers can't be negative", ErrAutoApprovalRuleInvalid)
+	}
+	if c.MinVideoAgeDays != nil && *c.MinVideoAgeDays < 0 {
+		return fmt.Errorf("%w: min_video_age_days can't be negative", ErrAutoApprovalRuleInvalid)
+	}
+	if c.MinApprovalRate != nil && (*c.MinApprovalRate < 0 || *c.MinApprovalRate > 1) {
+		return fmt.Errorf("%w: min_approval_rate must be between 0 and 1", ErrAutoApprovalRuleInvalid)
+	}
+	if c.MinDecidedRequests < 0 {
+		return fmt.Errorf("%w: min_decided_requests can't be negative", ErrAutoApprovalRuleInvalid)
+	}
+	if c.MinDecidedRequests > 0 && c.MinApprovalRate == nil {
+		return fmt.Errorf("%w: min_decided_requests needs min_approval_rate", ErrAutoApprovalRuleInvalid)
+	}
+
+	if len(c.Roles) == 0 && c.MinAccountAgeDays == nil && len(c.ChannelIDs) == 0 &&
+		c.MinSubscribers == nil && c.MinVideoAgeDays == nil && c.MinApprovalRate == nil {
+		return fmt.Errorf("%w: a rule needs at least one condition", ErrAutoApprovalRuleInvalid)
+	}
+
+	return nil
+}
+
+// NeedsSubscribers reports whether checking the conditions takes the
+// channel's subscriber count, which costs a youtube call.
+func (c AutoApprovalConditions) NeedsSubscribers() bool {
+	return c.MinSubscribers != nil
+}
+
+// Matches reports whether facts pass every condition that is set.
+func (c AutoApprovalConditions) Matches(facts AutoApprovalFacts, now time.Time) bool {
+	const day = 24 * time.Hour
+
+	if len(c.Roles) > 0 && !containsString(c.Roles, facts.Role) {
+		return false
+	}
+
+	if c.MinAccountAgeDays != nil && now.Sub(facts.AccountCreatedAt) < time.Duration(*c.MinAccountAgeDays)*day {
+		return false
+	}
+
+	if len(c.ChannelIDs) > 0 && !containsString(c.ChannelIDs, facts.ChannelID) {
+		return false
+	}
+
+	if c.MinSubscribers != nil && (facts.Subscribers == nil || *facts.Subscribers < *c.MinSubscribers) {
+		return false
+	}
+
+	if c.MinVideoAgeDays != nil && now.Sub(facts.PublishedAt) < time.Duration(*c.MinVideoAgeDays)*day {
+		return false
+	}
+
+	if c.MinApprovalRate != nil {
+		decided := facts.AcceptedRequests + facts.RejectedRequests
+		// no history is no approval rate, not a perfect one
+		if decided == 0 || decided < c.MinDecidedRequests {
+			return false
+		}
+		if float64(facts.AcceptedRequests)/float64(decided) < *c.MinApprovalRate {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type AutoApprovalRule struct {
+	ID         uuid.UUID              `json:"id"`
+	Name       string                 `json:"name"`
+	Enabled    bool                   `json:"enabled"`
+	Priority   int                    `json:"priority"`
+	Conditions AutoApprovalConditions `json:"conditions"`
+	CreatedBy  *uuid.UUID             `json:"created_by"`
+	Created_At time.Time              `json:"created_at"`
+	Updated_At time.Time              `json:"updated_at"`
+	// set once the rule is deleted, it's kept for the requests it approved
+	Deleted_At *time.Time `json:"deleted_at"`
+}
+
+type AdminPostgresAutoApprovalStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAdminAutoApprovalStore(db *sql.DB) *AdminPostgresAutoApprovalStore {
+	return &AdminPostgresAutoApprovalStore{db: db}
+}
+
+type AdminAutoApprovalStore interface {
+	GetAutoApprovalRules(enabledOnly bool, includeDeleted bool) ([]AutoApprovalRule, error)
+	GetAutoApprovalRule(ruleID uuid.UUID) (*AutoApprovalRule, error)
+	CreateAutoApprovalRule(rule *AutoApprovalRule, actor AuditActor) error
+	UpdateAutoApprovalRule(rule *AutoApprovalRule, actor AuditActor) error
+	DeleteAutoApprovalRule(ruleID uuid.UUID, actor AuditActor) error
+}
+
+const autoApprovalRuleColumns = `r.id, r.name, r.enabled, r.priority, r.conditions, r.created_by, r.created_at, r.updated_at, r.deleted_at`
+
+func scanAutoApprovalRule(row interface{ Scan(...interface{}) error }) (*AutoApprovalRule, error) {
+	var rule AutoApprovalRule
+	var conditions []byte
+
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Enabled, &rule.Priority, &conditions, &rule.CreatedBy, &rule.Created_At, &rule.Updated_At, &rule.Deleted_At)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(conditions, &rule.Conditions); err != nil {
+		return nil, fmt.Errorf("failed to decode conditions of rule %s: %w", rule.ID, err)
+	}
+
+	return &rule, nil
+}
+
+// GetAutoApprovalRules returns the rules in the order they are tried.
+// Deleted rules are only there with includeDeleted, to look up the rule that
+// approved a request.
+func (a *AdminPostgresAutoApprovalStore) GetAutoApprovalRules(enabledOnly bool, includeDeleted bool) ([]AutoApprovalRule, error) {
+	query := `
+		SELECT ` + autoApprovalRuleColumns + `
+		FROM auto_approval_rules r
+		WHERE (r.enabled OR NOT $1) AND (r.deleted_at IS NULL OR $2)
+		ORDER BY r.priority DESC, r.created_at
+	`
+
+	rows, err := a.db.Query(query, enabledOnly, includeDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auto approval rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []AutoApprovalRule{}
+
+	for rows.Next() {
+		rule, err := scanAutoApprovalRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan auto approval rule: %w", err)
+		}
+		rules = append(rules, *rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over auto approval rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+func (a *AdminPostgresAutoApprovalStore) GetAutoApprovalRule(ruleID uuid.UUID) (*AutoApprovalRule, error) {
+	query := `
+		SELECT ` + autoApprovalRuleColumns + `
+		FROM auto_approval_rules r
+		WHERE r.id = $1 AND r.deleted_at IS NULL
+	`
+
+	rule, err := scanAutoApprovalRule(a.db.QueryRow(query, ruleID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAutoApprovalRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auto approval rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+// autoApprovalRuleSnapshot is the rule's row as JSON, for the audit log.
+// Deleted rules are not found.
+func autoApprovalRuleSnapshot(q queryRower, ruleID uuid.UUID, forUpdate bool) (json.RawMessage, error) {
+	query := `
+		SELECT row_to_json(r)
+		FROM auto_approval_rules r
+		WHERE r.id = $1 AND r.deleted_at IS NULL
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var snapshot []byte
+	err := q.QueryRow(query, ruleID).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, ErrAutoApprovalRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auto approval rule: %w", err)
+	}
+
+	return json.RawMessage(snapshot), nil
+}
+
+// recheckPendingRequests has the rules tried again on every pending request
+// on the next run, with fresh attempts, after they changed in a way that
+// could approve more of them.
+func recheckPendingRequests(e execer) error {
+	query := `
+		UPDATE video_requests
+		SET auto_approval_next_check_at = NULL, auto_approval_failures = 0
+		WHERE status = 'PENDING' AND (auto_approval_next_check_at IS NOT NULL OR auto_approval_failures > 0)
+	`
+
+	if _, err := e.Exec(query); err != nil {
+		return fmt.Errorf("failed to reset auto approval checks: %w", err)
+	}
+
+	return nil
+}
+
+// CreateAutoApprovalRule adds rule, filling in its id and timestamps, and
+// audits it.
+func (a *AdminPostgresAutoApprovalStore) CreateAutoApprovalRule(rule *AutoApprovalRule, actor AuditActor) error {
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return fmt.Errorf("failed to encode conditions: %w", err)
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	query := `
+		INSERT INTO auto_approval_rules (name, enabled, priority, conditions, created_by)
+		VALUES ($1, $2, $3, $4::jsonb, $5)
+		RETURNING id, created_by, created_at, updated_at
+	`
+
+	err = tx.QueryRow(query, rule.Name, rule.Enabled, rule.Priority, string(conditions), actor.ID).
+		Scan(&rule.ID, &rule.CreatedBy, &rule.Created_At, &rule.Updated_At)
+	if err != nil {
+		return fmt.Errorf("failed to create auto approval rule: %w", err)
+	}
+
+	after, err := autoApprovalRuleSnapshot(tx, rule.ID, false)
+	if err != nil {
+		return err
+	}
+
+	err = recordAuditEntry(tx, actor, AuditActionAutoApprovalRuleCreate, AuditTargetAutoApprovalRule, rule.ID.String(), nil, after)
+	if err != nil {
+		return err
+	}
+
+	if rule.Enabled {
+		if err := recheckPendingRequests(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateAutoApprovalRule saves rule over the stored one, updating its
+// updated_at, and audits the change.
+func (a *AdminPostgresAutoApprovalStore) UpdateAutoApprovalRule(rule *AutoApprovalRule, actor AuditActor) error {
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return fmt.Errorf("failed to encode conditions: %w", err)
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	before, err := autoApprovalRuleSnapshot(tx, rule.ID, true)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE auto_approval_rules
+		SET name = $2, enabled = $3, priority = $4, conditions = $5::jsonb, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err = tx.QueryRow(query, rule.ID, rule.Name, rule.Enabled, rule.Priority, string(conditions)).Scan(&rule.Updated_At)
+	if err != nil {
+		return fmt.Errorf("failed to update auto approval rule: %w", err)
+	}
+
+	after, err := autoApprovalRuleSnapshot(tx, rule.ID, false)
+	if err != nil {
+		return err
+	}
+
+	err = recordAuditEntry(tx, actor, AuditActionAutoApprovalRuleUpdate, AuditTargetAutoApprovalRule, rule.ID.String(), before, after)
+	if err != nil {
+		return err
+	}
+
+	if rule.Enabled {
+		if err := recheckPendingRequests(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAutoApprovalRule deletes a rule and audits it. The rule is only
+// marked deleted, so the requests it approved still record it.
+func (a *AdminPostgresAutoApprovalStore) DeleteAutoApprovalRule(ruleID uuid.UUID, actor AuditActor) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	before, err := autoApprovalRuleSnapshot(tx, ruleID, true)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE auto_approval_rules
+		SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	_, err = tx.Exec(query, ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete auto approval rule: %w", err)
+	}
+
+	err = recordAuditEntry(tx, actor, AuditActionAutoApprovalRuleDelete, AuditTargetAutoApprovalRule, ruleID.String(), before, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
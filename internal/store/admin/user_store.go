@@ -6,6 +6,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/store"
+	"github.com/jackc/pgtype"
 )
 
 type AdminPostgresUserStore struct {
@@ -18,21 +20,124 @@ func NewPostgresAdminUserStore(db *sql.DB) *AdminPostgresUserStore {
 
 type AdminUserStore interface {
 	GetUserByID(UserID uuid.UUID) (*models.User, error)
+	GetRoles() ([]models.Role, error)
+	UpdateUserRole(userID uuid.UUID, role string, actor AuditActor) (bool, error)
 }
 
 func (a *AdminPostgresUserStore) GetUserByID(UserID uuid.UUID) (*models.User, error) {
 	user := &models.User{}
+	var permissions pgtype.TextArray
 
 	query := `
-		SELECT id, role, name, image, videos_tracked
-		FROM users
-		WHERE id = $1
+		SELECT u.id, u.role, u.name, u.image, u.videos_tracked, ` + store.UserPermissionsColumn + `
+		FROM users u
+		WHERE u.id = $1
 	`
 
-	err := a.db.QueryRow(query, UserID).Scan(&user.ID, &user.Role, &user.Name, &user.ImageSrc, &user.Videos_Tracked)
+	err := a.db.QueryRow(query, UserID).Scan(&user.ID, &user.Role, &user.Name, &user.ImageSrc, &user.Videos_Tracked, &permissions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to select user: %w", err)
 	}
 
+	if err := permissions.AssignTo(&user.Permissions); err != nil {
+		return nil, fmt.Errorf("failed to read user permissions: %w", err)
+	}
+
 	return user, nil
 }
+
+// GetRoles returns every role with the permissions it grants.
+func (a *AdminPostgresUserStore) GetRoles() ([]models.Role, error) {
+	query := `
+		SELECT r.name, r.description,
+			ARRAY(SELECT rp.permission FROM role_permissions rp WHERE rp.role = r.name ORDER BY rp.permission)
+		FROM roles r
+		ORDER BY r.name
+	`
+
+	rows, err := a.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+
+	for rows.Next() {
+		var role models.Role
+		var permissions pgtype.TextArray
+
+		if err := rows.Scan(&role.Name, &role.Description, &permissions); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+
+		if err := permissions.AssignTo(&role.Permissions); err != nil {
+			return nil, fmt.Errorf("failed to read role permissions: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// UpdateUserRole gives the user role, which must exist, and audits the
+// change. It reports false when there is no such user.
+func (a *AdminPostgresUserStore) UpdateUserRole(userID uuid.UUID, role string, actor AuditActor) (bool, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	snapshotQuery := `
+		SELECT json_build_object('role', role)
+		FROM users
+		WHERE id = $1
+	`
+
+	var before []byte
+	err = tx.QueryRow(snapshotQuery+" FOR UPDATE", userID).Scan(&before)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read user role: %w", err)
+	}
+
+	query := `
+		UPDATE users
+		SET role = $2, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err = tx.Exec(query, userID, role)
+	if err != nil {
+		return false, fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	var after []byte
+	err = tx.QueryRow(snapshotQuery, userID).Scan(&after)
+	if err != nil {
+		return false, fmt.Errorf("failed to read user role: %w", err)
+	}
+
+	err = recordAuditEntry(tx, actor, AuditActionUserRoleUpdate, AuditTargetUser, userID.String(), before, after)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,226 @@
+package admin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/models"
+)
+
+// Orders of the request queue, by when requests were made.
+const (
+	VideoRequestSortOldest = "oldest"
+	VideoRequestSortNewest = "newest"
+)
+
+// DefaultVideoRequestStatuses are the statuses the queue shows without a
+// filter, the requests still waiting for a decision.
+var DefaultVideoRequestStatuses = []string{
+	models.VideoRequestStatusPending,
+	models.VideoRequestStatusUnderReview,
+}
+
+var ErrInvalidVideoRequestCursor = errors.New("invalid cursor")
+
+// VideoRequestQueryParams filters the request queue. Zero values don't
+// filter. Cursor continues from the NextCursor of a page, with the same
+// filters and sort.
+type VideoRequestQueryParams struct {
+	Statuses  []string
+	UserID    *uuid.UUID
+	ChannelID string
+	Since     *time.Time
+	Until     *time.Time
+	Sort      string
+	Cursor    *VideoRequestCursor
+	Limit     int
+}
+
+// VideoRequestCursor is the last request of a page, the next one starts
+// after it.
+type VideoRequestCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Encode is the cursor as an opaque string for the client to send back.
+func (c VideoRequestCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeVideoRequestCursor(value string) (*VideoRequestCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidVideoRequestCursor
+	}
+
+	createdAtStr, idStr, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return nil, ErrInvalidVideoRequestCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return nil, ErrInvalidVideoRequestCursor
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, ErrInvalidVideoRequestCursor
+	}
+
+	return &VideoRequestCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// VideoRequestPage is a page of the queue. NextCursor is nil on the last
+// page. Counts has the number of requests of each status matching the
+// filters other than the statuses, for the tabs of the queue.
+type VideoRequestPage struct {
+	Requests   []AdminVideoRequest
+	NextCursor *VideoRequestCursor
+	Counts     map[string]int
+}
+
+// GetVideoRequests returns a page of the request queue.
+func (a *AdminPostgresVideoRequestStore) GetVideoRequests(params VideoRequestQueryParams) (*VideoRequestPage, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if params.UserID != nil {
+		addCondition("vr.user_id = $%d", *params.UserID)
+	}
+	if params.ChannelID != "" {
+		addCondition("vr.channel_id = $%d", params.ChannelID)
+	}
+	if params.Since != nil {
+		addCondition("vr.created_at >= $%d", *params.Since)
+	}
+	if params.Until != nil {
+		addCondition("vr.created_at < $%d", *params.Until)
+	}
+
+	counts, err := a.countVideoRequests(conditions, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params.Statuses) > 0 {
+		placeholders := make([]string, len(params.Statuses))
+		for i, status := range params.Statuses {
+			args = append(args, status)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		// compared as the enum, not as text, so the queue index is used
+		conditions = append(conditions, "vr.status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	order := "ASC"
+	comparison := ">"
+	if params.Sort == VideoRequestSortNewest {
+		order = "DESC"
+		comparison = "<"
+	}
+
+	if params.Cursor != nil {
+		args = append(args, params.Cursor.CreatedAt, params.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(vr.created_at, vr.id) %s ($%d, $%d)", comparison, len(args)-1, len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// one more than the page, to know whether there is a next one
+	args = append(args, params.Limit+1)
+	query := fmt.Sprintf(`
+		SELECT `+adminVideoRequestColumns+`
+		FROM video_requests vr
+		JOIN users u ON vr.user_id = u.id
+		%s
+		ORDER BY vr.created_at %s, vr.id %s
+		LIMIT $%d
+	`, where, order, order, len(args))
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch video requests: %w", err)
+	}
+	defer rows.Close()
+
+	page := &VideoRequestPage{
+		Requests: []AdminVideoRequest{},
+		Counts:   counts,
+	}
+
+	for rows.Next() {
+		videoReq, err := scanAdminVideoRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		page.Requests = append(page.Requests, *videoReq)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	if len(page.Requests) > params.Limit {
+		page.Requests = page.Requests[:params.Limit]
+		last := page.Requests[len(page.Requests)-1]
+		page.NextCursor = &VideoRequestCursor{CreatedAt: last.Created_At, ID: last.Id}
+	}
+
+	return page, nil
+}
+
+// countVideoRequests counts the requests matching conditions by status.
+func (a *AdminPostgresVideoRequestStore) countVideoRequests(conditions []string, args []interface{}) (map[string]int, error) {
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		SELECT vr.status, COUNT(*)
+		FROM video_requests vr
+		` + where + `
+		GROUP BY vr.status
+	`
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count video requests: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for status := range videoRequestTransitions {
+		counts[status] = 0
+	}
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan video request count: %w", err)
+		}
+		counts[status] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grvbrk/nazrein_server/internal/models"
+)
+
+var (
+	ErrInvalidVideoRequestTransition = errors.New("invalid video request status change")
+	ErrVideoRequestStale             = errors.New("video request was changed since it was read")
+	ErrRejectionReasonRequired       = errors.New("a rejection needs a reason")
+	ErrRejectionReasonNotAllowed     = errors.New("only rejected requests have a reason")
+)
+
+// VideoRequestVersionPrecision is the precision updated_at is compared at to
+// detect stale changes. Clients keeping it in a JavaScript Date only have
+// milliseconds, so the microseconds postgres stores are ignored.
+const VideoRequestVersionPrecision = time.Millisecond
+
+// videoRequestTransitions are the status changes an admin can make. A
+// request is accepted by approving it, which creates its video, so ACCEPTED
+// is only reached through ApproveVideoRequest and never left.
+var videoRequestTransitions = map[string][]string{
+	models.VideoRequestStatusPending: {
+		models.VideoRequestStatusUnderReview,
+		models.VideoRequestStatusAccepted,
+		models.VideoRequestStatusRejected,
+	},
+	models.VideoRequestStatusUnderReview: {
+		models.VideoRequestStatusPending,
+		models.VideoRequestStatusAccepted,
+		models.VideoRequestStatusRejected,
+	},
+	models.VideoRequestStatusRejected: {
+		models.VideoRequestStatusUnderReview,
+	},
+	models.VideoRequestStatusAccepted: {},
+}
+
+// ValidateVideoRequestStatus reports whether status is a known status.
+func ValidateVideoRequestStatus(status string) bool {
+	_, ok := videoRequestTransitions[status]
+	return ok
+}
+
+// checkVideoRequestTransition errors unless a request can go from one status
+// to the other.
+func checkVideoRequestTransition(from string, to string) error {
+	for _, allowed := range videoRequestTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s to %s", ErrInvalidVideoRequestTransition, from, to)
+}
+
+// rejectionReasonFor is the reason a request in status has after a change,
+// given the one it had and the one the change sets, if any. Rejected
+// requests must have one, others can't.
+func rejectionReasonFor(status string, current *string, update *string) (*string, error) {
+	if status != models.VideoRequestStatusRejected {
+		if update != nil && strings.TrimSpace(*update) != "" {
+			return nil, ErrRejectionReasonNotAllowed
+		}
+		return nil, nil
+	}
+
+	reason := current
+	if update != nil {
+		reason = update
+	}
+	if reason == nil || strings.TrimSpace(*reason) == "" {
+		return nil, ErrRejectionReasonRequired
+	}
+
+	trimmed := strings.TrimSpace(*reason)
+	return &trimmed, nil
+}
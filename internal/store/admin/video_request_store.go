@@ -2,13 +2,86 @@ package admin
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/models"
 )
 
+var ErrVideoRequestNotFound = errors.New("video request not found")
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// videoRequestState is a request as status changes are checked against,
+// with its row as JSON for the audit log.
+type videoRequestState struct {
+	UserID          uuid.UUID
+	Link            string
+	YoutubeID       string
+	Status          string
+	RejectionReason *string
+	UpdatedAt       time.Time
+	Snapshot        json.RawMessage
+}
+
+// lockVideoRequest reads the request and locks it until the end of the
+// transaction.
+func lockVideoRequest(q queryRower, requestID uuid.UUID) (*videoRequestState, error) {
+	query := `
+		SELECT vr.user_id, vr.link, vr.youtube_id, vr.status, vr.rejection_reason, vr.updated_at, row_to_json(vr)
+		FROM video_requests vr
+		WHERE vr.id = $1
+		FOR UPDATE
+	`
+
+	var state videoRequestState
+	var snapshot []byte
+	err := q.QueryRow(query, requestID).Scan(&state.UserID, &state.Link, &state.YoutubeID, &state.Status, &state.RejectionReason, &state.UpdatedAt, &snapshot)
+	if err == sql.ErrNoRows {
+		return nil, ErrVideoRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read video request: %w", err)
+	}
+
+	state.Snapshot = json.RawMessage(snapshot)
+	return &state, nil
+}
+
+// videoRequestSnapshot is the request's row as JSON, for the audit log.
+func videoRequestSnapshot(q queryRower, requestID uuid.UUID) (json.RawMessage, error) {
+	query := `
+		SELECT row_to_json(vr)
+		FROM video_requests vr
+		WHERE vr.id = $1
+	`
+
+	var snapshot []byte
+	err := q.QueryRow(query, requestID).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, ErrVideoRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read video request: %w", err)
+	}
+
+	return json.RawMessage(snapshot), nil
+}
+
+// VideoRequestUpdate is an admin's change to a request. An empty Status
+// keeps the status. UpdatedAt is the updated_at of the request as the admin
+// saw it, the update is refused if the request changed since.
+type VideoRequestUpdate struct {
+	Status          string
+	RejectionReason *string
+	UpdatedAt       time.Time
+}
+
 type AdminPostgresVideoRequestStore struct {
 	db *sql.DB
 }
@@ -18,8 +91,130 @@ func NewPostgresAdminVideoRequestStore(db *sql.DB) *AdminPostgresVideoRequestSto
 }
 
 type AdminVideoRequestStore interface {
+	GetVideoRequests(params VideoRequestQueryParams) (*VideoRequestPage, error)
+	GetVideoRequestByID(requestID uuid.UUID) (*AdminVideoRequest, error)
+	GetAutoApprovalCandidates(limit int) ([]AutoApprovalCandidate, error)
+	MarkAutoApprovalChecked(requestID uuid.UUID, nextCheckAt time.Time) error
+	MarkAutoApprovalFailed(requestID uuid.UUID, nextCheckAt time.Time) error
 	DeleteVideoRequest(requestID uuid.UUID) error
-	PatchVideoRequest(requestID uuid.UUID, status *string, processedBy *string, rejectionReason *string) error
+	PatchVideoRequest(requestID uuid.UUID, update VideoRequestUpdate, actor AuditActor) (time.Time, error)
+}
+
+// GetVideoRequestByID reads a request and its requester. It errors with
+// ErrVideoRequestNotFound when there is no such request.
+func (a *AdminPostgresVideoRequestStore) GetVideoRequestByID(requestID uuid.UUID) (*AdminVideoRequest, error) {
+	query := `
+		SELECT ` + adminVideoRequestColumns + `
+		FROM video_requests vr
+		JOIN users u ON vr.user_id = u.id
+		WHERE vr.id = $1
+	`
+
+	videoReq, err := scanAdminVideoRequest(a.db.QueryRow(query, requestID))
+	if err == sql.ErrNoRows {
+		return nil, ErrVideoRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch video request: %w", err)
+	}
+
+	return videoReq, nil
+}
+
+// MaxAutoApprovalFailures is how many times approving a request a rule
+// matched may fail before it's left for an admin.
+const MaxAutoApprovalFailures = 5
+
+// AutoApprovalCandidate is a pending request due for the auto approval
+// rules, with what the rules need to know about its requester. Failures is
+// how many times approving it failed in a row.
+type AutoApprovalCandidate struct {
+	RequestID        uuid.UUID
+	YoutubeID        string
+	UserID           uuid.UUID
+	Role             string
+	AccountCreatedAt time.Time
+	AcceptedRequests int
+	RejectedRequests int
+	Failures         int
+}
+
+// GetAutoApprovalCandidates returns up to limit pending requests due for the
+// rules, oldest first: the ones never tried and the ones whose next check
+// has come, skipping those out of attempts.
+func (a *AdminPostgresVideoRequestStore) GetAutoApprovalCandidates(limit int) ([]AutoApprovalCandidate, error) {
+	query := `
+		SELECT vr.id, vr.youtube_id, u.id, u.role, u.created_at,
+			(SELECT COUNT(*) FROM video_requests p WHERE p.user_id = u.id AND p.status = 'ACCEPTED'),
+			(SELECT COUNT(*) FROM video_requests p WHERE p.user_id = u.id AND p.status = 'REJECTED'),
+			vr.auto_approval_failures
+		FROM video_requests vr
+		JOIN users u ON vr.user_id = u.id
+		WHERE vr.status = 'PENDING'
+			AND (vr.auto_approval_next_check_at IS NULL OR vr.auto_approval_next_check_at <= NOW())
+			AND vr.auto_approval_failures < $2
+		ORDER BY vr.created_at
+		LIMIT $1
+	`
+
+	rows, err := a.db.Query(query, limit, MaxAutoApprovalFailures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auto approval candidates: %w", err)
+	}
+	defer rows.Close()
+
+	candidates := []AutoApprovalCandidate{}
+
+	for rows.Next() {
+		var c AutoApprovalCandidate
+		err := rows.Scan(&c.RequestID, &c.YoutubeID, &c.UserID, &c.Role, &c.AccountCreatedAt,
+			&c.AcceptedRequests, &c.RejectedRequests, &c.Failures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan auto approval candidate: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over auto approval candidates: %w", err)
+	}
+
+	return candidates, nil
+}
+
+// MarkAutoApprovalChecked records that no rule approved the request, so it
+// isn't tried again until nextCheckAt, or the rules change.
+func (a *AdminPostgresVideoRequestStore) MarkAutoApprovalChecked(requestID uuid.UUID, nextCheckAt time.Time) error {
+	query := `
+		UPDATE video_requests
+		SET auto_approval_checked_at = NOW(), auto_approval_next_check_at = $2, auto_approval_failures = 0
+		WHERE id = $1
+	`
+
+	_, err := a.db.Exec(query, requestID, nextCheckAt)
+	if err != nil {
+		return fmt.Errorf("failed to mark auto approval checked: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAutoApprovalFailed records a failed attempt at approving the request,
+// so it's retried at nextCheckAt, while it has attempts left.
+func (a *AdminPostgresVideoRequestStore) MarkAutoApprovalFailed(requestID uuid.UUID, nextCheckAt time.Time) error {
+	query := `
+		UPDATE video_requests
+		SET auto_approval_checked_at = NOW(), auto_approval_next_check_at = $2,
+			auto_approval_failures = auto_approval_failures + 1
+		WHERE id = $1
+	`
+
+	_, err := a.db.Exec(query, requestID, nextCheckAt)
+	if err != nil {
+		return fmt.Errorf("failed to mark auto approval failed: %w", err)
+	}
+
+	return nil
 }
 
 func (a *AdminPostgresVideoRequestStore) DeleteVideoRequest(requestID uuid.UUID) error {
@@ -36,55 +231,93 @@ func (a *AdminPostgresVideoRequestStore) DeleteVideoRequest(requestID uuid.UUID)
 	return nil
 }
 
-func (a *AdminPostgresVideoRequestStore) PatchVideoRequest(
-	requestID uuid.UUID,
-	status *string,
-	processedBy *string,
-	rejectionReason *string,
-) error {
+// PatchVideoRequest changes the status or rejection reason of a request, as
+// allowed by videoRequestTransitions, and audits the change. The request is
+// marked processed by the admin, unless it goes back to PENDING. It returns
+// the request's new updated_at.
+func (a *AdminPostgresVideoRequestStore) PatchVideoRequest(requestID uuid.UUID, update VideoRequestUpdate, actor AuditActor) (time.Time, error) {
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 
-	setClauses := []string{}
-	args := []interface{}{}
-	argPos := 1
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
 
-	if status != nil {
-		setClauses = append(setClauses, fmt.Sprintf("status = $%d", argPos))
-		args = append(args, *status)
-		argPos++
+	current, err := lockVideoRequest(tx, requestID)
+	if err != nil {
+		return time.Time{}, err
 	}
-	if processedBy != nil {
-		setClauses = append(setClauses, fmt.Sprintf("processed_by = $%d", argPos))
-		args = append(args, *processedBy)
-		argPos++
+
+	if !current.UpdatedAt.Truncate(VideoRequestVersionPrecision).Equal(update.UpdatedAt.Truncate(VideoRequestVersionPrecision)) {
+		return time.Time{}, ErrVideoRequestStale
 	}
-	if rejectionReason != nil {
-		setClauses = append(setClauses, fmt.Sprintf("rejection_reason = $%d", argPos))
-		args = append(args, *rejectionReason)
-		argPos++
+
+	status := update.Status
+	if status == "" {
+		status = current.Status
 	}
 
-	if len(setClauses) == 0 {
-		return fmt.Errorf("no fields provided to update")
+	if status != current.Status {
+		if status == models.VideoRequestStatusAccepted {
+			return time.Time{}, fmt.Errorf("%w: requests are accepted by approving them", ErrInvalidVideoRequestTransition)
+		}
+		if err := checkVideoRequestTransition(current.Status, status); err != nil {
+			return time.Time{}, err
+		}
+	} else if status != models.VideoRequestStatusRejected || update.RejectionReason == nil {
+		// only a rejection's reason can change without the status
+		return time.Time{}, fmt.Errorf("%w: %s to %s", ErrInvalidVideoRequestTransition, current.Status, status)
 	}
 
-	setClauses = append(setClauses, fmt.Sprintf("processed_at = $%d", argPos))
-	args = append(args, time.Now())
-	argPos++
+	rejectionReason, err := rejectionReasonFor(status, current.RejectionReason, update.RejectionReason)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-	setClauses = append(setClauses, "updated_at = CURRENT_TIMESTAMP")
+	var processedBy *uuid.UUID
+	var processedAt *time.Time
+	if status != models.VideoRequestStatusPending {
+		now := time.Now()
+		processedBy = &actor.ID
+		processedAt = &now
+	}
 
-	query := fmt.Sprintf(`
+	query := `
 		UPDATE video_requests
-		SET %s
-		WHERE id = $%d
-	`, strings.Join(setClauses, ", "), argPos)
+		SET status = $2, rejection_reason = $3, processed_by = $4, processed_at = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
 
-	args = append(args, requestID)
+	var updatedAt time.Time
+	err = tx.QueryRow(query, requestID, status, rejectionReason, processedBy, processedAt).Scan(&updatedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ADMIN: failed to update video request: %w", err)
+	}
 
-	_, err := a.db.Exec(query, args...)
+	after, err := videoRequestSnapshot(tx, requestID)
 	if err != nil {
-		return fmt.Errorf("ADMIN: failed to update video request: %w", err)
+		return time.Time{}, err
 	}
 
-	return nil
+	action := AuditActionVideoRequestUpdate
+	if status == models.VideoRequestStatusRejected {
+		action = AuditActionVideoRequestReject
+	}
+
+	err = recordAuditEntry(tx, actor, action, AuditTargetVideoRequest, requestID.String(), current.Snapshot, after)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return updatedAt, nil
 }
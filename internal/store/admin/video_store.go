@@ -7,15 +7,23 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/grvbrk/nazrein_server/internal/store"
 )
 
 type AdminVideoRequest struct {
-	Id         uuid.UUID   `json:"id"`
-	Status     string      `json:"status"`
-	Link       string      `json:"link"`
-	Youtube_ID string      `json:"youtube_id"`
-	User       models.User `json:"user"`
-	Created_At time.Time   `json:"created_at"`
+	Id              uuid.UUID `json:"id"`
+	Status          string    `json:"status"`
+	Link            string    `json:"link"`
+	Youtube_ID      string    `json:"youtube_id"`
+	Channel_ID      *string   `json:"channel_id"`
+	Channel_Title   *string   `json:"channel_title"`
+	RejectionReason *string   `json:"rejection_reason"`
+	// the rule that approved the request, when no admin did
+	AutoApprovalRuleID *uuid.UUID  `json:"auto_approval_rule_id"`
+	User               models.User `json:"user"`
+	Created_At         time.Time   `json:"created_at"`
+	// the version to send back with changes to the request
+	Updated_At time.Time `json:"updated_at"`
 }
 
 type AdminPostgresVideoStore struct {
@@ -27,100 +35,176 @@ func NewPostgresAdminVideoStore(db *sql.DB) *AdminPostgresVideoStore {
 }
 
 type AdminVideoStore interface {
-	GetAllVideoRequest() ([]AdminVideoRequest, error)
-	CreateVideo(video *models.Video, userId uuid.UUID, requestID uuid.UUID) error
+	ApproveVideoRequest(requestID uuid.UUID, details *models.Video, actor AuditActor) (bool, error)
+	AutoApproveVideoRequest(requestID uuid.UUID, details *models.Video, rule *AutoApprovalRule) (bool, error)
 }
 
-func (a *AdminPostgresVideoStore) GetAllVideoRequest() ([]AdminVideoRequest, error) {
+// adminVideoRequestColumns are the columns scanAdminVideoRequest reads, of
+// video_requests vr joined with its requester u.
+const adminVideoRequestColumns = `vr.id, vr.status, vr.link, vr.youtube_id, vr.channel_id, vr.channel_title, vr.rejection_reason, vr.auto_approval_rule_id, vr.created_at, vr.updated_at, u.id, u.name, u.image, u.videos_tracked`
+
+func scanAdminVideoRequest(row interface{ Scan(...interface{}) error }) (*AdminVideoRequest, error) {
+	var videoReq AdminVideoRequest
+
+	err := row.Scan(
+		&videoReq.Id,
+		&videoReq.Status,
+		&videoReq.Link,
+		&videoReq.Youtube_ID,
+		&videoReq.Channel_ID,
+		&videoReq.Channel_Title,
+		&videoReq.RejectionReason,
+		&videoReq.AutoApprovalRuleID,
+		&videoReq.Created_At,
+		&videoReq.Updated_At,
+		&videoReq.User.ID,
+		&videoReq.User.Name,
+		&videoReq.User.ImageSrc,
+		&videoReq.User.Videos_Tracked,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	results := []AdminVideoRequest{}
+	return &videoReq, nil
+}
 
-	query := `
-		SELECT vr.id, vr.status, vr.link, vr.youtube_id, vr.created_at, u.id, u.name, u.image, u.videos_tracked
-		FROM video_requests vr
-		JOIN users u ON vr.user_id = u.id;
-	`
+// ApproveVideoRequest accepts a request and has its requester watch its
+// video, tracking it if it isn't yet, in one transaction. The link, youtube
+// id and requester come from the stored request, details only gives what
+// YouTube says about the video. The request is marked processed by the
+// admin, and the approval audited. Approving an accepted request does
+// nothing, it returns false. store.ErrTrackLimitReached is returned when the
+// requester can't watch another video.
+func (a *AdminPostgresVideoStore) ApproveVideoRequest(requestID uuid.UUID, details *models.Video, actor AuditActor) (bool, error) {
+	return a.approveVideoRequest(requestID, details, actor, nil)
+}
 
-	rows, err := a.db.Query(query)
+// AutoApproveVideoRequest is ApproveVideoRequest for a request rule matched.
+// The request is marked with the rule instead of an admin, and only approved
+// while still PENDING: once an admin has it under review it's theirs.
+func (a *AdminPostgresVideoStore) AutoApproveVideoRequest(requestID uuid.UUID, details *models.Video, rule *AutoApprovalRule) (bool, error) {
+	return a.approveVideoRequest(requestID, details, AutoApprovalActor, rule)
+}
+
+func (a *AdminPostgresVideoStore) approveVideoRequest(requestID uuid.UUID, details *models.Video, actor AuditActor, rule *AutoApprovalRule) (bool, error) {
+
+	tx, err := a.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch all video request: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	defer rows.Close()
-
-	for rows.Next() {
-		var videoReq AdminVideoRequest
-		var user models.User
-
-		err := rows.Scan(
-			&videoReq.Id,
-			&videoReq.Status,
-			&videoReq.Link,
-			&videoReq.Youtube_ID,
-			&videoReq.Created_At,
-			&user.ID,
-			&user.Name,
-			&user.ImageSrc,
-			&user.Videos_Tracked,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
 		}
+	}()
 
-		videoReq.User = user
-		results = append(results, videoReq)
+	current, err := lockVideoRequest(tx, requestID)
+	if err != nil {
+		return false, err
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+	if current.Status == models.VideoRequestStatusAccepted {
+		return false, nil
 	}
 
-	return results, nil
-
-}
+	if rule != nil && current.Status != models.VideoRequestStatusPending {
+		return false, nil
+	}
 
-func (a *AdminPostgresVideoStore) CreateVideo(video *models.Video, userId uuid.UUID, requestID uuid.UUID) error {
+	if err := checkVideoRequestTransition(current.Status, models.VideoRequestStatusAccepted); err != nil {
+		return false, err
+	}
 
-	tx, err := a.db.Begin()
+	// the requester's row stays locked until commit, so concurrent
+	// approvals for them can't both pass the limit
+	canTrack, err := store.LockUserForTracking(tx, current.UserID)
 	if err != nil {
-		fmt.Println("Error starting transaction", err)
-		return err
+		return false, err
 	}
 
-	defer func() {
-		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
-			fmt.Printf("rollback error: %v", rErr)
+	if !canTrack {
+		var watching bool
+		query := `
+			SELECT EXISTS (
+				SELECT 1 FROM video_watchers w
+				JOIN videos v ON v.id = w.video_id
+				WHERE v.youtube_id = $1 AND w.user_id = $2
+			)
+		`
+
+		err = tx.QueryRow(query, current.YoutubeID, current.UserID).Scan(&watching)
+		if err != nil {
+			return false, fmt.Errorf("failed to check video watcher: %w", err)
 		}
-	}()
 
+		// already watching the video, approving doesn't add to their count
+		if !watching {
+			return false, store.ErrTrackLimitReached
+		}
+	}
+
+	// a video is tracked once, when another user's request for it got there
+	// first the requester just becomes one more watcher
 	query := `
-	INSERT INTO videos (link, published_at, title, description, thumbnail, youtube_id, channel_title, channel_id, user_id, is_active, created_at, updated_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	INSERT INTO videos (link, published_at, title, description, thumbnail, youtube_id, channel_title, channel_id, language, user_id, is_active, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	ON CONFLICT (youtube_id) DO NOTHING
 	`
 
-	_, err = a.db.Exec(query, video.Link, video.Published_At, video.Title, video.Description, video.Thumbnail, video.Youtube_ID, video.Channel_Title, video.Channel_ID, userId, true, time.Now(), time.Now())
+	_, err = tx.Exec(query, current.Link, details.Published_At, details.Title, details.Description, details.Thumbnail, current.YoutubeID, details.Channel_Title, details.Channel_ID, details.Language, current.UserID, true, time.Now(), time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to create video: %w", err)
+	}
 
+	query = `
+		INSERT INTO video_watchers (video_id, user_id, request_id)
+		SELECT v.id, $2, $3
+		FROM videos v
+		WHERE v.youtube_id = $1
+		ON CONFLICT (video_id, user_id) DO NOTHING
+	`
+
+	_, err = tx.Exec(query, current.YoutubeID, current.UserID, requestID)
 	if err != nil {
-		fmt.Println("Error creating video", err)
-		return err
+		return false, fmt.Errorf("failed to add video watcher: %w", err)
+	}
+
+	action := AuditActionVideoRequestApprove
+	processedBy := &actor.ID
+	var ruleID *uuid.UUID
+	if rule != nil {
+		action = AuditActionVideoRequestAutoApprove
+		processedBy = nil
+		ruleID = &rule.ID
 	}
 
 	query = `
 		UPDATE video_requests
-		SET processed_by = $1, processed_at = $2, status = 'ACCEPTED'
-		WHERE id = $3
+		SET processed_by = $1, processed_at = $2, status = 'ACCEPTED', rejection_reason = NULL, auto_approval_rule_id = $3,
+			channel_id = COALESCE(channel_id, NULLIF($5, '')), channel_title = COALESCE(channel_title, NULLIF($6, '')), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
 	`
-	_, err = a.db.Exec(query, userId, time.Now(), requestID)
+	_, err = tx.Exec(query, processedBy, time.Now(), ruleID, requestID, details.Channel_ID, details.Channel_Title)
+	if err != nil {
+		return false, fmt.Errorf("failed to update video request: %w", err)
+	}
+
+	after, err := videoRequestSnapshot(tx, requestID)
+	if err != nil {
+		return false, err
+	}
+
+	err = recordAuditEntry(tx, actor, action, AuditTargetVideoRequest, requestID.String(), current.Snapshot, after)
 	if err != nil {
-		fmt.Println("Error updating video request", err)
-		return err
+		return false, err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		fmt.Println("Error committing transaction", err)
-		return err
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return nil
+	return true, nil
 }
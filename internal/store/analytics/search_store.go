@@ -0,0 +1,277 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type SearchEndpoint string
+
+const (
+	SearchEndpointVideos       SearchEndpoint = "videos"
+	SearchEndpointAutocomplete SearchEndpoint = "autocomplete"
+)
+
+type TrendInterval string
+
+const (
+	TrendIntervalHour TrendInterval = "hour"
+	TrendIntervalDay  TrendInterval = "day"
+)
+
+type ClickhouseSearchStore struct {
+	conn driver.Conn
+}
+
+func NewClickhouseSearchStore(conn driver.Conn) *ClickhouseSearchStore {
+	return &ClickhouseSearchStore{conn: conn}
+}
+
+type SearchQueryEvent struct {
+	SearchedAt      time.Time
+	Query           string
+	Endpoint        SearchEndpoint
+	SearchType      string
+	ResultCount     int
+	Latency         time.Duration
+	IsAuthenticated bool
+}
+
+type SearchQueryStats struct {
+	Query              string    `json:"query"`
+	Searches           uint64    `json:"searches"`
+	ZeroResultSearches uint64    `json:"zero_result_searches"`
+	AvgResults         float64   `json:"avg_results"`
+	AvgLatencyMs       float64   `json:"avg_latency_ms"`
+	LastSearchedAt     time.Time `json:"last_searched_at"`
+}
+
+type SearchTrendPoint struct {
+	Bucket             time.Time `json:"bucket"`
+	Searches           uint64    `json:"searches"`
+	UniqueQueries      uint64    `json:"unique_queries"`
+	ZeroResultSearches uint64    `json:"zero_result_searches"`
+	AvgLatencyMs       float64   `json:"avg_latency_ms"`
+}
+
+// SearchStatsParams narrows the admin reports. An empty Endpoint covers both
+// endpoints and an empty Query covers every query.
+type SearchStatsParams struct {
+	Since    time.Time
+	Endpoint SearchEndpoint
+	Query    string
+	Limit    int
+	Interval TrendInterval
+}
+
+type AnalyticsSearchStore interface {
+	LogSearchQuery(ctx context.Context, event SearchQueryEvent) error
+	GetTopQueries(params SearchStatsParams) ([]SearchQueryStats, error)
+	GetZeroResultQueries(params SearchStatsParams) ([]SearchQueryStats, error)
+	GetQueryTrends(params SearchStatsParams) ([]SearchTrendPoint, error)
+}
+
+func ValidateSearchEndpoint(endpoint string) (SearchEndpoint, bool) {
+	switch SearchEndpoint(endpoint) {
+	case SearchEndpointVideos, SearchEndpointAutocomplete:
+		return SearchEndpoint(endpoint), true
+	}
+	return "", false
+}
+
+func ValidateTrendInterval(interval string) TrendInterval {
+	switch TrendInterval(interval) {
+	case TrendIntervalHour:
+		return TrendIntervalHour
+	default:
+		return TrendIntervalDay
+	}
+}
+
+// NormalizeSearchQuery lowercases a query and collapses its whitespace, so
+// queries that only differ in case or spacing are counted together.
+func NormalizeSearchQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// LogSearchQuery records one search. It uses an async insert, so clickhouse
+// batches the many single row inserts on its side.
+func (c *ClickhouseSearchStore) LogSearchQuery(ctx context.Context, event SearchQueryEvent) error {
+
+	query := `
+		INSERT INTO search_queries (
+			searched_at, query, normalized_query, endpoint, search_type,
+			result_count, latency_ms, is_authenticated
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	err := c.conn.AsyncInsert(ctx, query, false,
+		event.SearchedAt,
+		event.Query,
+		NormalizeSearchQuery(event.Query),
+		string(event.Endpoint),
+		event.SearchType,
+		uint32(event.ResultCount),
+		uint32(event.Latency.Milliseconds()),
+		event.IsAuthenticated,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to log search query: %w", err)
+	}
+
+	return nil
+}
+
+func (params SearchStatsParams) where() (string, []interface{}) {
+	where := []string{"searched_at >= ?"}
+	args := []interface{}{params.Since}
+
+	if params.Endpoint != "" {
+		where = append(where, "endpoint = ?")
+		args = append(args, string(params.Endpoint))
+	}
+	if params.Query != "" {
+		where = append(where, "normalized_query = ?")
+		args = append(args, NormalizeSearchQuery(params.Query))
+	}
+
+	return strings.Join(where, " AND "), args
+}
+
+// GetTopQueries returns the most searched queries since params.Since.
+func (c *ClickhouseSearchStore) GetTopQueries(params SearchStatsParams) ([]SearchQueryStats, error) {
+	where, args := params.where()
+
+	query := fmt.Sprintf(`
+		SELECT
+			normalized_query,
+			count() AS searches,
+			countIf(result_count = 0) AS zero_result_searches,
+			avg(result_count) AS avg_results,
+			avg(latency_ms) AS avg_latency_ms,
+			max(searched_at) AS last_searched_at
+		FROM search_queries
+		WHERE %s
+		GROUP BY normalized_query
+		ORDER BY searches DESC, last_searched_at DESC
+		LIMIT ?
+	`, where)
+
+	return c.queryStats(query, append(args, params.Limit)...)
+}
+
+// GetZeroResultQueries returns the most searched queries since params.Since
+// that returned nothing at least once.
+func (c *ClickhouseSearchStore) GetZeroResultQueries(params SearchStatsParams) ([]SearchQueryStats, error) {
+	where, args := params.where()
+
+	query := fmt.Sprintf(`
+		SELECT
+			normalized_query,
+			count() AS searches,
+			countIf(result_count = 0) AS zero_result_searches,
+			avg(result_count) AS avg_results,
+			avg(latency_ms) AS avg_latency_ms,
+			max(searched_at) AS last_searched_at
+		FROM search_queries
+		WHERE %s
+		GROUP BY normalized_query
+		HAVING zero_result_searches > 0
+		ORDER BY zero_result_searches DESC, last_searched_at DESC
+		LIMIT ?
+	`, where)
+
+	return c.queryStats(query, append(args, params.Limit)...)
+}
+
+func (c *ClickhouseSearchStore) queryStats(query string, args ...interface{}) ([]SearchQueryStats, error) {
+	rows, err := c.conn.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get search query stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []SearchQueryStats{}
+
+	for rows.Next() {
+		var s SearchQueryStats
+
+		err := rows.Scan(
+			&s.Query,
+			&s.Searches,
+			&s.ZeroResultSearches,
+			&s.AvgResults,
+			&s.AvgLatencyMs,
+			&s.LastSearchedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search query stats: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over search query stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// GetQueryTrends returns search volume per hour or day since params.Since,
+// for a single query when params.Query is set. Buckets without searches are
+// left out.
+func (c *ClickhouseSearchStore) GetQueryTrends(params SearchStatsParams) ([]SearchTrendPoint, error) {
+	where, args := params.where()
+
+	bucket := "toStartOfDay(searched_at)"
+	if params.Interval == TrendIntervalHour {
+		bucket = "toStartOfHour(searched_at)"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			%s AS bucket,
+			count() AS searches,
+			uniqExact(normalized_query) AS unique_queries,
+			countIf(result_count = 0) AS zero_result_searches,
+			avg(latency_ms) AS avg_latency_ms
+		FROM search_queries
+		WHERE %s
+		GROUP BY bucket
+		ORDER BY bucket
+	`, bucket, where)
+
+	rows, err := c.conn.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get search query trends: %w", err)
+	}
+	defer rows.Close()
+
+	points := []SearchTrendPoint{}
+
+	for rows.Next() {
+		var p SearchTrendPoint
+
+		err := rows.Scan(
+			&p.Bucket,
+			&p.Searches,
+			&p.UniqueQueries,
+			&p.ZeroResultSearches,
+			&p.AvgLatencyMs,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search query trend: %w", err)
+		}
+		points = append(points, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over search query trends: %w", err)
+	}
+
+	return points, nil
+}
@@ -23,8 +23,25 @@ type VideoTimelineSnapshot struct {
 	Link         string    `json:"link"`
 }
 
+type SnapshotTitle struct {
+	VideoID     string
+	Title       string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+}
+
+type SnapshotChange struct {
+	VideoID          string
+	ChangedAt        time.Time
+	TitleChanged     bool
+	ThumbnailChanged bool
+}
+
 type AnalyticsVideoStore interface {
 	GetVideoAnalyticsByID(videoID string) ([]VideoTimelineSnapshot, error)
+	GetSnapshotTitlesSince(since time.Time) ([]SnapshotTitle, error)
+	GetSnapshotChangesSince(since time.Time, lookback time.Duration) ([]SnapshotChange, error)
+	DeleteSnapshotsByVideoIDs(ctx context.Context, videoIDs []string) error
 }
 
 func (c *ClickhouseVideoStore) GetVideoAnalyticsByID(videoID string) ([]VideoTimelineSnapshot, error) {
@@ -62,3 +79,137 @@ func (c *ClickhouseVideoStore) GetVideoAnalyticsByID(videoID string) ([]VideoTim
 	return videos, nil
 
 }
+
+// GetSnapshotTitlesSince returns every distinct title per video seen in
+// snapshots taken after since, with the first and last time it was seen.
+func (c *ClickhouseVideoStore) GetSnapshotTitlesSince(since time.Time) ([]SnapshotTitle, error) {
+
+	query := `
+		SELECT video_id, title, min(snapshot_time), max(snapshot_time)
+		FROM video_snapshots
+		WHERE snapshot_time > ? AND title != ''
+		GROUP BY video_id, title
+	`
+
+	rows, err := c.conn.Query(context.Background(), query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot titles: %w", err)
+	}
+	defer rows.Close()
+
+	var titles []SnapshotTitle
+
+	for rows.Next() {
+		var title SnapshotTitle
+
+		err := rows.Scan(
+			&title.VideoID,
+			&title.Title,
+			&title.FirstSeenAt,
+			&title.LastSeenAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot title: %w", err)
+		}
+		titles = append(titles, title)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over snapshot titles: %w", err)
+	}
+
+	return titles, nil
+}
+
+// GetSnapshotChangesSince returns every snapshot taken after since whose title
+// or thumbnail differs from the snapshot before it. Snapshots up to lookback
+// before since are read so the first new snapshot has something to compare
+// against.
+func (c *ClickhouseVideoStore) GetSnapshotChangesSince(since time.Time, lookback time.Duration) ([]SnapshotChange, error) {
+
+	query := `
+		SELECT video_id, snapshot_time, title_changed, thumbnail_changed
+		FROM (
+			SELECT
+				video_id,
+				snapshot_time,
+				rn,
+				title_hash != prev_title_hash AS title_changed,
+				image_etag != '' AND prev_image_etag != '' AND image_etag != prev_image_etag AS thumbnail_changed
+			FROM (
+				SELECT
+					video_id,
+					snapshot_time,
+					title_hash,
+					image_etag,
+					row_number() OVER w AS rn,
+					lagInFrame(title_hash) OVER w AS prev_title_hash,
+					lagInFrame(image_etag) OVER w AS prev_image_etag
+				FROM video_snapshots
+				WHERE snapshot_time > ?
+				WINDOW w AS (PARTITION BY video_id ORDER BY snapshot_time ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)
+			)
+		)
+		WHERE rn > 1 AND snapshot_time > ? AND (title_changed OR thumbnail_changed)
+	`
+
+	from := since
+	if !since.IsZero() {
+		from = since.Add(-lookback)
+	}
+
+	rows, err := c.conn.Query(context.Background(), query, from, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []SnapshotChange
+
+	for rows.Next() {
+		var change SnapshotChange
+		var titleChanged, thumbnailChanged uint8
+
+		err := rows.Scan(
+			&change.VideoID,
+			&change.ChangedAt,
+			&titleChanged,
+			&thumbnailChanged,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot change: %w", err)
+		}
+
+		change.TitleChanged = titleChanged == 1
+		change.ThumbnailChanged = thumbnailChanged == 1
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over snapshot changes: %w", err)
+	}
+
+	return changes, nil
+}
+
+// DeleteSnapshotsByVideoIDs removes the snapshot history of the videos. The
+// snapshots hold no user ids, but the videos a user tracked are theirs, so
+// this is how a deleted account's analytics are anonymized. video_id is in
+// the sorting key and can't be rewritten, so the rows are deleted.
+func (c *ClickhouseVideoStore) DeleteSnapshotsByVideoIDs(ctx context.Context, videoIDs []string) error {
+	if len(videoIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		DELETE FROM video_snapshots
+		WHERE video_id IN ?
+	`
+
+	err := c.conn.Exec(ctx, query, videoIDs)
+	if err != nil {
+		return fmt.Errorf("failed to delete video snapshots: %w", err)
+	}
+
+	return nil
+}
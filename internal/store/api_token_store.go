@@ -0,0 +1,228 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+)
+
+const (
+	TokenScopeRead           = "read"
+	TokenScopeBookmarksWrite = "bookmarks:write"
+	TokenScopeRequestsWrite  = "requests:write"
+)
+
+var TokenScopes = []string{TokenScopeRead, TokenScopeBookmarksWrite, TokenScopeRequestsWrite}
+
+type APIToken struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"-"`
+	UserEmail   string     `json:"-"`
+	Name        string     `json:"name"`
+	TokenPrefix string     `json:"token_prefix"`
+	TokenHash   string     `json:"-"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateTokenScope(scope string) bool {
+	for _, s := range TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type PostgresAPITokenStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAPITokenStore(db *sql.DB) *PostgresAPITokenStore {
+	return &PostgresAPITokenStore{db: db}
+}
+
+type APITokenStore interface {
+	CreateAPIToken(token *APIToken) error
+	GetAPITokensByUserID(userID uuid.UUID) ([]APIToken, error)
+	GetAPITokenByHash(tokenHash string) (*APIToken, error)
+	TouchAPIToken(id uuid.UUID) error
+	DeleteAPIToken(id uuid.UUID, userID uuid.UUID) (bool, error)
+	DeleteAPITokensByUserID(userID uuid.UUID) (int64, error)
+}
+
+func (pg *PostgresAPITokenStore) CreateAPIToken(token *APIToken) error {
+	scopes := pgtype.TextArray{}
+	if err := scopes.Set(token.Scopes); err != nil {
+		return fmt.Errorf("failed to set token scopes: %w", err)
+	}
+
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := pg.db.QueryRow(query, token.UserID, token.Name, token.TokenPrefix, token.TokenHash, &scopes, token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create api token: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *PostgresAPITokenStore) GetAPITokensByUserID(userID uuid.UUID) ([]APIToken, error) {
+	query := `
+		SELECT id, user_id, name, token_prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+
+	for rows.Next() {
+		var token APIToken
+		var scopes pgtype.TextArray
+
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&token.TokenPrefix,
+			&scopes,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+			&token.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+
+		if err := scopes.AssignTo(&token.Scopes); err != nil {
+			return nil, fmt.Errorf("failed to read api token scopes: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over api tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenByHash returns the unexpired token with the hash, with its
+// owner's email, or nil when there is none.
+func (pg *PostgresAPITokenStore) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	var scopes pgtype.TextArray
+
+	query := `
+		SELECT t.id, t.user_id, u.email, t.name, t.token_prefix, t.scopes, t.expires_at, t.last_used_at, t.created_at
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > NOW())
+	`
+
+	err := pg.db.QueryRow(query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.UserEmail,
+		&token.Name,
+		&token.TokenPrefix,
+		&scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api token: %w", err)
+	}
+
+	if err := scopes.AssignTo(&token.Scopes); err != nil {
+		return nil, fmt.Errorf("failed to read api token scopes: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (pg *PostgresAPITokenStore) TouchAPIToken(id uuid.UUID) error {
+	query := `
+		UPDATE api_tokens
+		SET last_used_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := pg.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to touch api token: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAPIToken revokes one of the user's tokens. It reports false when the
+// user has no token with that id.
+func (pg *PostgresAPITokenStore) DeleteAPIToken(id uuid.UUID, userID uuid.UUID) (bool, error) {
+	query := `
+		DELETE FROM api_tokens
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := pg.db.Exec(query, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete api token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// DeleteAPITokensByUserID revokes all of the user's tokens.
+func (pg *PostgresAPITokenStore) DeleteAPITokensByUserID(userID uuid.UUID) (int64, error) {
+	query := `
+		DELETE FROM api_tokens
+		WHERE user_id = $1
+	`
+
+	result, err := pg.db.Exec(query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete api tokens: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/models"
 )
 
 type PostgresBookmarkStore struct {
@@ -18,6 +19,7 @@ func NewPostgresBookmarkStore(db *sql.DB) *PostgresBookmarkStore {
 type BookmarkStore interface {
 	CreateBookmark(videoID uuid.UUID, userID uuid.UUID) error
 	DeleteBookmark(videoID uuid.UUID, userID uuid.UUID) error
+	GetBookmarksByUserID(userID uuid.UUID) ([]models.Bookmark, error)
 }
 
 func (p *PostgresBookmarkStore) CreateBookmark(videoID uuid.UUID, userID uuid.UUID) error {
@@ -43,3 +45,36 @@ func (p *PostgresBookmarkStore) DeleteBookmark(videoID uuid.UUID, userID uuid.UU
 	}
 	return nil
 }
+
+// GetBookmarksByUserID returns all of the user's bookmarks, including those
+// of videos no longer tracked, newest first.
+func (p *PostgresBookmarkStore) GetBookmarksByUserID(userID uuid.UUID) ([]models.Bookmark, error) {
+	query := `
+		SELECT id, user_id, video_id, created_at, updated_at
+		FROM bookmarks
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks := []models.Bookmark{}
+	for rows.Next() {
+		var bookmark models.Bookmark
+		err := rows.Scan(&bookmark.Id, &bookmark.UserID, &bookmark.VideoID, &bookmark.Created_At, &bookmark.Updated_At)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan bookmark: %w", err)
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over bookmark rows: %w", err)
+	}
+
+	return bookmarks, nil
+}
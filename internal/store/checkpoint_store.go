@@ -0,0 +1,61 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type PostgresCheckpointStore struct {
+	db *sql.DB
+}
+
+func NewPostgresCheckpointStore(db *sql.DB) *PostgresCheckpointStore {
+	return &PostgresCheckpointStore{db: db}
+}
+
+type CheckpointStore interface {
+	GetCheckpoint(name string) (time.Time, error)
+	SetCheckpoint(name string, syncedUntil time.Time) error
+}
+
+// GetCheckpoint returns how far the named sync has read, or the zero time
+// when it has never run.
+func (pg *PostgresCheckpointStore) GetCheckpoint(name string) (time.Time, error) {
+	var syncedUntil time.Time
+
+	query := `
+		SELECT synced_until
+		FROM sync_checkpoints
+		WHERE name = $1
+	`
+
+	err := pg.db.QueryRow(query, name).Scan(&syncedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get checkpoint %s: %w", name, err)
+	}
+
+	return syncedUntil, nil
+}
+
+// SetCheckpoint moves the named checkpoint forward. It never moves it back,
+// so replicas running the same sync can't undo each other's progress.
+func (pg *PostgresCheckpointStore) SetCheckpoint(name string, syncedUntil time.Time) error {
+	query := `
+		INSERT INTO sync_checkpoints (name, synced_until)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE
+		SET synced_until = GREATEST(sync_checkpoints.synced_until, EXCLUDED.synced_until),
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := pg.db.Exec(query, name, syncedUntil)
+	if err != nil {
+		return fmt.Errorf("failed to set checkpoint %s: %w", name, err)
+	}
+
+	return nil
+}
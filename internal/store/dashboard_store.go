@@ -33,7 +33,7 @@ func (pg *PostgresDashboardStore) GetDashboardMetricsByUserID(userID uuid.UUID)
 		SELECT
 			(SELECT COUNT(*) FROM bookmarks WHERE user_id = $1) as bookmarked_videos,
 			(SELECT videos_tracked FROM users WHERE id = $1) as total_tracked_videos,
-			(SELECT COUNT(*) FROM video_requests WHERE user_id = $1 AND status = 'PENDING') as pending_requests;
+			(SELECT COUNT(*) FROM video_requests WHERE user_id = $1 AND status IN ('PENDING', 'UNDER_REVIEW')) as pending_requests;
 	`
 
 	err := pg.db.QueryRow(query, userID).Scan(&dashboard.Bookmarked, &dashboard.Tracked, &dashboard.Pending)
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MatchSpan marks a highlighted part of a suggestion. Start and End are
+// offsets in unicode code points, End exclusive.
+type MatchSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// highlightSpans finds where query occurs in text, ignoring case. When the
+// whole query doesn't occur, as with trigram matches, each of its words is
+// looked for instead. Overlapping spans are merged.
+func highlightSpans(text string, query string) []MatchSpan {
+	haystack := lowerRunes(text)
+
+	spans := findAll(haystack, lowerRunes(strings.TrimSpace(query)))
+	if len(spans) == 0 {
+		for _, word := range strings.Fields(query) {
+			if len([]rune(word)) < 2 {
+				continue
+			}
+			spans = append(spans, findAll(haystack, lowerRunes(word))...)
+		}
+	}
+
+	return mergeSpans(spans)
+}
+
+// lowerRunes lowercases rune by rune, so offsets into the result line up with
+// offsets into the original string.
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func findAll(haystack []rune, needle []rune) []MatchSpan {
+	spans := []MatchSpan{}
+	if len(needle) == 0 {
+		return spans
+	}
+
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if string(haystack[i:i+len(needle)]) == string(needle) {
+			spans = append(spans, MatchSpan{Start: i, End: i + len(needle)})
+			i += len(needle) - 1
+		}
+	}
+
+	return spans
+}
+
+func mergeSpans(spans []MatchSpan) []MatchSpan {
+	if len(spans) == 0 {
+		return []MatchSpan{}
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Start < spans[j].Start
+	})
+
+	merged := []MatchSpan{spans[0]}
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span.Start <= last.End {
+			if span.End > last.End {
+				last.End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	return merged
+}
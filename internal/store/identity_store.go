@@ -0,0 +1,224 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/models"
+)
+
+// ErrIdentityTaken is returned when the provider account is already linked,
+// or the user already has an account of that provider linked.
+var ErrIdentityTaken = errors.New("identity already linked")
+
+// UserIdentity is an account at an identity provider that the user logs in
+// with. Subject is the user's id at the provider.
+type UserIdentity struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"-"`
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"-"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+type PostgresIdentityStore struct {
+	db *sql.DB
+}
+
+func NewPostgresIdentityStore(db *sql.DB) *PostgresIdentityStore {
+	return &PostgresIdentityStore{db: db}
+}
+
+type IdentityStore interface {
+	GetIdentity(provider string, subject string) (*UserIdentity, error)
+	GetIdentitiesByUserID(userID uuid.UUID) ([]UserIdentity, error)
+	CreateIdentity(identity *UserIdentity) error
+	CreateUserWithIdentity(user *models.User, identity *UserIdentity) error
+	TouchIdentity(id uuid.UUID, email string) error
+	DeleteIdentity(userID uuid.UUID, provider string) (bool, error)
+}
+
+// GetIdentity returns the identity of the provider account, or nil when it
+// isn't linked to anyone.
+func (pg *PostgresIdentityStore) GetIdentity(provider string, subject string) (*UserIdentity, error) {
+	var identity UserIdentity
+	var email sql.NullString
+
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	err := pg.db.QueryRow(query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	identity.Email = email.String
+
+	return &identity, nil
+}
+
+func (pg *PostgresIdentityStore) GetIdentitiesByUserID(userID uuid.UUID) ([]UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []UserIdentity{}
+
+	for rows.Next() {
+		var identity UserIdentity
+		var email sql.NullString
+
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+
+		identity.Email = email.String
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+const insertIdentityQuery = `
+	INSERT INTO user_identities (user_id, provider, subject, email)
+	VALUES ($1, $2, $3, NULLIF($4, ''))
+	ON CONFLICT DO NOTHING
+	RETURNING id, created_at, last_login_at
+`
+
+// CreateIdentity links identity to identity.UserID. It returns
+// ErrIdentityTaken when either side is already linked.
+func (pg *PostgresIdentityStore) CreateIdentity(identity *UserIdentity) error {
+	err := pg.db.QueryRow(insertIdentityQuery, identity.UserID, identity.Provider, identity.Subject, identity.Email).
+		Scan(&identity.ID, &identity.CreatedAt, &identity.LastLoginAt)
+	if err == sql.ErrNoRows {
+		return ErrIdentityTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	return nil
+}
+
+// CreateUserWithIdentity signs up a new user with their first identity.
+func (pg *PostgresIdentityStore) CreateUserWithIdentity(user *models.User, identity *UserIdentity) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	query := `
+		INSERT INTO users (name, email, image, role)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+
+	err = tx.QueryRow(query, user.Name, user.Email, user.ImageSrc, user.Role).Scan(&user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	identity.UserID = user.ID
+	err = tx.QueryRow(insertIdentityQuery, identity.UserID, identity.Provider, identity.Subject, identity.Email).
+		Scan(&identity.ID, &identity.CreatedAt, &identity.LastLoginAt)
+	if err == sql.ErrNoRows {
+		return ErrIdentityTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// TouchIdentity records a login with the identity and the email the provider
+// reported for it.
+func (pg *PostgresIdentityStore) TouchIdentity(id uuid.UUID, email string) error {
+	query := `
+		UPDATE user_identities
+		SET last_login_at = NOW(), email = COALESCE(NULLIF($2, ''), email)
+		WHERE id = $1
+	`
+
+	_, err := pg.db.Exec(query, id, email)
+	if err != nil {
+		return fmt.Errorf("failed to touch identity: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteIdentity unlinks the user's account of provider. It reports false
+// when there is none, or when it's the only one left, since the user
+// couldn't log in anymore.
+func (pg *PostgresIdentityStore) DeleteIdentity(userID uuid.UUID, provider string) (bool, error) {
+	query := `
+		DELETE FROM user_identities
+		WHERE user_id = $1 AND provider = $2
+		AND (SELECT COUNT(*) FROM user_identities WHERE user_id = $1) > 1
+	`
+
+	result, err := pg.db.Exec(query, userID, provider)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete identity: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/httprate"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitStoreTimeout bounds each counter call, they are on the path of
+// every request.
+const rateLimitStoreTimeout = 250 * time.Millisecond
+
+// The rate limit stores are httprate.LimitCounter backends shared by all
+// replicas. Counters are per window, and kept for two windows since the
+// limiter reads the previous one too. A store counts for one limiter, Config
+// gives it the window length.
+
+var (
+	_ httprate.LimitCounter = (*RedisRateLimitStore)(nil)
+	_ RateLimitStore        = (*PostgresRateLimitStore)(nil)
+)
+
+// RateLimitStore is a counter backend whose old counters have to be deleted,
+// redis expires its own.
+type RateLimitStore interface {
+	httprate.LimitCounter
+	DeleteExpiredCounters() (int64, error)
+}
+
+type RedisRateLimitStore struct {
+	client *redis.Client
+	window time.Duration
+}
+
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client, window: time.Minute}
+}
+
+func (s *RedisRateLimitStore) Config(requestLimit int, windowLength time.Duration) {
+	s.window = windowLength
+}
+
+func redisRateLimitKey(key string, window time.Time) string {
+	return "ratelimit:" + key + ":" + strconv.FormatInt(window.Unix(), 10)
+}
+
+func (s *RedisRateLimitStore) Increment(key string, currentWindow time.Time) error {
+	return s.IncrementBy(key, currentWindow, 1)
+}
+
+func (s *RedisRateLimitStore) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	redisKey := redisRateLimitKey(key, currentWindow)
+
+	pipe := s.client.TxPipeline()
+	pipe.IncrBy(ctx, redisKey, int64(amount))
+	pipe.Expire(ctx, redisKey, 2*s.window)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to increment rate limit counter: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisRateLimitStore) Get(key string, currentWindow time.Time, previousWindow time.Time) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	values, err := s.client.MGet(ctx, redisRateLimitKey(key, currentWindow), redisRateLimitKey(key, previousWindow)).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get rate limit counters: %w", err)
+	}
+
+	counts := [2]int{}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			// missing key
+			continue
+		}
+		counts[i], err = strconv.Atoi(str)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse rate limit counter: %w", err)
+		}
+	}
+
+	return counts[0], counts[1], nil
+}
+
+type PostgresRateLimitStore struct {
+	db     *sql.DB
+	window time.Duration
+}
+
+func NewPostgresRateLimitStore(db *sql.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db, window: time.Minute}
+}
+
+func (s *PostgresRateLimitStore) Config(requestLimit int, windowLength time.Duration) {
+	s.window = windowLength
+}
+
+func (s *PostgresRateLimitStore) Increment(key string, currentWindow time.Time) error {
+	return s.IncrementBy(key, currentWindow, 1)
+}
+
+func (s *PostgresRateLimitStore) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO rate_limit_counters (key, window_start, count, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key, window_start)
+		DO UPDATE SET count = rate_limit_counters.count + EXCLUDED.count
+	`
+
+	_, err := s.db.ExecContext(ctx, query, key, currentWindow, amount, currentWindow.Add(2*s.window))
+	if err != nil {
+		return fmt.Errorf("failed to increment rate limit counter: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresRateLimitStore) Get(key string, currentWindow time.Time, previousWindow time.Time) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitStoreTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			COALESCE(SUM(count) FILTER (WHERE window_start = $2), 0),
+			COALESCE(SUM(count) FILTER (WHERE window_start = $3), 0)
+		FROM rate_limit_counters
+		WHERE key = $1 AND window_start IN ($2, $3)
+	`
+
+	var current, previous int
+	err := s.db.QueryRowContext(ctx, query, key, currentWindow, previousWindow).Scan(&current, &previous)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get rate limit counters: %w", err)
+	}
+
+	return current, previous, nil
+}
+
+// DeleteExpiredCounters deletes the counters of past windows, of every
+// limiter.
+func (s *PostgresRateLimitStore) DeleteExpiredCounters() (int64, error) {
+	query := `
+		DELETE FROM rate_limit_counters
+		WHERE expires_at < NOW()
+	`
+
+	result, err := s.db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired rate limit counters: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return deleted, nil
+}
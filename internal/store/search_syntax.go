@@ -0,0 +1,282 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchQuery is the parsed form of the q parameter of the video listing.
+//
+//	words              match like a plain search
+//	"exact phrase"     the title must contain the phrase
+//	-word, -"phrase"   the video and channel title must not contain it
+//	channel:name       the channel id is name, or its title contains it
+//	before:2025-01-01  published before the date, RFC 3339 also works
+//	after:2025-01-01   published on or after the date
+//	changed:>3         number of changes, with >, >=, <, <= or = (the default)
+//	is:bookmarked      only videos the logged in user bookmarked
+//
+// Operator values can be quoted too, as in channel:"linus tech tips". Words
+// that look like an operator but have an unknown key, like re:zero, are plain
+// words.
+type SearchQuery struct {
+	Terms      []string
+	Phrases    []string
+	Excludes   []string
+	Channels   []string
+	Before     *time.Time
+	After      *time.Time
+	Changes    []ChangeCondition
+	Bookmarked bool
+}
+
+// ChangeCondition compares a video's change count, as in changed:>3.
+type ChangeCondition struct {
+	Op    string
+	Count int
+}
+
+// SearchSyntaxError reports where parsing failed. Position is an offset in
+// unicode code points into the query.
+type SearchSyntaxError struct {
+	Position int
+	Message  string
+}
+
+func (e *SearchSyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+// Text is what's left to rank results by once operators are taken out.
+func (sq SearchQuery) Text() string {
+	parts := make([]string, 0, len(sq.Terms)+len(sq.Phrases))
+	parts = append(parts, sq.Terms...)
+	parts = append(parts, sq.Phrases...)
+	return strings.Join(parts, " ")
+}
+
+type searchParser struct {
+	input []rune
+	pos   int
+	query SearchQuery
+}
+
+// ParseSearchQuery parses q into a SearchQuery. Errors are always a
+// *SearchSyntaxError.
+func ParseSearchQuery(q string) (SearchQuery, error) {
+	p := &searchParser{input: []rune(q)}
+
+	for {
+		p.skipSpaces()
+		if p.done() {
+			return p.query, nil
+		}
+
+		if err := p.parseTerm(); err != nil {
+			return SearchQuery{}, err
+		}
+	}
+}
+
+func (p *searchParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *searchParser) peek() rune {
+	return p.input[p.pos]
+}
+
+func (p *searchParser) skipSpaces() {
+	for !p.done() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+func (p *searchParser) errorf(pos int, format string, args ...interface{}) *SearchSyntaxError {
+	return &SearchSyntaxError{Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *searchParser) parseTerm() error {
+	start := p.pos
+
+	negated := false
+	if p.peek() == '-' {
+		negated = true
+		p.pos++
+		if p.done() || unicode.IsSpace(p.peek()) {
+			return p.errorf(start, "expected a word or phrase after '-'")
+		}
+	}
+
+	if p.peek() == '"' {
+		phrase, err := p.parseQuoted()
+		if err != nil {
+			return err
+		}
+		if negated {
+			p.query.Excludes = append(p.query.Excludes, phrase)
+		} else {
+			p.query.Phrases = append(p.query.Phrases, phrase)
+		}
+		return nil
+	}
+
+	keyStart := p.pos
+	for !p.done() && !unicode.IsSpace(p.peek()) && p.peek() != ':' && p.peek() != '"' {
+		p.pos++
+	}
+	key := strings.ToLower(string(p.input[keyStart:p.pos]))
+
+	if !p.done() && p.peek() == ':' && isSearchOperator(key) {
+		if negated {
+			return p.errorf(start, "%s: can't be negated", key)
+		}
+		p.pos++
+		return p.parseOperator(key)
+	}
+
+	word, err := p.parseWord(keyStart)
+	if err != nil {
+		return err
+	}
+	if negated {
+		p.query.Excludes = append(p.query.Excludes, word)
+	} else {
+		p.query.Terms = append(p.query.Terms, word)
+	}
+	return nil
+}
+
+// parseWord reads the rest of a bare word that started at start.
+func (p *searchParser) parseWord(start int) (string, error) {
+	for !p.done() && !unicode.IsSpace(p.peek()) {
+		if p.peek() == '"' {
+			return "", p.errorf(p.pos, "unexpected '\"' inside a word")
+		}
+		p.pos++
+	}
+	return normalizeSearchText(string(p.input[start:p.pos])), nil
+}
+
+func (p *searchParser) parseQuoted() (string, error) {
+	start := p.pos
+	p.pos++
+
+	for !p.done() && p.peek() != '"' {
+		p.pos++
+	}
+	if p.done() {
+		return "", p.errorf(start, "unterminated quote")
+	}
+
+	value := normalizeSearchText(string(p.input[start+1 : p.pos]))
+	p.pos++
+
+	if value == "" {
+		return "", p.errorf(start, "empty phrase")
+	}
+	if !p.done() && !unicode.IsSpace(p.peek()) {
+		return "", p.errorf(p.pos, "expected a space after the closing quote")
+	}
+	return value, nil
+}
+
+func isSearchOperator(key string) bool {
+	switch key {
+	case "channel", "before", "after", "changed", "is":
+		return true
+	}
+	return false
+}
+
+func (p *searchParser) parseOperator(key string) error {
+	valueStart := p.pos
+
+	var value string
+	var err error
+	switch {
+	case p.done() || unicode.IsSpace(p.peek()):
+		return p.errorf(valueStart, "missing value for %s:", key)
+	case p.peek() == '"':
+		value, err = p.parseQuoted()
+	default:
+		value, err = p.parseWord(valueStart)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch key {
+	case "channel":
+		p.query.Channels = append(p.query.Channels, value)
+
+	case "before", "after":
+		t, ok := parseSearchDate(value)
+		if !ok {
+			return p.errorf(valueStart, "invalid date %q for %s:, expected YYYY-MM-DD", value, key)
+		}
+		if key == "before" {
+			p.query.Before = &t
+		} else {
+			p.query.After = &t
+		}
+
+	case "changed":
+		condition, ok := parseChangeCondition(value)
+		if !ok {
+			return p.errorf(valueStart, "invalid value %q for changed:, expected a count like >3", value)
+		}
+		p.query.Changes = append(p.query.Changes, condition)
+
+	case "is":
+		if value != "bookmarked" {
+			return p.errorf(valueStart, "unknown value %q for is:, expected bookmarked", value)
+		}
+		p.query.Bookmarked = true
+	}
+
+	return nil
+}
+
+func parseSearchDate(value string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true
+	}
+	// values are lowercased, RFC 3339 wants the T and Z upper case
+	if t, err := time.Parse(time.RFC3339, strings.ToUpper(value)); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func parseChangeCondition(value string) (ChangeCondition, bool) {
+	op := "="
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = strings.TrimPrefix(value, candidate)
+			break
+		}
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return ChangeCondition{}, false
+	}
+	return ChangeCondition{Op: op, Count: count}, true
+}
+
+// normalizeSearchText matches how the normalized title columns are built.
+func normalizeSearchText(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// containsPattern matches s anywhere in a string, with LIKE wildcards in s
+// escaped.
+func containsPattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func searchDate(t *testing.T, value string) *time.Time {
+	t.Helper()
+
+	d, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("bad test date %q: %v", value, err)
+	}
+	return &d
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want SearchQuery
+	}{
+		{
+			name: "empty",
+			q:    "   ",
+			want: SearchQuery{},
+		},
+		{
+			name: "words",
+			q:    "Linus  Tech",
+			want: SearchQuery{Terms: []string{"linus", "tech"}},
+		},
+		{
+			name: "phrase",
+			q:    `"Exact   Phrase"`,
+			want: SearchQuery{Phrases: []string{"exact phrase"}},
+		},
+		{
+			name: "negated word and phrase",
+			q:    `-sponsor -"Paid Promo"`,
+			want: SearchQuery{Excludes: []string{"sponsor", "paid promo"}},
+		},
+		{
+			name: "unknown operator is a word",
+			q:    "re:zero",
+			want: SearchQuery{Terms: []string{"re:zero"}},
+		},
+		{
+			name: "negated unknown operator is excluded",
+			q:    "-re:zero",
+			want: SearchQuery{Excludes: []string{"re:zero"}},
+		},
+		{
+			name: "hyphen inside a word",
+			q:    "spider-man",
+			want: SearchQuery{Terms: []string{"spider-man"}},
+		},
+		{
+			name: "channel",
+			q:    `channel:UCXuqSBlHAE6Xw-yeJA0Tunw channel:"Linus Tech Tips"`,
+			want: SearchQuery{Channels: []string{"ucxuqsblhae6xw-yeja0tunw", "linus tech tips"}},
+		},
+		{
+			name: "operator keys are case insensitive",
+			q:    "IS:Bookmarked",
+			want: SearchQuery{Bookmarked: true},
+		},
+		{
+			name: "dates",
+			q:    "after:2024-06-01 before:2025-01-01T10:30:00Z",
+			want: SearchQuery{
+				After:  searchDate(t, "2024-06-01T00:00:00Z"),
+				Before: searchDate(t, "2025-01-01T10:30:00Z"),
+			},
+		},
+		{
+			name: "change counts",
+			q:    `changed:>=3 changed:<10 changed:5 changed:"2"`,
+			want: SearchQuery{Changes: []ChangeCondition{
+				{Op: ">=", Count: 3},
+				{Op: "<", Count: 10},
+				{Op: "=", Count: 5},
+				{Op: "=", Count: 2},
+			}},
+		},
+		{
+			name: "mixed",
+			q:    `review "iphone 15" -unboxing channel:mkbhd is:bookmarked`,
+			want: SearchQuery{
+				Terms:      []string{"review"},
+				Phrases:    []string{"iphone 15"},
+				Excludes:   []string{"unboxing"},
+				Channels:   []string{"mkbhd"},
+				Bookmarked: true,
+			},
+		},
+		{
+			name: "unicode",
+			q:    `Café "Naïve Art"`,
+			want: SearchQuery{Terms: []string{"café"}, Phrases: []string{"naïve art"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSearchQuery(tt.q)
+			if err != nil {
+				t.Fatalf("ParseSearchQuery(%q) error: %v", tt.q, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSearchQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		q        string
+		position int
+		message  string
+	}{
+		{name: "lone minus", q: "-", position: 0, message: "expected a word or phrase after '-'"},
+		{name: "minus before a space", q: "foo - bar", position: 4, message: "expected a word or phrase after '-'"},
+		{name: "unterminated quote", q: `"unterminated`, position: 0, message: "unterminated quote"},
+		{name: "unterminated quote after a word", q: `foo "bar`, position: 4, message: "unterminated quote"},
+		{name: "unterminated negated quote", q: `-"bar`, position: 1, message: "unterminated quote"},
+		{name: "empty phrase", q: `""`, position: 0, message: "empty phrase"},
+		{name: "blank phrase", q: `"   "`, position: 0, message: "empty phrase"},
+		{name: "text after closing quote", q: `"a"b`, position: 3, message: "expected a space after the closing quote"},
+		{name: "quote inside a word", q: `fo"o`, position: 2, message: `unexpected '"' inside a word`},
+		{name: "negated operator", q: "-channel:mkbhd", position: 0, message: "channel: can't be negated"},
+		{name: "missing value", q: "channel:", position: 8, message: "missing value for channel:"},
+		{name: "space after operator", q: "channel: mkbhd", position: 8, message: "missing value for channel:"},
+		{name: "invalid date", q: "before:yesterday", position: 7, message: "invalid date"},
+		{name: "invalid change count", q: "changed:>=x", position: 8, message: "invalid value"},
+		{name: "negative change count", q: "changed:-1", position: 8, message: "invalid value"},
+		{name: "doubled comparison", q: "changed:>>3", position: 8, message: "invalid value"},
+		{name: "unknown is value", q: "is:watched", position: 3, message: "unknown value"},
+		{name: "positions count code points", q: `héllo wörld "x`, position: 12, message: "unterminated quote"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSearchQuery(tt.q)
+			if err == nil {
+				t.Fatalf("ParseSearchQuery(%q) succeeded, want an error", tt.q)
+			}
+
+			var syntaxErr *SearchSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ParseSearchQuery(%q) error %T, want *SearchSyntaxError", tt.q, err)
+			}
+			if syntaxErr.Position != tt.position {
+				t.Errorf("ParseSearchQuery(%q) error at %d, want %d", tt.q, syntaxErr.Position, tt.position)
+			}
+			if !strings.Contains(syntaxErr.Message, tt.message) {
+				t.Errorf("ParseSearchQuery(%q) error %q, want it to contain %q", tt.q, syntaxErr.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestSearchQueryText(t *testing.T) {
+	sq, err := ParseSearchQuery(`linus "tech tips" -drama channel:ltt`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery error: %v", err)
+	}
+
+	if got, want := sq.Text(), "linus tech tips"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
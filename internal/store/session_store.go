@@ -0,0 +1,255 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Session struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"-"`
+	UserID     *uuid.UUID `json:"-"`
+	Data       string     `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	IsCurrent  bool       `json:"is_current"`
+}
+
+type PostgresSessionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresSessionStore(db *sql.DB) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db}
+}
+
+type SessionStore interface {
+	CreateSession(session *Session) error
+	GetSession(id string, name string) (*Session, error)
+	UpdateSession(session *Session) (bool, error)
+	TouchSession(id string, userAgent string, ipAddress string) error
+	DeleteSession(id string) error
+	GetSessionsByUserID(userID uuid.UUID, name string) ([]Session, error)
+	DeleteUserSession(userID uuid.UUID, id string) (bool, error)
+	DeleteUserSessions(userID uuid.UUID, exceptID string) (int64, error)
+	DeleteExpiredSessions() (int64, error)
+}
+
+func (pg *PostgresSessionStore) CreateSession(session *Session) error {
+	query := `
+		INSERT INTO sessions (id, name, user_id, data, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, last_seen_at
+	`
+
+	err := pg.db.QueryRow(query, session.ID, session.Name, session.UserID, session.Data,
+		session.UserAgent, session.IPAddress, session.ExpiresAt).Scan(&session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSession returns the unexpired session with id and cookie name, or nil
+// when there is none.
+func (pg *PostgresSessionStore) GetSession(id string, name string) (*Session, error) {
+	var session Session
+	var userAgent, ipAddress sql.NullString
+
+	query := `
+		SELECT id, name, user_id, data, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE id = $1 AND name = $2 AND expires_at > NOW()
+	`
+
+	err := pg.db.QueryRow(query, id, name).Scan(
+		&session.ID,
+		&session.Name,
+		&session.UserID,
+		&session.Data,
+		&userAgent,
+		&ipAddress,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	session.UserAgent = userAgent.String
+	session.IPAddress = ipAddress.String
+
+	return &session, nil
+}
+
+// UpdateSession saves the session's data, user and expiry. It reports false
+// when the session no longer exists, as when it was revoked.
+func (pg *PostgresSessionStore) UpdateSession(session *Session) (bool, error) {
+	query := `
+		UPDATE sessions
+		SET data = $2, user_id = $3, expires_at = $4, last_seen_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(query, session.ID, session.Data, session.UserID, session.ExpiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// TouchSession records that the session was just used, and from where.
+func (pg *PostgresSessionStore) TouchSession(id string, userAgent string, ipAddress string) error {
+	query := `
+		UPDATE sessions
+		SET last_seen_at = NOW(), user_agent = $2, ip_address = $3
+		WHERE id = $1
+	`
+
+	_, err := pg.db.Exec(query, id, userAgent, ipAddress)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *PostgresSessionStore) DeleteSession(id string) error {
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1
+	`
+
+	_, err := pg.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSessionsByUserID returns the user's unexpired sessions of the given
+// cookie name, most recently used first.
+func (pg *PostgresSessionStore) GetSessionsByUserID(userID uuid.UUID, name string) ([]Session, error) {
+	query := `
+		SELECT id, name, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND name = $2 AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := pg.db.Query(query, userID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+
+	for rows.Next() {
+		var session Session
+		var userAgent, ipAddress sql.NullString
+
+		err := rows.Scan(
+			&session.ID,
+			&session.Name,
+			&session.UserID,
+			&userAgent,
+			&ipAddress,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+
+		session.UserAgent = userAgent.String
+		session.IPAddress = ipAddress.String
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSession revokes one of the user's sessions. It reports false when
+// the user has no session with that id.
+func (pg *PostgresSessionStore) DeleteUserSession(userID uuid.UUID, id string) (bool, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := pg.db.Exec(query, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// DeleteUserSessions revokes every session of the user, user and admin
+// alike, except exceptID when it is set.
+func (pg *PostgresSessionStore) DeleteUserSessions(userID uuid.UUID, exceptID string) (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE user_id = $1 AND id != $2
+	`
+
+	result, err := pg.db.Exec(query, userID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+func (pg *PostgresSessionStore) DeleteExpiredSessions() (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE expires_at <= NOW()
+	`
+
+	result, err := pg.db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
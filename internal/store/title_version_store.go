@@ -0,0 +1,77 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TitleVersion struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	Title       string    `json:"title"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+type PostgresTitleVersionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTitleVersionStore(db *sql.DB) *PostgresTitleVersionStore {
+	return &PostgresTitleVersionStore{db: db}
+}
+
+type TitleVersionStore interface {
+	UpsertTitleVersions(versions []TitleVersion) error
+}
+
+// UpsertTitleVersions records every version in a single transaction. Versions
+// of videos that no longer exist in postgres are skipped.
+func (pg *PostgresTitleVersionStore) UpsertTitleVersions(versions []TitleVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	query := `
+		INSERT INTO video_title_versions (video_id, title, first_seen_at, last_seen_at)
+		SELECT $1::uuid, $2::text, $3::timestamptz, $4::timestamptz
+		WHERE EXISTS (SELECT 1 FROM videos WHERE id = $1)
+		ON CONFLICT (video_id, title) DO UPDATE
+		SET first_seen_at = LEAST(video_title_versions.first_seen_at, EXCLUDED.first_seen_at),
+			last_seen_at = GREATEST(video_title_versions.last_seen_at, EXCLUDED.last_seen_at),
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare title version upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, version := range versions {
+		_, err = stmt.Exec(version.VideoID, version.Title, version.FirstSeenAt, version.LastSeenAt)
+		if err != nil {
+			return fmt.Errorf("failed to upsert title version: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Arbitrary key for the advisory lock that keeps replicas from refreshing
+// trending scores at the same time
+const trendingRefreshLockKey = 31_000_001
+
+// TrendingWeights decide how much one event of each kind adds to a video's
+// trending score before decay.
+type TrendingWeights struct {
+	Visit    float64
+	Bookmark float64
+	Change   float64
+}
+
+type PostgresTrendingStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTrendingStore(db *sql.DB) *PostgresTrendingStore {
+	return &PostgresTrendingStore{db: db}
+}
+
+type TrendingStore interface {
+	RefreshTrendingScores(weights TrendingWeights, halfLife time.Duration, window time.Duration) (bool, error)
+}
+
+// RefreshTrendingScores recomputes every video's trending score from the
+// visits, bookmarks and changes of the last window. Each event's weight halves
+// every halfLife. It returns false without doing anything when another
+// replica is already refreshing.
+func (pg *PostgresTrendingStore) RefreshTrendingScores(weights TrendingWeights, halfLife time.Duration, window time.Duration) (bool, error) {
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	var locked bool
+	err = tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1)`, trendingRefreshLockKey).Scan(&locked)
+	if err != nil {
+		return false, fmt.Errorf("failed to take trending refresh lock: %w", err)
+	}
+
+	if !locked {
+		return false, nil
+	}
+
+	// $1 is the half life in hours and $2 the window start. Daily visits
+	// are aged from the middle of their day.
+	query := `
+		WITH events AS (
+			SELECT
+				video_id,
+				visits * $3::float8 * exp(-ln(2) * EXTRACT(EPOCH FROM (NOW() - (day + INTERVAL '12 hours')))::float8 / 3600 / $1::float8) AS score
+			FROM video_daily_visits
+			WHERE day >= ($2::timestamptz)::date
+
+			UNION ALL
+
+			SELECT
+				video_id,
+				$4::float8 * exp(-ln(2) * EXTRACT(EPOCH FROM (NOW() - created_at))::float8 / 3600 / $1::float8) AS score
+			FROM bookmarks
+			WHERE created_at >= $2::timestamptz
+
+			UNION ALL
+
+			SELECT
+				video_id,
+				$5::float8 * exp(-ln(2) * EXTRACT(EPOCH FROM (NOW() - changed_at))::float8 / 3600 / $1::float8) AS score
+			FROM video_changes
+			WHERE changed_at >= $2::timestamptz
+		),
+		scores AS (
+			SELECT v.id, COALESCE(SUM(e.score), 0) AS score
+			FROM videos v
+			LEFT JOIN events e ON e.video_id = v.id
+			GROUP BY v.id
+		)
+		UPDATE videos v
+		SET trending_score = s.score,
+			trending_updated_at = NOW()
+		FROM scores s
+		WHERE v.id = s.id
+	`
+
+	_, err = tx.Exec(
+		query,
+		halfLife.Hours(),
+		time.Now().Add(-window),
+		weights.Visit,
+		weights.Bookmark,
+		weights.Change,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to refresh trending scores: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
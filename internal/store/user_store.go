@@ -3,10 +3,17 @@ package store
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/grvbrk/nazrein_server/internal/models"
+	"github.com/jackc/pgtype"
 )
 
+// UserPermissionsColumn selects the permissions of the role of the user
+// aliased u.
+const UserPermissionsColumn = `ARRAY(SELECT rp.permission FROM role_permissions rp WHERE rp.role = u.role ORDER BY rp.permission)`
+
 type PostgresUserStore struct {
 	db *sql.DB
 }
@@ -18,17 +25,22 @@ func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 type UserStore interface {
 	CreateUser(*models.User) error
 	GetUserByUsername(username string) (*models.User, error)
-	GetUserByGoogleID(id string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByID(id uuid.UUID) (*models.User, error)
+	ScheduleUserDeletion(userID uuid.UUID, deleteAfter time.Time) error
+	CancelUserDeletion(userID uuid.UUID) (bool, error)
+	GetUsersDueForDeletion(limit int) ([]uuid.UUID, error)
+	DeleteUser(userID uuid.UUID) (bool, error)
 }
 
 func (pg *PostgresUserStore) CreateUser(user *models.User) error {
 
 	query := `
-	INSERT INTO users (google_id, name, email, image, role)
-	VALUES ($1, $2, $3, $4, $5)
+	INSERT INTO users (name, email, image, role)
+	VALUES ($1, $2, $3, $4)
 	RETURNING id;
 	`
-	err := pg.db.QueryRow(query, user.GoogleID, user.Name, user.Email, user.ImageSrc, user.Role).Scan(&user.ID)
+	err := pg.db.QueryRow(query, user.Name, user.Email, user.ImageSrc, user.Role).Scan(&user.ID)
 
 	if err != nil {
 		return fmt.Errorf("error running create user query: %w", err)
@@ -41,14 +53,13 @@ func (pg *PostgresUserStore) GetUserByUsername(username string) (*models.User, e
 	user := &models.User{}
 
 	query := `
-	SELECT id, google_id, name, email, image, role
+	SELECT id, name, email, image, role
 	FROM users
 	WHERE name = $1;
 	`
 
 	err := pg.db.QueryRow(query, username).Scan(
 		&user.ID,
-		&user.GoogleID,
 		&user.Name,
 		&user.Email,
 		&user.ImageSrc,
@@ -66,31 +77,203 @@ func (pg *PostgresUserStore) GetUserByUsername(username string) (*models.User, e
 	return user, nil
 }
 
-func (pg *PostgresUserStore) GetUserByGoogleID(id string) (*models.User, error) {
+// GetUserByID returns the user with the permissions of their role, or nil
+// when there is no such user.
+func (pg *PostgresUserStore) GetUserByID(id uuid.UUID) (*models.User, error) {
 	user := &models.User{}
+	var permissions pgtype.TextArray
 
 	query := `
-	SELECT id, google_id, name, email, image, role
-	FROM users
-	WHERE google_id = $1
+	SELECT u.id, u.name, u.email, u.image, u.role, u.videos_tracked, u.delete_after, u.created_at, u.updated_at, ` + UserPermissionsColumn + `
+	FROM users u
+	WHERE u.id = $1
 	`
 
 	err := pg.db.QueryRow(query, id).Scan(
 		&user.ID,
-		&user.GoogleID,
 		&user.Name,
 		&user.Email,
 		&user.ImageSrc,
 		&user.Role,
+		&user.Videos_Tracked,
+		&user.Delete_After,
+		&user.Created_At,
+		&user.Updated_At,
+		&permissions,
 	)
-
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error running get user by google id query: %w", err)
+		return nil, fmt.Errorf("failed to get user by id: %w", err)
 	}
 
+	if err := permissions.AssignTo(&user.Permissions); err != nil {
+		return nil, fmt.Errorf("failed to read user permissions: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail returns the user with the permissions of their role, or nil
+// when there is no such user.
+func (pg *PostgresUserStore) GetUserByEmail(email string) (*models.User, error) {
+	user := &models.User{}
+	var permissions pgtype.TextArray
+
+	query := `
+	SELECT u.id, u.name, u.email, u.image, u.role, ` + UserPermissionsColumn + `
+	FROM users u
+	WHERE LOWER(u.email) = LOWER($1)
+	`
+
+	err := pg.db.QueryRow(query, email).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.ImageSrc,
+		&user.Role,
+		&permissions,
+	)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("no user found with google id: %s", id)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by email: %w", err)
+	}
+
+	if err := permissions.AssignTo(&user.Permissions); err != nil {
+		return nil, fmt.Errorf("failed to read user permissions: %w", err)
 	}
 
 	return user, nil
 }
+
+// ScheduleUserDeletion marks the user to be deleted after deleteAfter, see
+// the account_deletion job. Scheduling again keeps the earlier date.
+func (pg *PostgresUserStore) ScheduleUserDeletion(userID uuid.UUID, deleteAfter time.Time) error {
+	query := `
+		UPDATE users
+		SET delete_after = COALESCE(delete_after, $2), updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := pg.db.Exec(query, userID, deleteAfter)
+	if err != nil {
+		return fmt.Errorf("failed to schedule user deletion: %w", err)
+	}
+
+	return nil
+}
+
+// CancelUserDeletion keeps the account. It reports false when no deletion
+// was scheduled, or when its grace period is over: from then on the
+// account_deletion job may already be deleting the account's analytics,
+// which can't be restored.
+func (pg *PostgresUserStore) CancelUserDeletion(userID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE users
+		SET delete_after = NULL, updated_at = NOW()
+		WHERE id = $1 AND delete_after > NOW()
+	`
+
+	result, err := pg.db.Exec(query, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel user deletion: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetUsersDueForDeletion returns up to limit users whose grace period is
+// over, longest overdue first.
+func (pg *PostgresUserStore) GetUsersDueForDeletion(limit int) ([]uuid.UUID, error) {
+	query := `
+		SELECT id
+		FROM users
+		WHERE delete_after <= NOW()
+		ORDER BY delete_after
+		LIMIT $1
+	`
+
+	rows, err := pg.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users due for deletion: %w", err)
+	}
+	defer rows.Close()
+
+	userIDs := []uuid.UUID{}
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over user rows: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+// DeleteUser deletes a user whose grace period is over, and with them
+// everything referencing them, including the videos only they watch. Videos
+// others watch stay tracked. It reports false when the user is gone or the
+// deletion was cancelled meanwhile.
+func (pg *PostgresUserStore) DeleteUser(userID uuid.UUID) (bool, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1 AND delete_after <= NOW()
+	`
+
+	result, err := tx.Exec(query, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	// the user's watches went with them, so their videos are the ones they
+	// first asked for that nobody watches anymore
+	query = `
+		DELETE FROM videos v
+		WHERE v.user_id IS NULL
+			AND NOT EXISTS (SELECT 1 FROM video_watchers w WHERE w.video_id = v.id)
+	`
+
+	_, err = tx.Exec(query)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete unwatched videos: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
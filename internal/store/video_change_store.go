@@ -0,0 +1,74 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoChange struct {
+	VideoID          uuid.UUID `json:"video_id"`
+	ChangedAt        time.Time `json:"changed_at"`
+	TitleChanged     bool      `json:"title_changed"`
+	ThumbnailChanged bool      `json:"thumbnail_changed"`
+}
+
+type PostgresVideoChangeStore struct {
+	db *sql.DB
+}
+
+func NewPostgresVideoChangeStore(db *sql.DB) *PostgresVideoChangeStore {
+	return &PostgresVideoChangeStore{db: db}
+}
+
+type VideoChangeStore interface {
+	InsertVideoChanges(changes []VideoChange) error
+}
+
+// InsertVideoChanges records every change in a single transaction. Changes
+// already recorded, and changes of videos no longer in postgres, are skipped.
+func (pg *PostgresVideoChangeStore) InsertVideoChanges(changes []VideoChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	query := `
+		INSERT INTO video_changes (video_id, changed_at, title_changed, thumbnail_changed)
+		SELECT $1::uuid, $2::timestamptz, $3::boolean, $4::boolean
+		WHERE EXISTS (SELECT 1 FROM videos WHERE id = $1)
+		ON CONFLICT (video_id, changed_at) DO NOTHING
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare video change insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, change := range changes {
+		_, err = stmt.Exec(change.VideoID, change.ChangedAt, change.TitleChanged, change.ThumbnailChanged)
+		if err != nil {
+			return fmt.Errorf("failed to insert video change: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type VideoWithoutLanguage struct {
+	ID         uuid.UUID
+	Youtube_ID string
+	Title      string
+}
+
+type PostgresVideoLanguageStore struct {
+	db *sql.DB
+}
+
+func NewPostgresVideoLanguageStore(db *sql.DB) *PostgresVideoLanguageStore {
+	return &PostgresVideoLanguageStore{db: db}
+}
+
+type VideoLanguageStore interface {
+	GetVideosWithoutLanguage(limit int) ([]VideoWithoutLanguage, error)
+	SetVideoLanguage(videoID uuid.UUID, language string) error
+}
+
+func (pg *PostgresVideoLanguageStore) GetVideosWithoutLanguage(limit int) ([]VideoWithoutLanguage, error) {
+
+	query := `
+		SELECT id, youtube_id, title
+		FROM videos
+		WHERE language IS NULL
+		ORDER BY created_at
+		LIMIT $1
+	`
+
+	rows, err := pg.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get videos without language: %w", err)
+	}
+	defer rows.Close()
+
+	videos := []VideoWithoutLanguage{}
+	for rows.Next() {
+		var video VideoWithoutLanguage
+		if err := rows.Scan(&video.ID, &video.Youtube_ID, &video.Title); err != nil {
+			return nil, fmt.Errorf("failed to scan video: %w", err)
+		}
+		videos = append(videos, video)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over video rows: %w", err)
+	}
+
+	return videos, nil
+}
+
+// SetVideoLanguage stores the language of a video. The search vector
+// triggers reindex it with the matching text search config.
+func (pg *PostgresVideoLanguageStore) SetVideoLanguage(videoID uuid.UUID, language string) error {
+	query := `
+		UPDATE videos
+		SET language = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+
+	_, err := pg.db.Exec(query, language, videoID)
+	if err != nil {
+		return fmt.Errorf("failed to set video language: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,368 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Search queries go through video_search_query, which parses them with every
+// text search config videos are indexed with, see migration 00008.
+
+// videoListQuery builds the count and select statements behind the public
+// video listing. Placeholders are handed out in the order clauses are added,
+// and every WHERE clause is added before any select-only clause, so the count
+// statement can run with just the prefix of args its WHERE clause uses.
+type videoListQuery struct {
+	params GetVideosParams
+	userID uuid.UUID
+
+	args      []interface{}
+	where     []string
+	whereArgs int
+
+	searchIdx  int
+	likeIdx    int
+	userIdx    int
+	phraseIdxs []int
+}
+
+func newVideoListQuery(params GetVideosParams, userID uuid.UUID) *videoListQuery {
+	q := &videoListQuery{
+		params: params,
+		userID: userID,
+		where:  []string{"v.is_active = true"},
+	}
+
+	if q.searching() {
+		searchQuery := params.Search.Text()
+		q.searchIdx = q.addArg(searchQuery)
+		q.likeIdx = q.addArg("%" + searchQuery + "%")
+
+		for _, phrase := range params.Search.Phrases {
+			q.phraseIdxs = append(q.phraseIdxs, q.addArg(containsPattern(phrase)))
+		}
+
+		q.where = append(q.where, q.searchClause())
+	}
+
+	q.addSearchOperators()
+	q.addFilters()
+
+	q.whereArgs = len(q.args)
+	return q
+}
+
+func (q *videoListQuery) addFilters() {
+	f := q.params.Filters
+
+	if f.ChannelID != "" {
+		q.where = append(q.where, fmt.Sprintf("v.channel_id = $%d", q.addArg(f.ChannelID)))
+	}
+	if f.PublishedAfter != nil {
+		q.where = append(q.where, fmt.Sprintf("v.published_at >= $%d", q.addArg(*f.PublishedAfter)))
+	}
+	if f.PublishedBefore != nil {
+		q.where = append(q.where, fmt.Sprintf("v.published_at < $%d", q.addArg(*f.PublishedBefore)))
+	}
+	if f.TrackedAfter != nil {
+		q.where = append(q.where, fmt.Sprintf("v.created_at >= $%d", q.addArg(*f.TrackedAfter)))
+	}
+	if f.TrackedBefore != nil {
+		q.where = append(q.where, fmt.Sprintf("v.created_at < $%d", q.addArg(*f.TrackedBefore)))
+	}
+	if f.MinChanges > 0 {
+		q.where = append(q.where, fmt.Sprintf("v.change_count >= $%d", q.addArg(f.MinChanges)))
+	}
+	if f.ChangedWithinDays > 0 {
+		q.where = append(q.where, fmt.Sprintf("v.last_changed_at >= NOW() - make_interval(days => $%d)", q.addArg(f.ChangedWithinDays)))
+	}
+	if f.Bookmarked != nil && q.userID != uuid.Nil {
+		bookmarked := fmt.Sprintf("EXISTS (SELECT 1 FROM bookmarks bf WHERE bf.video_id = v.id AND bf.user_id = $%d)", q.userArg())
+		if !*f.Bookmarked {
+			bookmarked = "NOT " + bookmarked
+		}
+		q.where = append(q.where, bookmarked)
+	}
+}
+
+// addSearchOperators compiles everything in the parsed query besides its
+// words and phrases, which searchClause handles.
+func (q *videoListQuery) addSearchOperators() {
+	sq := q.params.Search
+
+	for _, exclude := range sq.Excludes {
+		idx := q.addArg(containsPattern(exclude))
+		q.where = append(q.where, fmt.Sprintf("NOT (v.normalized_video_title LIKE $%d OR v.normalized_channel_title LIKE $%d)", idx, idx))
+	}
+	for _, channel := range sq.Channels {
+		q.where = append(q.where, fmt.Sprintf("(lower(v.channel_id) = $%d OR v.normalized_channel_title LIKE $%d)",
+			q.addArg(channel), q.addArg(containsPattern(channel))))
+	}
+	if sq.After != nil {
+		q.where = append(q.where, fmt.Sprintf("v.published_at >= $%d", q.addArg(*sq.After)))
+	}
+	if sq.Before != nil {
+		q.where = append(q.where, fmt.Sprintf("v.published_at < $%d", q.addArg(*sq.Before)))
+	}
+	for _, condition := range sq.Changes {
+		// Op is one of the operators parseChangeCondition accepts
+		q.where = append(q.where, fmt.Sprintf("v.change_count %s $%d", condition.Op, q.addArg(condition.Count)))
+	}
+	if sq.Bookmarked && q.userID != uuid.Nil {
+		q.where = append(q.where, fmt.Sprintf("EXISTS (SELECT 1 FROM bookmarks bq WHERE bq.video_id = v.id AND bq.user_id = $%d)", q.userArg()))
+	}
+}
+
+func (q *videoListQuery) addArg(arg interface{}) int {
+	q.args = append(q.args, arg)
+	return len(q.args)
+}
+
+// userArg returns the placeholder index of the current user's id, adding it
+// the first time it is needed.
+func (q *videoListQuery) userArg() int {
+	if q.userIdx == 0 {
+		q.userIdx = q.addArg(q.userID)
+	}
+	return q.userIdx
+}
+
+func (q *videoListQuery) searching() bool {
+	return q.params.Search.Text() != ""
+}
+
+// phraseMatch requires every quoted phrase to appear in column.
+func (q *videoListQuery) phraseMatch(column string) string {
+	var clause strings.Builder
+	for _, idx := range q.phraseIdxs {
+		fmt.Fprintf(&clause, " AND %s LIKE $%d", column, idx)
+	}
+	return clause.String()
+}
+
+// searchesHistory reports whether the query should match every title a video
+// has had rather than only its current one. Channel titles have no history.
+func (q *videoListQuery) searchesHistory() bool {
+	return q.searching() && q.params.Scope == ScopeHistory && q.params.Type == SearchVideo
+}
+
+func (q *videoListQuery) searchClause() string {
+	if q.searchesHistory() {
+		return fmt.Sprintf(`EXISTS (
+			SELECT 1
+			FROM video_title_versions tv
+			WHERE tv.video_id = v.id AND %s
+		)`, q.titleVersionMatch())
+	}
+
+	switch q.params.Type {
+	case SearchChannel:
+		return fmt.Sprintf(`(
+			v.normalized_channel_title ILIKE $%d
+			OR v.search_vector @@ video_search_query($%d)
+			OR similarity(v.normalized_channel_title, $%d) > 0.15
+		)%s`, q.likeIdx, q.searchIdx, q.searchIdx, q.phraseMatch("v.normalized_channel_title"))
+	default:
+		return fmt.Sprintf(`(
+			v.normalized_video_title ILIKE $%d
+			OR v.search_vector @@ video_search_query($%d)
+			OR similarity(v.normalized_video_title, $%d) > 0.15
+		)%s`, q.likeIdx, q.searchIdx, q.searchIdx, q.phraseMatch("v.normalized_video_title"))
+	}
+}
+
+// titleVersionMatch matches a single title version, so a phrase has to
+// appear in the same title as the rest of the query.
+func (q *videoListQuery) titleVersionMatch() string {
+	return fmt.Sprintf(`(
+		tv.normalized_title ILIKE $%d
+		OR tv.search_vector @@ video_search_query($%d)
+		OR similarity(tv.normalized_title, $%d) > 0.15
+	)%s`, q.likeIdx, q.searchIdx, q.searchIdx, q.phraseMatch("tv.normalized_title"))
+}
+
+func (q *videoListQuery) rankClause() string {
+	if !q.searching() {
+		// If there is no search query, there's no rank to be generated hence 0
+		return "0 AS rank"
+	}
+
+	if q.searchesHistory() {
+		return "COALESCE(mt.rank, 0.1) AS rank"
+	}
+
+	return fmt.Sprintf(`
+		CASE
+			WHEN v.search_vector @@ video_search_query($%d)
+			THEN ts_rank(v.search_vector, video_search_query($%d)) * 2.0
+			WHEN v.normalized_video_title ILIKE $%d OR v.normalized_channel_title ILIKE $%d
+			THEN 1.5
+			WHEN similarity(v.normalized_video_title, $%d) > 0.2 OR similarity(v.normalized_channel_title, $%d) > 0.15
+			THEN GREATEST(similarity(v.normalized_video_title, $%d), similarity(v.normalized_channel_title, $%d))
+			ELSE 0.1
+		END AS rank
+	`, q.searchIdx, q.searchIdx, q.likeIdx, q.likeIdx, q.searchIdx, q.searchIdx, q.searchIdx, q.searchIdx)
+}
+
+// matchedTitleJoin picks the best matching title version of each video, so
+// history search can say which title matched and when it was live.
+func (q *videoListQuery) matchedTitleJoin() string {
+	if !q.searchesHistory() {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+		LEFT JOIN LATERAL (
+			SELECT
+				tv.title,
+				tv.first_seen_at,
+				tv.last_seen_at,
+				CASE
+					WHEN tv.search_vector @@ video_search_query($%d)
+					THEN ts_rank(tv.search_vector, video_search_query($%d)) * 2.0
+					WHEN tv.normalized_title ILIKE $%d
+					THEN 1.5
+					ELSE similarity(tv.normalized_title, $%d)
+				END AS rank
+			FROM video_title_versions tv
+			WHERE tv.video_id = v.id AND %s
+			ORDER BY rank DESC, tv.last_seen_at DESC
+			LIMIT 1
+		) mt ON true
+	`, q.searchIdx, q.searchIdx, q.likeIdx, q.searchIdx, q.titleVersionMatch())
+}
+
+func (q *videoListQuery) matchedTitleColumns() string {
+	if !q.searchesHistory() {
+		return "NULL::text AS matched_title, NULL::timestamptz AS matched_first_seen_at, NULL::timestamptz AS matched_last_seen_at"
+	}
+	return "mt.title AS matched_title, mt.first_seen_at AS matched_first_seen_at, mt.last_seen_at AS matched_last_seen_at"
+}
+
+func (q *videoListQuery) orderClause() string {
+	switch q.params.SortBy {
+	case SortByRecent:
+		if q.searching() {
+			return "ORDER BY rank DESC, v.created_at DESC"
+		}
+		return "ORDER BY v.created_at DESC"
+	case SortByPopular:
+		if q.searching() {
+			return "ORDER BY popularity_score DESC, rank DESC"
+		}
+		return "ORDER BY popularity_score DESC"
+	case SortByMostChanged:
+		if q.searching() {
+			return "ORDER BY v.change_count DESC, rank DESC"
+		}
+		return "ORDER BY v.change_count DESC, v.last_changed_at DESC NULLS LAST"
+	case SortByRecentlyChanged:
+		if q.searching() {
+			return "ORDER BY v.last_changed_at DESC NULLS LAST, rank DESC"
+		}
+		return "ORDER BY v.last_changed_at DESC NULLS LAST, v.created_at DESC"
+	case SortByTrending:
+		if q.searching() {
+			return "ORDER BY v.trending_score DESC, rank DESC"
+		}
+		return "ORDER BY v.trending_score DESC, v.created_at DESC"
+	}
+	return "ORDER BY v.created_at DESC"
+}
+
+func (q *videoListQuery) countSQL() (string, []interface{}) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM videos v
+		WHERE %s
+	`, strings.Join(q.where, " AND "))
+
+	return query, q.args[:q.whereArgs]
+}
+
+func (q *videoListQuery) channelFacetSQL() (string, []interface{}) {
+	query := fmt.Sprintf(`
+		SELECT v.channel_id, MAX(v.channel_title) AS channel_title, COUNT(*) AS video_count
+		FROM videos v
+		WHERE %s AND v.channel_id IS NOT NULL
+		GROUP BY v.channel_id
+		ORDER BY video_count DESC, channel_title
+		LIMIT 20
+	`, strings.Join(q.where, " AND "))
+
+	return query, q.args[:q.whereArgs]
+}
+
+// changeBucketFacetSQL counts matching videos per changeBuckets bound.
+func (q *videoListQuery) changeBucketFacetSQL() (string, []interface{}) {
+	query := fmt.Sprintf(`
+		SELECT
+			CASE
+				WHEN v.change_count = 0 THEN 0
+				WHEN v.change_count = 1 THEN 1
+				WHEN v.change_count <= 5 THEN 2
+				WHEN v.change_count <= 10 THEN 6
+				ELSE 11
+			END AS min_changes,
+			COUNT(*)
+		FROM videos v
+		WHERE %s
+		GROUP BY min_changes
+	`, strings.Join(q.where, " AND "))
+
+	return query, q.args[:q.whereArgs]
+}
+
+func (q *videoListQuery) selectSQL() (string, []interface{}) {
+	bookmarkJoin := ""
+	isBookmarked := "false"
+	if q.userID != uuid.Nil {
+		bookmarkJoin = fmt.Sprintf("LEFT JOIN bookmarks bu ON v.id = bu.video_id AND bu.user_id = $%d", q.userArg())
+		isBookmarked = "bu.id IS NOT NULL"
+	}
+
+	offset := (q.params.Page - 1) * q.params.Limit
+
+	query := fmt.Sprintf(`
+		SELECT
+			v.id,
+			v.link,
+			v.published_at,
+			v.title,
+			v.description,
+			v.thumbnail,
+			v.youtube_id,
+			v.channel_title,
+			v.channel_id,
+			COALESCE(v.language, ''),
+			v.user_id,
+			v.is_active,
+			v.visits,
+			v.created_at,
+			v.updated_at,
+			COALESCE(bc.bookmark_count, 0) AS bookmark_count,
+			v.change_count,
+			v.last_changed_at,
+			v.trending_score,
+			%s AS is_bookmarked,
+			%s,
+			%s,
+			(COALESCE(bc.bookmark_count, 0) * 3.0 + COALESCE(v.visits, 0) * 1.0) AS popularity_score
+		FROM videos v
+		%s
+		LEFT JOIN (
+			SELECT video_id, COUNT(*) AS bookmark_count
+			FROM bookmarks
+			GROUP BY video_id
+		) bc ON v.id = bc.video_id
+		%s
+		WHERE %s
+		%s
+		LIMIT %d OFFSET %d
+	`, isBookmarked, q.rankClause(), q.matchedTitleColumns(), bookmarkJoin, q.matchedTitleJoin(),
+		strings.Join(q.where, " AND "), q.orderClause(), q.params.Limit, offset)
+
+	return query, q.args
+}
@@ -2,12 +2,15 @@ package store
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/grvbrk/nazrein_server/internal/models"
 )
 
+var ErrDuplicateVideoRequest = errors.New("video already requested")
+
 type PostgresVideoRequestStore struct {
 	db *sql.DB
 }
@@ -24,17 +27,30 @@ type VideoRequestStore interface {
 	GetTotalPendingRequestsByUserID(UserID uuid.UUID) (int, error)
 }
 
+// CreateVideoRequest adds a request of the user, or errors with
+// ErrDuplicateVideoRequest when they already requested the video.
 func (pvr *PostgresVideoRequestStore) CreateVideoRequest(vr *models.VideoRequest, userID uuid.UUID) error {
 
 	query := `
-	INSERT INTO video_requests (link, youtube_id, user_id)
-	VALUES ($1, $2, $3)
+	INSERT INTO video_requests (link, youtube_id, channel_id, channel_title, user_id)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (youtube_id, user_id) DO NOTHING
 	`
 
-	_, err := pvr.db.Exec(query, vr.Link, vr.Youtube_ID, userID)
+	result, err := pvr.db.Exec(query, vr.Link, vr.Youtube_ID, vr.Channel_ID, vr.Channel_Title, userID)
 	if err != nil {
 		return fmt.Errorf("failed to insert video request: %w", err)
 	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateVideoRequest
+	}
+
 	return nil
 }
 
@@ -56,8 +72,10 @@ func (pvr *PostgresVideoRequestStore) DeleteVideoRequest(requestID uuid.UUID) er
 func (pvr *PostgresVideoRequestStore) GetAllVideoRequestByUserID(UserID uuid.UUID) ([]models.VideoRequest, error) {
 	var videoRequests []models.VideoRequest
 	query := `
-	SELECT * FROM video_requests
+	SELECT id, status, link, youtube_id, channel_id, channel_title, user_id, processed_by, processed_at, rejection_reason, created_at, updated_at
+	FROM video_requests
 	WHERE user_id = $1
+	ORDER BY created_at DESC
 	`
 
 	rows, err := pvr.db.Query(query, UserID)
@@ -67,7 +85,7 @@ func (pvr *PostgresVideoRequestStore) GetAllVideoRequestByUserID(UserID uuid.UUI
 
 	for rows.Next() {
 		var videoRequest models.VideoRequest
-		err = rows.Scan(&videoRequest.Id, &videoRequest.Status, &videoRequest.Link, &videoRequest.Youtube_ID, &videoRequest.UserId, &videoRequest.ProcessedBy, &videoRequest.ProcessedAt, &videoRequest.RejectionReason, &videoRequest.Created_At, &videoRequest.Updated_At)
+		err = rows.Scan(&videoRequest.Id, &videoRequest.Status, &videoRequest.Link, &videoRequest.Youtube_ID, &videoRequest.Channel_ID, &videoRequest.Channel_Title, &videoRequest.UserId, &videoRequest.ProcessedBy, &videoRequest.ProcessedAt, &videoRequest.RejectionReason, &videoRequest.Created_At, &videoRequest.Updated_At)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan video request: %w", err)
 		}
@@ -100,7 +118,7 @@ func (pvr *PostgresVideoRequestStore) GetTotalPendingRequestsByUserID(UserID uui
 	query := `
 		SELECT COUNT(*)
 		FROM video_requests
-		WHERE user_id = $1 AND status = 'PENDING'
+		WHERE user_id = $1 AND status IN ('PENDING', 'UNDER_REVIEW')
 	`
 
 	err := pvr.db.QueryRow(query, UserID).Scan(&totalRequests)
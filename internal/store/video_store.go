@@ -12,24 +12,49 @@ import (
 
 type SortBy string
 type SearchType string
+type SearchScope string
 
 const (
-	SortByPopular SortBy     = "popular"
-	SortByRecent  SortBy     = "recent"
-	SearchVideo   SearchType = "video"
-	SearchChannel SearchType = "channel"
+	SortByPopular         SortBy      = "popular"
+	SortByRecent          SortBy      = "recent"
+	SortByMostChanged     SortBy      = "most_changed"
+	SortByRecentlyChanged SortBy      = "recently_changed"
+	SortByTrending        SortBy      = "trending"
+	SearchVideo           SearchType  = "video"
+	SearchChannel         SearchType  = "channel"
+	ScopeCurrent          SearchScope = "current"
+	ScopeHistory          SearchScope = "history"
 )
 
+// GetVideosParams describe a listing. Query is the raw q parameter, the
+// listing is built from its parsed form in Search.
 type GetVideosParams struct {
-	Page   int
-	Limit  int
-	Query  string
-	SortBy SortBy
-	Type   SearchType
+	Page    int
+	Limit   int
+	Query   string
+	Search  SearchQuery
+	SortBy  SortBy
+	Type    SearchType
+	Scope   SearchScope
+	Filters VideoFilters
+}
+
+// VideoFilters narrow the public listing. Zero values mean no filter.
+type VideoFilters struct {
+	ChannelID         string
+	PublishedAfter    *time.Time
+	PublishedBefore   *time.Time
+	TrackedAfter      *time.Time
+	TrackedBefore     *time.Time
+	MinChanges        int
+	ChangedWithinDays int
+	// Bookmarked only applies when the listing is for a logged in user
+	Bookmarked *bool
 }
 
 type VideosResponse struct {
 	Videos  []VideoWithCounts `json:"videos"`
+	Facets  *VideoFacets      `json:"facets"`
 	Page    int               `json:"page"`
 	Limit   int               `json:"limit"`
 	Total   int               `json:"total"`
@@ -38,15 +63,49 @@ type VideosResponse struct {
 
 type VideoWithBookmarksResponse struct {
 	Videos  []BookmarkedVideoWithCounts `json:"videos"`
+	Facets  *VideoFacets                `json:"facets"`
 	Page    int                         `json:"page"`
 	Limit   int                         `json:"limit"`
 	Total   int                         `json:"total"`
 	HasMore bool                        `json:"has_more"`
 }
 
+// VideoFacets count the videos matching a listing, grouped for the filter
+// sidebar. Each facet ignores its own filter, so picking a channel still
+// shows how many videos the other channels have.
+type VideoFacets struct {
+	Channels      []ChannelFacet      `json:"channels"`
+	ChangeBuckets []ChangeBucketFacet `json:"change_buckets"`
+}
+
+type ChannelFacet struct {
+	ChannelID    string `json:"channel_id"`
+	ChannelTitle string `json:"channel_title"`
+	Count        int    `json:"count"`
+}
+
+type ChangeBucketFacet struct {
+	Label      string `json:"label"`
+	MinChanges int    `json:"min_changes"`
+	Count      int    `json:"count"`
+}
+
 type VideoWithCounts struct {
 	models.Video
-	BookmarkCount int `json:"bookmark_count"`
+	BookmarkCount int                `json:"bookmark_count"`
+	ChangeCount   int                `json:"change_count"`
+	LastChangedAt *time.Time         `json:"last_changed_at"`
+	TrendingScore float64            `json:"trending_score"`
+	MatchedTitle  *TitleVersionMatch `json:"matched_title,omitempty"`
+}
+
+// TitleVersionMatch is the title a history search matched on, and when the
+// video carried it.
+type TitleVersionMatch struct {
+	Title       string    `json:"title"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	IsCurrent   bool      `json:"is_current"`
 }
 
 type BookmarkedVideoWithCounts struct {
@@ -59,9 +118,27 @@ type BookmarkedVideo struct {
 	Bookmarked_At time.Time `json:"bookmarked_at"`
 }
 
-type SimilarVideo struct {
-	Title        string `json:"title"`
-	Channel_Name string `json:"channel_name"`
+// AutocompleteSuggestions groups the suggestions for a partial query.
+type AutocompleteSuggestions struct {
+	Videos   []VideoSuggestion   `json:"videos"`
+	Channels []ChannelSuggestion `json:"channels"`
+}
+
+type VideoSuggestion struct {
+	ID              uuid.UUID   `json:"id"`
+	Title           string      `json:"title"`
+	ChannelID       string      `json:"channel_id"`
+	ChannelTitle    string      `json:"channel_title"`
+	TitleHighlights []MatchSpan `json:"title_highlights"`
+	ChangeCount     int         `json:"change_count"`
+	LastChangedAt   *time.Time  `json:"last_changed_at"`
+}
+
+type ChannelSuggestion struct {
+	ChannelID    string      `json:"channel_id"`
+	ChannelTitle string      `json:"channel_title"`
+	VideoCount   int         `json:"video_count"`
+	Highlights   []MatchSpan `json:"highlights"`
 }
 
 type PostgresVideoStore struct {
@@ -81,321 +158,77 @@ type VideoStore interface {
 	GetVideosByUserID(userID uuid.UUID) ([]models.Video, error)
 	GetVideoByID(videoID uuid.UUID) (*VideoWithCounts, error)
 	GetBookmarkedVideosByUserID(userID uuid.UUID) ([]BookmarkedVideo, error)
-	GetSimilarVideosByName(name string) ([]SimilarVideo, error)
+	GetSimilarVideosByName(name string) (*AutocompleteSuggestions, error)
 }
 
 func (pg *PostgresVideoStore) GetVideos(params GetVideosParams) (*VideosResponse, error) {
-	offset := (params.Page - 1) * params.Limit
-	orderClause := "ORDER BY v.created_at DESC"
-
-	switch params.SortBy {
-	case SortByRecent:
-		orderClause = "ORDER BY v.created_at DESC"
-	case SortByPopular:
-		orderClause = "ORDER BY popularity_score DESC"
-	}
-
-	whereClauses := []string{"v.is_active = true"}
-	args := []interface{}{}
-	argPos := 1
-
-	var rankClause string
-
-	// If there is a search query
-	if strings.TrimSpace(params.Query) != "" {
-		rawQuery := strings.TrimSpace(params.Query)
-		searchQuery := strings.ToLower(rawQuery)
-		likeQuery := "%" + searchQuery + "%"
-
-		args = append(args, searchQuery)
-		searchIdx := argPos
-		argPos++
-
-		args = append(args, likeQuery)
-		likeIdx := argPos
-
-		var typeClause string
-		switch params.Type {
-		case SearchVideo:
-			typeClause = fmt.Sprintf(`(
-				v.normalized_video_title ILIKE $%d
-				OR v.search_vector @@ plainto_tsquery('english', $%d)
-				OR similarity(v.normalized_video_title, $%d) > 0.15
-			)`, likeIdx, searchIdx, searchIdx)
-
-		case SearchChannel:
-			typeClause = fmt.Sprintf(`(
-				v.normalized_channel_title ILIKE $%d
-				OR v.search_vector @@ plainto_tsquery('english', $%d)
-				OR similarity(v.normalized_channel_title, $%d) > 0.15
-			)`, likeIdx, searchIdx, searchIdx)
-		}
-
-		whereClauses = append(whereClauses, typeClause)
-
-		rankClause = fmt.Sprintf(`
-			CASE
-				WHEN v.search_vector @@ plainto_tsquery('english', $%d)
-				THEN ts_rank(v.search_vector, plainto_tsquery('english', $%d)) * 2.0
-				WHEN v.normalized_video_title ILIKE $%d OR v.normalized_channel_title ILIKE $%d
-				THEN 1.5
-				WHEN similarity(v.normalized_video_title, $%d) > 0.2 OR similarity(v.normalized_channel_title, $%d) > 0.15
-				THEN GREATEST(similarity(v.normalized_video_title, $%d), similarity(v.normalized_channel_title, $%d))
-				ELSE 0.1
-			END AS rank
-		`, searchIdx, searchIdx, likeIdx, likeIdx, searchIdx, searchIdx, searchIdx, searchIdx)
-
-		switch params.SortBy {
-		case SortByRecent:
-			orderClause = "ORDER BY rank DESC, v.created_at DESC"
-		case SortByPopular:
-			orderClause = "ORDER BY popularity_score DESC, rank DESC"
-		}
-	} else {
-		// If there is no search query, there's no rank to be generated hence 0
-		rankClause = "0 as rank"
-	}
-
-	// CountQuery will look like this
-	// SELECT COUNT(*)
-	// FROM videos v
-	// WHERE v.is_active = true AND (
-	// 	v.normalized_video_title ILIKE $2
-	// 	OR v.search_vector @@ plainto_tsquery('english', $1)
-	// 	OR similarity(v.normalized_video_title, $1) > 0.15
-	// )
-
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*)
-		FROM videos v
-		WHERE %s
-	`, strings.Join(whereClauses, " AND "))
-
-	var total int
-	if err := pg.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
-		return nil, fmt.Errorf("failed to get total video count: %w", err)
-	}
-
-	// Main query
-	selectQuery := fmt.Sprintf(`
-		SELECT
-			v.id,
-			v.link,
-			v.published_at,
-			v.title,
-			v.description,
-			v.thumbnail,
-			v.youtube_id,
-			v.channel_title,
-			v.channel_id,
-			v.user_id,
-			v.is_active,
-			v.visits,
-			v.created_at,
-			v.updated_at,
-			COALESCE(b.bookmark_count, 0) as bookmark_count,
-			%s,
-			(COALESCE(b.bookmark_count, 0) * 3.0 + COALESCE(v.visits, 0) * 1.0) as popularity_score
-		FROM videos v
-		LEFT JOIN (
-			SELECT video_id, COUNT(*) as bookmark_count
-			FROM bookmarks
-			GROUP BY video_id
-		) b ON v.id = b.video_id
-		WHERE %s
-		%s
-		LIMIT %d OFFSET %d
-	`, rankClause, strings.Join(whereClauses, " AND "), orderClause, params.Limit, offset)
-
-	rows, err := pg.db.Query(selectQuery, args...)
+	listed, total, err := pg.listVideos(params, uuid.Nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get videos: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
-
-	videos := []VideoWithCounts{}
-	for rows.Next() {
-		var v VideoWithCounts
-		var rank float64
-		var popularityScore float64
-
-		if err := rows.Scan(
-			&v.Id,
-			&v.Link,
-			&v.Published_At,
-			&v.Title,
-			&v.Description,
-			&v.Thumbnail,
-			&v.Youtube_ID,
-			&v.Channel_Title,
-			&v.Channel_ID,
-			&v.User_ID,
-			&v.Is_Active,
-			&v.Visits,
-			&v.Created_At,
-			&v.Updated_At,
-			&v.BookmarkCount,
-			&rank,
-			&popularityScore,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan video row: %w", err)
-		}
 
-		videos = append(videos, v)
+	facets, err := pg.getVideoFacets(params, uuid.Nil)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over video rows: %w", err)
+	videos := make([]VideoWithCounts, 0, len(listed))
+	for _, v := range listed {
+		videos = append(videos, v.VideoWithCounts)
 	}
 
-	hasMore := offset+len(videos) < total
+	offset := (params.Page - 1) * params.Limit
 
 	return &VideosResponse{
 		Videos:  videos,
+		Facets:  facets,
 		Page:    params.Page,
 		Limit:   params.Limit,
 		Total:   total,
-		HasMore: hasMore,
+		HasMore: offset+len(videos) < total,
 	}, nil
 }
 
 func (pg *PostgresVideoStore) GetVideosWithUserBookmarks(params GetVideosParams, userID uuid.UUID) (*VideoWithBookmarksResponse, error) {
-	offset := (params.Page - 1) * params.Limit
-	orderClause := "ORDER BY v.created_at DESC"
+	videos, total, err := pg.listVideos(params, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	switch params.SortBy {
-	case SortByRecent:
-		orderClause = "ORDER BY v.created_at DESC"
-	case SortByPopular:
-		orderClause = "ORDER BY popularity_score DESC"
-	}
-
-	countArgs := []interface{}{}
-	mainArgs := []interface{}{userID}
-
-	baseWhereClauses := []string{"v.is_active = true"}
-	countWhereClauses := make([]string, len(baseWhereClauses))
-	mainWhereClauses := make([]string, len(baseWhereClauses))
-	copy(countWhereClauses, baseWhereClauses)
-	copy(mainWhereClauses, baseWhereClauses)
-
-	var rankClause string
-
-	if strings.TrimSpace(params.Query) != "" {
-		searchQuery := strings.ToLower(strings.TrimSpace(params.Query))
-		likeQuery := "%" + searchQuery + "%"
-
-		// For count query - parameters start at $1
-		searchIdx := 1
-		likeIdx := 2
-		countArgs = append(countArgs, searchQuery, likeQuery)
-
-		// For main query - parameters start at $2 (since $1 is userID)
-		mainSearchIdx := 2
-		mainLikeIdx := 3
-		mainArgs = append(mainArgs, searchQuery, likeQuery)
-
-		var typeClauseCount, typeClauseMain string
-
-		switch params.Type {
-		case SearchVideo:
-			typeClauseCount = fmt.Sprintf(`(
-				v.normalized_video_title ILIKE $%d
-				OR v.search_vector @@ plainto_tsquery('english', $%d)
-				OR similarity(v.normalized_video_title, $%d) > 0.15
-			)`, likeIdx, searchIdx, searchIdx)
-
-			typeClauseMain = fmt.Sprintf(`(
-				v.normalized_video_title ILIKE $%d
-				OR v.search_vector @@ plainto_tsquery('english', $%d)
-				OR similarity(v.normalized_video_title, $%d) > 0.15
-			)`, mainLikeIdx, mainSearchIdx, mainSearchIdx)
-
-		case SearchChannel:
-			typeClauseCount = fmt.Sprintf(`(
-				v.normalized_channel_title ILIKE $%d
-				OR v.search_vector @@ plainto_tsquery('english', $%d)
-				OR similarity(v.normalized_channel_title, $%d) > 0.15
-			)`, likeIdx, searchIdx, searchIdx)
-
-			typeClauseMain = fmt.Sprintf(`(
-				v.normalized_channel_title ILIKE $%d
-				OR v.search_vector @@ plainto_tsquery('english', $%d)
-				OR similarity(v.normalized_channel_title, $%d) > 0.15
-			)`, mainLikeIdx, mainSearchIdx, mainSearchIdx)
-		}
+	facets, err := pg.getVideoFacets(params, userID)
+	if err != nil {
+		return nil, err
+	}
 
-		countWhereClauses = append(countWhereClauses, typeClauseCount)
-		mainWhereClauses = append(mainWhereClauses, typeClauseMain)
+	offset := (params.Page - 1) * params.Limit
 
-		rankClause = fmt.Sprintf(`
-			CASE
-				WHEN v.search_vector @@ plainto_tsquery('english', $%d)
-				THEN ts_rank(v.search_vector, plainto_tsquery('english', $%d)) * 2.0
-				WHEN v.normalized_video_title ILIKE $%d OR v.normalized_channel_title ILIKE $%d
-				THEN 1.5
-				WHEN similarity(v.normalized_video_title, $%d) > 0.2 OR similarity(v.normalized_channel_title, $%d) > 0.15
-				THEN GREATEST(similarity(v.normalized_video_title, $%d), similarity(v.normalized_channel_title, $%d))
-				ELSE 0.1
-			END AS rank
-		`, mainSearchIdx, mainSearchIdx, mainLikeIdx, mainLikeIdx, mainSearchIdx, mainSearchIdx, mainSearchIdx, mainSearchIdx)
-
-		switch params.SortBy {
-		case SortByRecent:
-			orderClause = "ORDER BY rank DESC, v.created_at DESC"
-		case SortByPopular:
-			orderClause = "ORDER BY popularity_score DESC, rank DESC"
-		}
-	} else {
-		rankClause = "0 as rank"
-	}
+	return &VideoWithBookmarksResponse{
+		Videos:  videos,
+		Facets:  facets,
+		Page:    params.Page,
+		Limit:   params.Limit,
+		Total:   total,
+		HasMore: offset+len(videos) < total,
+	}, nil
+}
 
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*)
-		FROM videos v
-		WHERE %s
-	`, strings.Join(countWhereClauses, " AND "))
+// listVideos runs the public listing for a page of params. When userID is
+// uuid.Nil every video is reported as not bookmarked.
+func (pg *PostgresVideoStore) listVideos(params GetVideosParams, userID uuid.UUID) ([]BookmarkedVideoWithCounts, int, error) {
+	q := newVideoListQuery(params, userID)
+
+	countQuery, countArgs := q.countSQL()
 
 	var total int
 	if err := pg.db.QueryRow(countQuery, countArgs...).Scan(&total); err != nil {
-		return nil, fmt.Errorf("failed to get total video count: %w", err)
+		return nil, 0, fmt.Errorf("failed to get total video count: %w", err)
 	}
 
-	mainQuery := fmt.Sprintf(`
-		SELECT
-			v.id,
-			v.link,
-			v.published_at,
-			v.title,
-			v.description,
-			v.thumbnail,
-			v.youtube_id,
-			v.channel_title,
-			v.channel_id,
-			v.user_id,
-			v.is_active,
-			v.visits,
-			v.created_at,
-			v.updated_at,
-			COALESCE(bc.bookmark_count, 0) AS bookmark_count,
-			CASE WHEN bu.id IS NOT NULL THEN true ELSE false END AS is_bookmarked,
-			%s,
-			(COALESCE(bc.bookmark_count, 0) * 3.0 + COALESCE(v.visits, 0) * 1.0) AS popularity_score
-		FROM videos v
-		LEFT JOIN bookmarks bu ON v.id = bu.video_id AND bu.user_id = $1
-		LEFT JOIN (
-			SELECT video_id, COUNT(*) AS bookmark_count
-			FROM bookmarks
-			GROUP BY video_id
-		) bc ON v.id = bc.video_id
-		WHERE %s
-		%s
-		LIMIT %d OFFSET %d
-	`, rankClause, strings.Join(mainWhereClauses, " AND "), orderClause, params.Limit, offset)
-
-	rows, err := pg.db.Query(mainQuery, mainArgs...)
+	selectQuery, selectArgs := q.selectSQL()
+
+	rows, err := pg.db.Query(selectQuery, selectArgs...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get videos with bookmarks: %w", err)
+		return nil, 0, fmt.Errorf("failed to get videos: %w", err)
 	}
 	defer rows.Close()
 
@@ -403,32 +236,107 @@ func (pg *PostgresVideoStore) GetVideosWithUserBookmarks(params GetVideosParams,
 	for rows.Next() {
 		var v BookmarkedVideoWithCounts
 		var rank, popularityScore float64
+		var matchedTitle sql.NullString
+		var matchedFirstSeenAt, matchedLastSeenAt sql.NullTime
 
 		if err := rows.Scan(
 			&v.Id, &v.Link, &v.Published_At, &v.Title, &v.Description, &v.Thumbnail,
-			&v.Youtube_ID, &v.Channel_Title, &v.Channel_ID, &v.User_ID, &v.Is_Active,
-			&v.Visits, &v.Created_At, &v.Updated_At, &v.BookmarkCount, &v.IsBookmarked,
-			&rank, &popularityScore,
+			&v.Youtube_ID, &v.Channel_Title, &v.Channel_ID, &v.Language, &v.User_ID, &v.Is_Active,
+			&v.Visits, &v.Created_At, &v.Updated_At, &v.BookmarkCount, &v.ChangeCount, &v.LastChangedAt, &v.TrendingScore, &v.IsBookmarked,
+			&rank, &matchedTitle, &matchedFirstSeenAt, &matchedLastSeenAt, &popularityScore,
 		); err != nil {
-			return nil, fmt.Errorf("failed to scan video row: %w", err)
+			return nil, 0, fmt.Errorf("failed to scan video row: %w", err)
+		}
+
+		if matchedTitle.Valid {
+			v.MatchedTitle = &TitleVersionMatch{
+				Title:       matchedTitle.String,
+				FirstSeenAt: matchedFirstSeenAt.Time,
+				LastSeenAt:  matchedLastSeenAt.Time,
+				IsCurrent:   matchedTitle.String == v.Title,
+			}
 		}
 
 		videos = append(videos, v)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over video rows: %w", err)
+		return nil, 0, fmt.Errorf("error iterating over video rows: %w", err)
 	}
 
-	return &VideoWithBookmarksResponse{
-		Videos:  videos,
-		Page:    params.Page,
-		Limit:   params.Limit,
-		Total:   total,
-		HasMore: offset+len(videos) < total,
-	}, nil
+	return videos, total, nil
+}
+
+// changeBuckets are the change count ranges of the change facet, by their
+// lower bound. videoListQuery.changeBucketFacetSQL buckets on the same bounds.
+var changeBuckets = []ChangeBucketFacet{
+	{Label: "0", MinChanges: 0},
+	{Label: "1", MinChanges: 1},
+	{Label: "2-5", MinChanges: 2},
+	{Label: "6-10", MinChanges: 6},
+	{Label: "11+", MinChanges: 11},
+}
+
+func (pg *PostgresVideoStore) getVideoFacets(params GetVideosParams, userID uuid.UUID) (*VideoFacets, error) {
+	facets := &VideoFacets{
+		Channels:      []ChannelFacet{},
+		ChangeBuckets: []ChangeBucketFacet{},
+	}
+
+	channelParams := params
+	channelParams.Filters.ChannelID = ""
+	channelQuery, channelArgs := newVideoListQuery(channelParams, userID).channelFacetSQL()
+
+	rows, err := pg.db.Query(channelQuery, channelArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel facets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet ChannelFacet
+		if err := rows.Scan(&facet.ChannelID, &facet.ChannelTitle, &facet.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan channel facet: %w", err)
+		}
+		facets.Channels = append(facets.Channels, facet)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over channel facets: %w", err)
+	}
+
+	changeParams := params
+	changeParams.Filters.MinChanges = 0
+	changeQuery, changeArgs := newVideoListQuery(changeParams, userID).changeBucketFacetSQL()
+
+	bucketRows, err := pg.db.Query(changeQuery, changeArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get change facets: %w", err)
+	}
+	defer bucketRows.Close()
+
+	counts := map[int]int{}
+	for bucketRows.Next() {
+		var minChanges, count int
+		if err := bucketRows.Scan(&minChanges, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan change facet: %w", err)
+		}
+		counts[minChanges] = count
+	}
+
+	if err := bucketRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over change facets: %w", err)
+	}
+
+	for _, bucket := range changeBuckets {
+		bucket.Count = counts[bucket.MinChanges]
+		facets.ChangeBuckets = append(facets.ChangeBuckets, bucket)
+	}
+
+	return facets, nil
 }
 
+// GetVideosByUserID returns the videos the user watches, latest first.
 func (pg *PostgresVideoStore) GetVideosByUserID(userId uuid.UUID) ([]models.Video, error) {
 
 	query := `
@@ -448,7 +356,9 @@ func (pg *PostgresVideoStore) GetVideosByUserID(userId uuid.UUID) ([]models.Vide
 		v.created_at,
 		v.updated_at
 	FROM videos v
-	WHERE v.user_id = $1
+	JOIN video_watchers w ON w.video_id = v.id
+	WHERE w.user_id = $1
+	ORDER BY w.created_at DESC
 	`
 
 	rows, err := pg.db.Query(query, userId)
@@ -515,6 +425,20 @@ func (pg *PostgresVideoStore) GetVideoByID(videoID uuid.UUID) (*VideoWithCounts,
 		return nil, fmt.Errorf("failed to update video visits: %w", err)
 	}
 
+	query = `
+		INSERT INTO video_daily_visits (video_id, day, visits)
+		SELECT id, CURRENT_DATE, 1
+		FROM videos
+		WHERE id = $1
+		ON CONFLICT (video_id, day) DO UPDATE
+		SET visits = video_daily_visits.visits + 1
+	`
+
+	_, err = tx.Exec(query, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update video daily visits: %w", err)
+	}
+
 	query = `
 	SELECT
 		v.id,
@@ -526,12 +450,16 @@ func (pg *PostgresVideoStore) GetVideoByID(videoID uuid.UUID) (*VideoWithCounts,
 		v.youtube_id,
 		v.channel_title,
 		v.channel_id,
+		COALESCE(v.language, ''),
 		v.user_id,
 		v.is_active,
 		v.visits,
 		v.created_at,
 		v.updated_at,
-		COALESCE(b.bookmark_count, 0) as bookmark_count
+		COALESCE(b.bookmark_count, 0) as bookmark_count,
+		v.change_count,
+		v.last_changed_at,
+		v.trending_score
 	FROM videos v
 	LEFT JOIN (
 		SELECT video_id, COUNT(*) as bookmark_count
@@ -554,12 +482,16 @@ func (pg *PostgresVideoStore) GetVideoByID(videoID uuid.UUID) (*VideoWithCounts,
 		&video.Youtube_ID,
 		&video.Channel_Title,
 		&video.Channel_ID,
+		&video.Language,
 		&video.User_ID,
 		&video.Is_Active,
 		&video.Visits,
 		&video.Created_At,
 		&video.Updated_At,
 		&video.BookmarkCount,
+		&video.ChangeCount,
+		&video.LastChangedAt,
+		&video.TrendingScore,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan video: %w", err)
@@ -637,81 +569,183 @@ func (pg *PostgresVideoStore) GetBookmarkedVideosByUserID(userID uuid.UUID) ([]B
 	return bookmarkedVideos, nil
 }
 
-func (pg *PostgresVideoStore) GetSimilarVideosByName(name string) ([]SimilarVideo, error) {
+func (pg *PostgresVideoStore) GetSimilarVideosByName(name string) (*AutocompleteSuggestions, error) {
 
 	normalizedInput := strings.ToLower(strings.TrimSpace(name))
 	prefixPattern := normalizedInput + "%"
 	likePattern := "%" + normalizedInput + "%"
 
+	videos, err := pg.getVideoSuggestions(normalizedInput, prefixPattern, likePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := pg.getChannelSuggestions(normalizedInput, prefixPattern, likePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AutocompleteSuggestions{
+		Videos:   videos,
+		Channels: channels,
+	}, nil
+}
+
+func (pg *PostgresVideoStore) getVideoSuggestions(normalizedInput, prefixPattern, likePattern string) ([]VideoSuggestion, error) {
+
 	query := `
 	WITH ranked_results AS (
-		SELECT DISTINCT
+		SELECT
+			v.id,
 			v.title,
+			v.channel_id,
 			v.channel_title,
+			v.change_count,
+			v.last_changed_at,
 			v.visits,
 			CASE
 				WHEN v.normalized_video_title ILIKE $2 THEN 1.0
-				WHEN v.normalized_channel_title ILIKE $2 THEN 0.9
 				WHEN v.normalized_video_title ILIKE $3 THEN 0.7
-				WHEN v.normalized_channel_title ILIKE $3 THEN 0.6
-				ELSE GREATEST(
-					similarity(v.normalized_video_title, $1),
-					similarity(v.normalized_channel_title, $1)
-				)
+				ELSE similarity(v.normalized_video_title, $1)
 			END as relevance_score
 		FROM videos v
 		WHERE v.is_active = true
 			AND (
 				v.normalized_video_title ILIKE $2
-				OR v.normalized_channel_title ILIKE $2
 				OR v.normalized_video_title ILIKE $3
-				OR v.normalized_channel_title ILIKE $3
 				OR v.normalized_video_title % $1
-				OR v.normalized_channel_title % $1
 			)
 	)
 	SELECT
+		id,
 		title,
-		channel_title
+		COALESCE(channel_id, ''),
+		channel_title,
+		change_count,
+		last_changed_at
 	FROM ranked_results
 	WHERE relevance_score > 0.1
 	ORDER BY relevance_score DESC, visits DESC
-	LIMIT 10
+	LIMIT 8
 	`
 
 	rows, err := pg.db.Query(query, normalizedInput, prefixPattern, likePattern)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get similar videos by name: %w", err)
+		return nil, fmt.Errorf("failed to get video suggestions: %w", err)
 	}
 	defer rows.Close()
 
-	videos := []SimilarVideo{}
+	videos := []VideoSuggestion{}
 	for rows.Next() {
-		var video SimilarVideo
+		var video VideoSuggestion
+		var lastChangedAt sql.NullTime
 
 		err := rows.Scan(
+			&video.ID,
 			&video.Title,
-			&video.Channel_Name,
+			&video.ChannelID,
+			&video.ChannelTitle,
+			&video.ChangeCount,
+			&lastChangedAt,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan video: %w", err)
+			return nil, fmt.Errorf("failed to scan video suggestion: %w", err)
+		}
+
+		if lastChangedAt.Valid {
+			video.LastChangedAt = &lastChangedAt.Time
 		}
+		video.TitleHighlights = highlightSpans(video.Title, normalizedInput)
+
 		videos = append(videos, video)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over video rows: %w", err)
+		return nil, fmt.Errorf("error iterating over video suggestions: %w", err)
 	}
 
 	return videos, nil
 }
 
+// getChannelSuggestions returns one suggestion per channel_id, however many
+// of the channel's videos match.
+func (pg *PostgresVideoStore) getChannelSuggestions(normalizedInput, prefixPattern, likePattern string) ([]ChannelSuggestion, error) {
+
+	query := `
+	WITH ranked_channels AS (
+		SELECT
+			v.channel_id,
+			MAX(v.channel_title) as channel_title,
+			COUNT(*) as video_count,
+			MAX(
+				CASE
+					WHEN v.normalized_channel_title ILIKE $2 THEN 1.0
+					WHEN v.normalized_channel_title ILIKE $3 THEN 0.7
+					ELSE similarity(v.normalized_channel_title, $1)
+				END
+			) as relevance_score
+		FROM videos v
+		WHERE v.is_active = true
+			AND v.channel_id IS NOT NULL
+			AND (
+				v.normalized_channel_title ILIKE $2
+				OR v.normalized_channel_title ILIKE $3
+				OR v.normalized_channel_title % $1
+			)
+		GROUP BY v.channel_id
+	)
+	SELECT
+		channel_id,
+		channel_title,
+		video_count
+	FROM ranked_channels
+	WHERE relevance_score > 0.1
+	ORDER BY relevance_score DESC, video_count DESC
+	LIMIT 5
+	`
+
+	rows, err := pg.db.Query(query, normalizedInput, prefixPattern, likePattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel suggestions: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []ChannelSuggestion{}
+	for rows.Next() {
+		var channel ChannelSuggestion
+
+		err := rows.Scan(
+			&channel.ChannelID,
+			&channel.ChannelTitle,
+			&channel.VideoCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan channel suggestion: %w", err)
+		}
+
+		channel.Highlights = highlightSpans(channel.ChannelTitle, normalizedInput)
+		channels = append(channels, channel)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over channel suggestions: %w", err)
+	}
+
+	return channels, nil
+}
+
 func ValidateSortBy(sortBy string) SortBy {
 	switch SortBy(sortBy) {
 	case SortByPopular:
 		return SortByPopular
 	case SortByRecent:
 		return SortByRecent
+	case SortByMostChanged:
+		return SortByMostChanged
+	case SortByRecentlyChanged:
+		return SortByRecentlyChanged
+	case SortByTrending:
+		return SortByTrending
 	default:
 		return SortByPopular // Default to popular
 	}
@@ -727,3 +761,14 @@ func ValidateSearchType(searchType string) SearchType {
 		return SearchVideo // Default to video search
 	}
 }
+
+func ValidateSearchScope(scope string) SearchScope {
+	switch SearchScope(scope) {
+	case ScopeCurrent:
+		return ScopeCurrent
+	case ScopeHistory:
+		return ScopeHistory
+	default:
+		return ScopeCurrent // Default to the current title only
+	}
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/grvbrk/nazrein_server/internal/models"
+)
+
+var (
+	ErrVideoNotTracked   = errors.New("video is not tracked")
+	ErrTrackLimitReached = errors.New("track limit reached")
+)
+
+type PostgresVideoWatcherStore struct {
+	db *sql.DB
+}
+
+func NewPostgresVideoWatcherStore(db *sql.DB) *PostgresVideoWatcherStore {
+	return &PostgresVideoWatcherStore{db: db}
+}
+
+type VideoWatcherStore interface {
+	GetVideoIDByYoutubeID(youtubeID string) (uuid.UUID, error)
+	WatchVideo(videoID uuid.UUID, userID uuid.UUID) (bool, error)
+	GetSoleWatchedVideoIDs(userID uuid.UUID) ([]string, error)
+}
+
+// GetVideoIDByYoutubeID returns the id of the tracked video with youtubeID,
+// or ErrVideoNotTracked.
+func (pg *PostgresVideoWatcherStore) GetVideoIDByYoutubeID(youtubeID string) (uuid.UUID, error) {
+	var videoID uuid.UUID
+
+	err := pg.db.QueryRow(`SELECT id FROM videos WHERE youtube_id = $1`, youtubeID).Scan(&videoID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrVideoNotTracked
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to find video: %w", err)
+	}
+
+	return videoID, nil
+}
+
+// WatchVideo adds the user to the watchers of a video. It reports false when
+// they were watching it already, and returns ErrTrackLimitReached when they
+// can't watch another video.
+func (pg *PostgresVideoWatcherStore) WatchVideo(videoID uuid.UUID, userID uuid.UUID) (bool, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			fmt.Printf("rollback error: %v", rErr)
+		}
+	}()
+
+	canTrack, err := LockUserForTracking(tx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	var watching bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM video_watchers
+			WHERE video_id = $1 AND user_id = $2
+		)
+	`
+
+	err = tx.QueryRow(query, videoID, userID).Scan(&watching)
+	if err != nil {
+		return false, fmt.Errorf("failed to check video watcher: %w", err)
+	}
+
+	if watching {
+		return false, nil
+	}
+
+	if !canTrack {
+		return false, ErrTrackLimitReached
+	}
+
+	query = `
+		INSERT INTO video_watchers (video_id, user_id)
+		VALUES ($1, $2)
+	`
+
+	_, err = tx.Exec(query, videoID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to add video watcher: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// LockUserForTracking locks the user's row until tx ends, so watches of
+// concurrent transactions are counted one after the other, and reports
+// whether the user can watch another video. Call it before adding a watcher.
+func LockUserForTracking(tx *sql.Tx, userID uuid.UUID) (bool, error) {
+	var tracked int
+	var unlimited bool
+
+	query := `
+		SELECT u.videos_tracked,
+			EXISTS (SELECT 1 FROM role_permissions rp WHERE rp.role = u.role AND rp.permission = $2)
+		FROM users u
+		WHERE u.id = $1
+		FOR UPDATE OF u
+	`
+
+	err := tx.QueryRow(query, userID, models.PermissionRequestsUnlimited).Scan(&tracked, &unlimited)
+	if err != nil {
+		return false, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	return unlimited || tracked < models.MaxTrackedVideos, nil
+}
+
+// GetSoleWatchedVideoIDs returns the ids of the videos nobody but the user
+// watches, the ones that go with their account.
+func (pg *PostgresVideoWatcherStore) GetSoleWatchedVideoIDs(userID uuid.UUID) ([]string, error) {
+	query := `
+		SELECT w.video_id
+		FROM video_watchers w
+		WHERE w.user_id = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM video_watchers o
+				WHERE o.video_id = w.video_id AND o.user_id <> w.user_id
+			)
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get watched videos: %w", err)
+	}
+	defer rows.Close()
+
+	videoIDs := []string{}
+	for rows.Next() {
+		var videoID uuid.UUID
+		if err := rows.Scan(&videoID); err != nil {
+			return nil, fmt.Errorf("failed to scan video id: %w", err)
+		}
+		videoIDs = append(videoIDs, videoID.String())
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over watched videos: %w", err)
+	}
+
+	return videoIDs, nil
+}
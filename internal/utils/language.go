@@ -0,0 +1,74 @@
+package utils
+
+import "unicode"
+
+// scriptLanguages maps scripts that are (nearly) only used for one language
+// on youtube to that language. Latin and Cyrillic are shared by too many
+// languages to guess from.
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Bengali, "bn"},
+	{unicode.Tamil, "ta"},
+	{unicode.Telugu, "te"},
+	{unicode.Gujarati, "gu"},
+	{unicode.Gurmukhi, "pa"},
+	{unicode.Kannada, "kn"},
+	{unicode.Malayalam, "ml"},
+	{unicode.Thai, "th"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Arabic, "ar"},
+	{unicode.Han, "zh"},
+}
+
+// DetectLanguage guesses the language of text from the script most of its
+// letters are written in, and returns "und" when it can't tell. Kana beats
+// Han, since japanese mixes both.
+func DetectLanguage(text string) string {
+	counts := make([]int, len(scriptLanguages))
+	letters := 0
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+
+		for i, sl := range scriptLanguages {
+			if unicode.Is(sl.script, r) {
+				counts[i]++
+				break
+			}
+		}
+	}
+
+	if letters == 0 {
+		return "und"
+	}
+
+	best := -1
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		// Any kana at all means japanese, even in a mostly kanji title
+		if scriptLanguages[i].language == "ja" {
+			return "ja"
+		}
+		if best == -1 || count > counts[best] {
+			best = i
+		}
+	}
+
+	if best == -1 || counts[best]*2 < letters {
+		return "und"
+	}
+
+	return scriptLanguages[best].language
+}
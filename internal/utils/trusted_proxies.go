@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultTrustedProxies are the private ranges, where the swarm ingress and
+// any load balancer in front of it live.
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+// TrustedProxies are the addresses whose X-Forwarded-For and X-Real-IP
+// headers are believed, so the address ClientIP finds can't be spoofed by a
+// client sending the headers itself.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads a comma separated list of CIDRs or addresses.
+// An empty list means the private ranges.
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	entries := strings.Split(list, ",")
+	if strings.TrimSpace(list) == "" {
+		entries = defaultTrustedProxies
+	}
+
+	var proxies TrustedProxies
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+func (tp TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address a request came from. The forwarding headers
+// are only read when the connection is from a trusted proxy, and
+// X-Forwarded-For is read from the right, skipping the trusted proxies the
+// request went through, since a client can put anything on the left.
+func (tp TrustedProxies) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	remoteIP := net.ParseIP(remote)
+	if remoteIP == nil || !tp.trusts(remoteIP) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	if len(hops) > 0 {
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// garbage is as far as the chain can be followed
+				break
+			}
+			client = ip.String()
+			if !tp.trusts(ip) {
+				break
+			}
+		}
+		return client
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return remote
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/grvbrk/nazrein_server/internal/app"
+	"github.com/grvbrk/nazrein_server/internal/cli"
 	"github.com/grvbrk/nazrein_server/internal/routes"
 )
 
@@ -15,6 +18,14 @@ const (
 
 func main() {
 
+	// Admin commands, like `nazrein keygen`, run instead of the server
+	if len(os.Args) > 1 {
+		if err := cli.Run(os.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app, err := app.NewApplication()
 	if err != nil {
 		log.Fatal("Failed to start application:", err)
@@ -22,6 +33,8 @@ func main() {
 
 	r := routes.SetupRoutes(app)
 
+	app.Scheduler.Start(context.Background())
+
 	// defer app.RedisClient.Close()
 
 	server := &http.Server{
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS default.search_queries (
+  searched_at DateTime64(3),
+  query String,
+  normalized_query String,
+  endpoint LowCardinality(String),
+  search_type LowCardinality(String),
+  result_count UInt32,
+  latency_ms UInt32,
+  is_authenticated Bool,
+
+  created_at DateTime DEFAULT now()
+)
+ENGINE = MergeTree()
+ORDER BY (endpoint, searched_at)
+PARTITION BY toYYYYMM(searched_at);
@@ -0,0 +1,99 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE IF NOT EXISTS video_title_versions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  normalized_title TEXT,
+  search_vector tsvector,
+  first_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+  -- A title that comes back after a change is the same version seen again
+  UNIQUE(video_id, title)
+);
+
+CREATE OR REPLACE FUNCTION update_video_title_version_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+
+    NEW.normalized_title = lower(trim(regexp_replace(NEW.title, '\s+', ' ', 'g')));
+    NEW.search_vector = to_tsvector('english', COALESCE(NEW.title, ''));
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_video_title_version_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title ON video_title_versions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_video_title_version_search_vector();
+
+-- The title a video was approved with is its first version. Later versions
+-- are synced from the clickhouse video_snapshots table.
+CREATE OR REPLACE FUNCTION record_video_title_version() RETURNS TRIGGER AS $$
+DECLARE
+    seen_at TIMESTAMP WITH TIME ZONE;
+BEGIN
+
+    IF (TG_OP = 'INSERT') THEN
+        seen_at = COALESCE(NEW.created_at, CURRENT_TIMESTAMP);
+    ELSE
+        seen_at = CURRENT_TIMESTAMP;
+    END IF;
+
+    INSERT INTO video_title_versions (video_id, title, first_seen_at, last_seen_at)
+    VALUES (NEW.id, NEW.title, seen_at, seen_at)
+    ON CONFLICT (video_id, title) DO UPDATE
+    SET last_seen_at = GREATEST(video_title_versions.last_seen_at, EXCLUDED.last_seen_at),
+        updated_at = CURRENT_TIMESTAMP;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_video_title_version_trigger
+    AFTER INSERT OR UPDATE OF title ON videos
+    FOR EACH ROW
+    EXECUTE FUNCTION record_video_title_version();
+
+-- Backfill the approval time title of every existing video
+INSERT INTO video_title_versions (video_id, title, first_seen_at, last_seen_at)
+SELECT id, title, COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM videos
+ON CONFLICT (video_id, title) DO NOTHING;
+
+-- How far each background sync has read from clickhouse
+CREATE TABLE IF NOT EXISTS sync_checkpoints (
+  name VARCHAR(100) PRIMARY KEY,
+  synced_until TIMESTAMP WITH TIME ZONE NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_video_title_versions_video_id ON video_title_versions(video_id);
+CREATE INDEX idx_video_title_versions_last_seen_at ON video_title_versions(last_seen_at DESC);
+CREATE INDEX idx_video_title_versions_search_vector ON video_title_versions USING gin(search_vector);
+CREATE INDEX idx_video_title_versions_normalized_title ON video_title_versions USING gin(normalized_title gin_trgm_ops);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TRIGGER IF EXISTS record_video_title_version_trigger ON videos;
+DROP FUNCTION IF EXISTS record_video_title_version();
+
+DROP TRIGGER IF EXISTS update_video_title_version_search_vector_trigger ON video_title_versions;
+DROP FUNCTION IF EXISTS update_video_title_version_search_vector();
+
+DROP INDEX IF EXISTS idx_video_title_versions_video_id;
+DROP INDEX IF EXISTS idx_video_title_versions_last_seen_at;
+DROP INDEX IF EXISTS idx_video_title_versions_search_vector;
+DROP INDEX IF EXISTS idx_video_title_versions_normalized_title;
+
+DROP TABLE IF EXISTS sync_checkpoints;
+DROP TABLE IF EXISTS video_title_versions;
+
+-- +goose StatementEnd
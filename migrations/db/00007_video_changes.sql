@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- One row per snapshot where a video's title or thumbnail differed from the
+-- snapshot before it. Synced from the clickhouse video_snapshots table.
+CREATE TABLE IF NOT EXISTS video_changes (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+  changed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  title_changed BOOLEAN NOT NULL DEFAULT FALSE,
+  thumbnail_changed BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE(video_id, changed_at)
+);
+
+CREATE INDEX idx_video_changes_video_changed_at ON video_changes(video_id, changed_at DESC);
+CREATE INDEX idx_video_changes_changed_at ON video_changes(changed_at DESC);
+
+CREATE INDEX idx_videos_channel_id ON videos(channel_id);
+CREATE INDEX idx_videos_published_at ON videos(published_at);
+CREATE INDEX idx_videos_created_at ON videos(created_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_videos_channel_id;
+DROP INDEX IF EXISTS idx_videos_published_at;
+DROP INDEX IF EXISTS idx_videos_created_at;
+
+DROP INDEX IF EXISTS idx_video_changes_video_changed_at;
+DROP INDEX IF EXISTS idx_video_changes_changed_at;
+
+DROP TABLE IF EXISTS video_changes;
+
+-- +goose StatementEnd
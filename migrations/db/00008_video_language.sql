@@ -0,0 +1,188 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Maps the primary subtag of a video's language (from youtube's
+-- defaultLanguage or script detection) to the text search config its
+-- search_vector is built with. Languages without a stemmer, like hindi and
+-- japanese, aren't listed and fall back to simple.
+CREATE TABLE IF NOT EXISTS text_search_languages (
+  code VARCHAR(10) PRIMARY KEY,
+  config regconfig NOT NULL
+);
+
+-- Only configs this postgres build ships with are seeded
+INSERT INTO text_search_languages (code, config)
+SELECT l.code, l.config_name::regconfig
+FROM (VALUES
+  ('ar', 'arabic'),
+  ('da', 'danish'),
+  ('de', 'german'),
+  ('el', 'greek'),
+  ('en', 'english'),
+  ('es', 'spanish'),
+  ('fi', 'finnish'),
+  ('fr', 'french'),
+  ('ga', 'irish'),
+  ('hu', 'hungarian'),
+  ('id', 'indonesian'),
+  ('it', 'italian'),
+  ('lt', 'lithuanian'),
+  ('nb', 'norwegian'),
+  ('ne', 'nepali'),
+  ('nl', 'dutch'),
+  ('nn', 'norwegian'),
+  ('no', 'norwegian'),
+  ('pt', 'portuguese'),
+  ('ro', 'romanian'),
+  ('ru', 'russian'),
+  ('sr', 'serbian'),
+  ('sv', 'swedish'),
+  ('ta', 'tamil'),
+  ('tr', 'turkish')
+) AS l(code, config_name)
+WHERE EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = l.config_name)
+ON CONFLICT (code) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION video_search_config(lang TEXT) RETURNS regconfig AS $$
+    SELECT COALESCE(
+        (SELECT config FROM text_search_languages
+         WHERE code = split_part(replace(lower(COALESCE(lang, '')), '_', '-'), '-', 1)),
+        'simple'::regconfig
+    );
+$$ LANGUAGE sql STABLE;
+
+-- Parses a search query with every config videos can be indexed with and ORs
+-- the results, so one query matches videos of every language.
+CREATE OR REPLACE FUNCTION video_search_query(query TEXT) RETURNS tsquery AS $$
+DECLARE
+    result tsquery := plainto_tsquery('simple', query);
+    config regconfig;
+BEGIN
+    FOR config IN SELECT DISTINCT l.config FROM text_search_languages l LOOP
+        result = result || plainto_tsquery(config, query);
+    END LOOP;
+
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+ALTER TABLE videos
+ADD COLUMN language VARCHAR(20),
+ADD COLUMN search_config regconfig NOT NULL DEFAULT 'simple';
+
+CREATE OR REPLACE FUNCTION update_video_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+
+    NEW.normalized_video_title = lower(trim(regexp_replace(NEW.title, '\s+', ' ', 'g')));
+    NEW.normalized_channel_title = lower(trim(regexp_replace(NEW.channel_title, '\s+', ' ', 'g')));
+    NEW.search_config = video_search_config(NEW.language);
+
+    -- A = higher weight (video title), B = lower weight (channel name )
+    NEW.search_vector =
+        setweight(to_tsvector(NEW.search_config, COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_config, COALESCE(NEW.channel_title, '')), 'B');
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_video_search_vector_trigger ON videos;
+
+CREATE TRIGGER update_video_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title, channel_title, description, language ON videos
+    FOR EACH ROW
+    EXECUTE FUNCTION update_video_search_vector();
+
+CREATE OR REPLACE FUNCTION update_video_title_version_search_vector() RETURNS TRIGGER AS $$
+DECLARE
+    config regconfig;
+BEGIN
+
+    SELECT search_config INTO config FROM videos WHERE id = NEW.video_id;
+
+    NEW.normalized_title = lower(trim(regexp_replace(NEW.title, '\s+', ' ', 'g')));
+    NEW.search_vector = to_tsvector(COALESCE(config, 'simple'::regconfig), COALESCE(NEW.title, ''));
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Old titles are indexed with the video's config too, so they need
+-- reindexing when its language changes
+CREATE OR REPLACE FUNCTION reindex_video_title_versions() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.search_config IS DISTINCT FROM OLD.search_config THEN
+        UPDATE video_title_versions SET title = title WHERE video_id = NEW.id;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reindex_video_title_versions_trigger
+    AFTER UPDATE OF language ON videos
+    FOR EACH ROW
+    EXECUTE FUNCTION reindex_video_title_versions();
+
+-- Existing videos have no language yet. The language backfill job fills it
+-- in from youtube, which reindexes them through the triggers above. Until
+-- then they keep the english config they were indexed with.
+UPDATE videos SET search_config = 'english' WHERE language IS NULL;
+
+CREATE INDEX idx_videos_language_missing ON videos(created_at) WHERE language IS NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_videos_language_missing;
+
+DROP TRIGGER IF EXISTS reindex_video_title_versions_trigger ON videos;
+DROP FUNCTION IF EXISTS reindex_video_title_versions();
+
+CREATE OR REPLACE FUNCTION update_video_title_version_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+
+    NEW.normalized_title = lower(trim(regexp_replace(NEW.title, '\s+', ' ', 'g')));
+    NEW.search_vector = to_tsvector('english', COALESCE(NEW.title, ''));
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_video_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+
+    NEW.normalized_video_title = lower(trim(regexp_replace(NEW.title, '\s+', ' ', 'g')));
+    NEW.normalized_channel_title = lower(trim(regexp_replace(NEW.channel_title, '\s+', ' ', 'g')));
+
+    -- A = higher weight (video title), B = lower weight (channel name )
+    NEW.search_vector =
+        setweight(to_tsvector('english', COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE(NEW.channel_title, '')), 'B');
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_video_search_vector_trigger ON videos;
+
+CREATE TRIGGER update_video_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title, channel_title, description ON videos
+    FOR EACH ROW
+    EXECUTE FUNCTION update_video_search_vector();
+
+ALTER TABLE videos
+DROP COLUMN IF EXISTS language,
+DROP COLUMN IF EXISTS search_config;
+
+UPDATE videos SET title = title WHERE TRUE;
+UPDATE video_title_versions SET title = title WHERE TRUE;
+
+DROP FUNCTION IF EXISTS video_search_query(TEXT);
+DROP FUNCTION IF EXISTS video_search_config(TEXT);
+
+DROP TABLE IF EXISTS text_search_languages;
+
+-- +goose StatementEnd
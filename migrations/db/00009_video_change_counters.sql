@@ -0,0 +1,63 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE videos
+ADD COLUMN change_count INTEGER NOT NULL DEFAULT 0 CHECK (change_count >= 0),
+ADD COLUMN last_changed_at TIMESTAMP WITH TIME ZONE;
+
+-- Keeps the counters on videos in step with video_changes, which the change
+-- sync job fills in as new snapshots land in clickhouse
+CREATE OR REPLACE FUNCTION update_video_change_counters() RETURNS TRIGGER AS $$
+BEGIN
+  IF (TG_OP = 'INSERT') THEN
+    UPDATE videos
+    SET change_count = change_count + 1,
+        last_changed_at = GREATEST(last_changed_at, NEW.changed_at)
+    WHERE id = NEW.video_id;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') THEN
+    UPDATE videos
+    SET change_count = GREATEST(change_count - 1, 0),
+        last_changed_at = (SELECT MAX(changed_at) FROM video_changes WHERE video_id = OLD.video_id)
+    WHERE id = OLD.video_id;
+    RETURN OLD;
+  END IF;
+  RETURN COALESCE(NEW, OLD);
+
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_video_change_counters_trigger
+  AFTER INSERT OR DELETE ON video_changes
+  FOR EACH ROW
+  EXECUTE FUNCTION update_video_change_counters();
+
+UPDATE videos v
+SET change_count = c.change_count,
+    last_changed_at = c.last_changed_at
+FROM (
+  SELECT video_id, COUNT(*) AS change_count, MAX(changed_at) AS last_changed_at
+  FROM video_changes
+  GROUP BY video_id
+) c
+WHERE v.id = c.video_id;
+
+CREATE INDEX idx_videos_change_count ON videos(change_count DESC, last_changed_at DESC NULLS LAST);
+CREATE INDEX idx_videos_last_changed_at ON videos(last_changed_at DESC NULLS LAST);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TRIGGER IF EXISTS update_video_change_counters_trigger ON video_changes;
+DROP FUNCTION IF EXISTS update_video_change_counters();
+
+DROP INDEX IF EXISTS idx_videos_change_count;
+DROP INDEX IF EXISTS idx_videos_last_changed_at;
+
+ALTER TABLE videos
+DROP COLUMN IF EXISTS change_count,
+DROP COLUMN IF EXISTS last_changed_at;
+
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Visits per video per day, so trending can weigh recent visits over old
+-- ones. videos.visits keeps the all time total.
+CREATE TABLE IF NOT EXISTS video_daily_visits (
+  video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+  day DATE NOT NULL,
+  visits INTEGER NOT NULL DEFAULT 0 CHECK (visits >= 0),
+
+  PRIMARY KEY (video_id, day)
+);
+
+CREATE INDEX idx_video_daily_visits_day ON video_daily_visits(day);
+
+-- Refreshed periodically by the trending refresh job
+ALTER TABLE videos
+ADD COLUMN trending_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+ADD COLUMN trending_updated_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_videos_trending_score ON videos(trending_score DESC);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_videos_trending_score;
+
+ALTER TABLE videos
+DROP COLUMN IF EXISTS trending_score,
+DROP COLUMN IF EXISTS trending_updated_at;
+
+DROP INDEX IF EXISTS idx_video_daily_visits_day;
+
+DROP TABLE IF EXISTS video_daily_visits;
+
+-- +goose StatementEnd
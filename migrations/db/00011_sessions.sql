@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Server side sessions. The cookie only carries the signed session id, so a
+-- session stops working as soon as its row is deleted.
+CREATE TABLE IF NOT EXISTS sessions (
+  id VARCHAR(64) PRIMARY KEY,
+  -- cookie name, nazrein_session or nazrein_admin_session
+  name VARCHAR(50) NOT NULL,
+  user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+  -- session values, encoded and encrypted with the session keys
+  data TEXT NOT NULL,
+  user_agent TEXT,
+  ip_address VARCHAR(64),
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id, name);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_sessions_user_id;
+DROP INDEX IF EXISTS idx_sessions_expires_at;
+
+DROP TABLE IF EXISTS sessions;
+
+-- +goose StatementEnd
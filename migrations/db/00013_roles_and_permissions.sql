@@ -0,0 +1,86 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE IF NOT EXISTS roles (
+  name VARCHAR(50) PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+  name VARCHAR(100) PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role VARCHAR(50) NOT NULL REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE,
+  permission VARCHAR(100) NOT NULL REFERENCES permissions(name) ON UPDATE CASCADE ON DELETE CASCADE,
+
+  PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+  ('USER', 'Default role of every account'),
+  ('PRO', 'Users without the request and tracking limits'),
+  ('MODERATOR', 'Reviews video requests in the admin panel'),
+  ('ADMIN', 'Full access')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+  ('requests:create', 'Request videos to be tracked'),
+  ('requests:unlimited', 'No limit on pending requests and tracked videos'),
+  ('requests:review', 'Approve and reject video requests'),
+  ('bookmarks:write', 'Bookmark videos'),
+  ('admin:access', 'Log into the admin panel'),
+  ('analytics:read', 'Read search analytics'),
+  ('sessions:revoke', 'Log other users out'),
+  ('users:manage', 'Change the role of users')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('USER', 'requests:create'),
+  ('USER', 'bookmarks:write'),
+
+  ('PRO', 'requests:create'),
+  ('PRO', 'bookmarks:write'),
+  ('PRO', 'requests:unlimited'),
+
+  ('MODERATOR', 'requests:create'),
+  ('MODERATOR', 'bookmarks:write'),
+  ('MODERATOR', 'admin:access'),
+  ('MODERATOR', 'requests:review')
+ON CONFLICT (role, permission) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission)
+SELECT 'ADMIN', name FROM permissions
+ON CONFLICT (role, permission) DO NOTHING;
+
+-- users.role becomes a reference to roles instead of the two value enum
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN role TYPE VARCHAR(50) USING COALESCE(role::text, 'USER');
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'USER';
+ALTER TABLE users ALTER COLUMN role SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE;
+
+DROP TYPE IF EXISTS user_role;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+CREATE TYPE user_role AS ENUM ('ADMIN', 'USER');
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+ALTER TABLE users ALTER COLUMN role DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN role DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN role TYPE user_role
+  USING (CASE WHEN role = 'ADMIN' THEN 'ADMIN' ELSE 'USER' END)::user_role;
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'USER';
+
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
+
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The accounts a user logs in with. subject is the user's id at the
+-- provider, which unlike the email never changes.
+CREATE TABLE IF NOT EXISTS user_identities (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider VARCHAR(50) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  email VARCHAR(255),
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  last_login_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE (provider, subject),
+  -- one account per provider and user, so unlinking can go by provider
+  UNIQUE (user_id, provider)
+);
+
+INSERT INTO user_identities (user_id, provider, subject, email)
+SELECT id, 'google', google_id, email
+FROM users
+WHERE google_id IS NOT NULL
+ON CONFLICT (provider, subject) DO NOTHING;
+
+ALTER TABLE users DROP COLUMN IF EXISTS google_id;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- users who never linked google keep a NULL google_id
+ALTER TABLE users ADD COLUMN IF NOT EXISTS google_id VARCHAR(255) UNIQUE;
+
+UPDATE users u
+SET google_id = i.subject
+FROM user_identities i
+WHERE i.user_id = u.id AND i.provider = 'google';
+
+DROP TABLE IF EXISTS user_identities;
+
+-- +goose StatementEnd
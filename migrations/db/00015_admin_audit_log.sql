@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Every change made from the admin panel. Rows are only ever inserted, the
+-- triggers below refuse updates and deletes. The actor has no foreign key
+-- so that entries outlive deleted accounts, actor_email keeps who it was.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  actor_id UUID NOT NULL,
+  actor_email VARCHAR(255) NOT NULL,
+  action VARCHAR(100) NOT NULL,
+  target_type VARCHAR(50) NOT NULL,
+  target_id VARCHAR(255) NOT NULL,
+  before JSONB,
+  after JSONB,
+  ip_address VARCHAR(45),
+  user_agent TEXT,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_admin_audit_log_created_at ON admin_audit_log(created_at DESC);
+CREATE INDEX idx_admin_audit_log_actor ON admin_audit_log(actor_id, created_at DESC);
+CREATE INDEX idx_admin_audit_log_target ON admin_audit_log(target_type, target_id, created_at DESC);
+CREATE INDEX idx_admin_audit_log_action ON admin_audit_log(action, created_at DESC);
+
+CREATE OR REPLACE FUNCTION admin_audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'admin_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER admin_audit_log_no_update_delete
+BEFORE UPDATE OR DELETE ON admin_audit_log
+FOR EACH ROW EXECUTE FUNCTION admin_audit_log_append_only();
+
+CREATE TRIGGER admin_audit_log_no_truncate
+BEFORE TRUNCATE ON admin_audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION admin_audit_log_append_only();
+
+INSERT INTO permissions (name, description) VALUES
+  ('audit:read', 'Read the admin audit log')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('ADMIN', 'audit:read')
+ON CONFLICT (role, permission) DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DELETE FROM permissions WHERE name = 'audit:read';
+
+DROP TABLE IF EXISTS admin_audit_log;
+
+DROP FUNCTION IF EXISTS admin_audit_log_append_only();
+
+-- +goose StatementEnd
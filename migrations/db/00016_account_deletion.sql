@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Users can ask for their account to be deleted. It's kept until
+-- delete_after, so the user can change their mind, and then deleted by the
+-- account_deletion job, cascading to everything the user owns.
+ALTER TABLE users ADD COLUMN delete_after TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_delete_after ON users(delete_after) WHERE delete_after IS NOT NULL;
+
+-- Deleting an admin keeps the requests they processed.
+ALTER TABLE video_requests DROP CONSTRAINT IF EXISTS video_requests_processed_by_fkey;
+ALTER TABLE video_requests ADD CONSTRAINT video_requests_processed_by_fkey
+  FOREIGN KEY (processed_by) REFERENCES users(id) ON DELETE SET NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE video_requests DROP CONSTRAINT IF EXISTS video_requests_processed_by_fkey;
+ALTER TABLE video_requests ADD CONSTRAINT video_requests_processed_by_fkey
+  FOREIGN KEY (processed_by) REFERENCES users(id);
+
+DROP INDEX IF EXISTS idx_users_delete_after;
+
+ALTER TABLE users DROP COLUMN IF EXISTS delete_after;
+
+-- +goose StatementEnd
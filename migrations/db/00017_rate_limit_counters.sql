@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Rate limit counters shared by the replicas, when redis isn't available.
+-- Losing them in a crash only resets the limits, so the table skips the WAL.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_counters (
+  key VARCHAR(255) NOT NULL,
+  window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+  count INTEGER NOT NULL DEFAULT 0,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+  PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX idx_rate_limit_counters_expires_at ON rate_limit_counters(expires_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_rate_limit_counters_expires_at;
+
+DROP TABLE IF EXISTS rate_limit_counters;
+
+-- +goose StatementEnd
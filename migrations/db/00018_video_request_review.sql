@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- A request an admin has picked up but not decided on yet. The allowed
+-- changes of status are in internal/store/admin/video_request_status.go.
+ALTER TYPE video_request_status ADD VALUE IF NOT EXISTS 'UNDER_REVIEW' AFTER 'PENDING';
+
+-- updated_at is the version admins edit a request against, it can't be
+-- missing.
+UPDATE video_requests SET updated_at = COALESCE(created_at, NOW()) WHERE updated_at IS NULL;
+ALTER TABLE video_requests ALTER COLUMN updated_at SET NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- enum values can't be dropped, the type is made again without it
+UPDATE video_requests SET status = 'PENDING' WHERE status = 'UNDER_REVIEW';
+
+ALTER TABLE video_requests ALTER COLUMN updated_at DROP NOT NULL;
+
+ALTER TYPE video_request_status RENAME TO video_request_status_old;
+CREATE TYPE video_request_status AS ENUM ('PENDING', 'ACCEPTED', 'REJECTED');
+
+ALTER TABLE video_requests ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE video_requests
+  ALTER COLUMN status TYPE video_request_status USING status::text::video_request_status;
+ALTER TABLE video_requests ALTER COLUMN status SET DEFAULT 'PENDING';
+
+DROP TYPE video_request_status_old;
+
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Rules approving requests without an admin. conditions is a JSON object of
+-- the checks a request has to pass, see admin.AutoApprovalConditions.
+-- Enabled rules are tried by priority, highest first.
+CREATE TABLE IF NOT EXISTS auto_approval_rules (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  name VARCHAR(100) NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  priority INTEGER NOT NULL DEFAULT 0,
+  conditions JSONB NOT NULL DEFAULT '{}',
+  created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_auto_approval_rules_enabled ON auto_approval_rules(priority DESC, created_at) WHERE enabled;
+
+-- the rule that approved the request, if one did
+ALTER TABLE video_requests
+  ADD COLUMN auto_approval_rule_id UUID REFERENCES auto_approval_rules(id) ON DELETE SET NULL;
+
+-- when the rules were last tried on a pending request, cleared when the
+-- rules change so they are tried again
+ALTER TABLE video_requests
+  ADD COLUMN auto_approval_checked_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_video_requests_auto_approval_unchecked ON video_requests(created_at)
+  WHERE status = 'PENDING' AND auto_approval_checked_at IS NULL;
+
+INSERT INTO permissions (name, description) VALUES
+  ('auto_approval:manage', 'Manage the rules approving video requests automatically')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('ADMIN', 'auto_approval:manage')
+ON CONFLICT (role, permission) DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM role_permissions WHERE permission = 'auto_approval:manage';
+DELETE FROM permissions WHERE name = 'auto_approval:manage';
+
+DROP INDEX IF EXISTS idx_video_requests_auto_approval_unchecked;
+ALTER TABLE video_requests DROP COLUMN IF EXISTS auto_approval_checked_at;
+ALTER TABLE video_requests DROP COLUMN IF EXISTS auto_approval_rule_id;
+
+DROP TABLE IF EXISTS auto_approval_rules;
+
+-- +goose StatementEnd
@@ -0,0 +1,159 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The users tracking each video. A video is tracked once, by youtube id, and
+-- every user asking for it watches that row. videos.user_id stays as who
+-- asked first, and no longer takes the video with it when they go.
+CREATE TABLE IF NOT EXISTS video_watchers (
+  video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  -- the request that started the watch, none when the video was already
+  -- tracked
+  request_id UUID REFERENCES video_requests(id) ON DELETE SET NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (video_id, user_id)
+);
+
+CREATE INDEX idx_video_watchers_user ON video_watchers(user_id, created_at DESC);
+
+INSERT INTO video_watchers (video_id, user_id, request_id, created_at)
+SELECT v.id, v.user_id, vr.id, v.created_at
+FROM videos v
+LEFT JOIN video_requests vr ON vr.youtube_id = v.youtube_id AND vr.user_id = v.user_id
+ON CONFLICT (video_id, user_id) DO NOTHING;
+
+ALTER TABLE videos ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE videos DROP CONSTRAINT IF EXISTS videos_user_id_fkey;
+ALTER TABLE videos ADD CONSTRAINT videos_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+-- any number of users can request a video, once each
+ALTER TABLE video_requests DROP CONSTRAINT IF EXISTS video_requests_link_key;
+ALTER TABLE video_requests DROP CONSTRAINT IF EXISTS video_requests_youtube_id_key;
+
+-- videos_tracked counts the active videos a user watches
+DROP TRIGGER IF EXISTS update_track_count ON videos;
+DROP FUNCTION IF EXISTS update_videos_tracked_count_of_user();
+
+CREATE OR REPLACE FUNCTION update_videos_tracked_count_of_watcher() RETURNS TRIGGER AS $$
+BEGIN
+  IF (TG_OP = 'INSERT') THEN
+    UPDATE users SET videos_tracked = videos_tracked + 1
+    WHERE id = NEW.user_id AND EXISTS (SELECT 1 FROM videos WHERE id = NEW.video_id AND is_active);
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') THEN
+    UPDATE users SET videos_tracked = videos_tracked - 1
+    WHERE id = OLD.user_id AND EXISTS (SELECT 1 FROM videos WHERE id = OLD.video_id AND is_active);
+    RETURN OLD;
+  END IF;
+  RETURN COALESCE(NEW, OLD);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_watcher_track_count
+  AFTER INSERT OR DELETE ON video_watchers
+  FOR EACH ROW
+  EXECUTE FUNCTION update_videos_tracked_count_of_watcher();
+
+-- deleting a video cascades to its watchers, whose trigger can't see the
+-- video anymore, so the counts are lowered before it goes
+CREATE OR REPLACE FUNCTION update_videos_tracked_count_of_video() RETURNS TRIGGER AS $$
+BEGIN
+  IF (TG_OP = 'UPDATE') THEN
+    IF OLD.is_active = FALSE AND NEW.is_active = TRUE THEN
+      UPDATE users SET videos_tracked = videos_tracked + 1
+      WHERE id IN (SELECT user_id FROM video_watchers WHERE video_id = NEW.id);
+    ELSIF OLD.is_active = TRUE AND NEW.is_active = FALSE THEN
+      UPDATE users SET videos_tracked = videos_tracked - 1
+      WHERE id IN (SELECT user_id FROM video_watchers WHERE video_id = NEW.id);
+    END IF;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') AND OLD.is_active = TRUE THEN
+    UPDATE users SET videos_tracked = videos_tracked - 1
+    WHERE id IN (SELECT user_id FROM video_watchers WHERE video_id = OLD.id);
+    RETURN OLD;
+  END IF;
+  RETURN COALESCE(NEW, OLD);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_video_track_count
+  AFTER UPDATE OF is_active ON videos
+  FOR EACH ROW
+  EXECUTE FUNCTION update_videos_tracked_count_of_video();
+
+CREATE TRIGGER update_video_track_count_on_delete
+  BEFORE DELETE ON videos
+  FOR EACH ROW
+  EXECUTE FUNCTION update_videos_tracked_count_of_video();
+
+UPDATE users u
+SET videos_tracked = (
+  SELECT COUNT(*)
+  FROM video_watchers w
+  JOIN videos v ON v.id = w.video_id
+  WHERE w.user_id = u.id AND v.is_active
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TRIGGER IF EXISTS update_video_track_count_on_delete ON videos;
+DROP TRIGGER IF EXISTS update_video_track_count ON videos;
+DROP FUNCTION IF EXISTS update_videos_tracked_count_of_video();
+DROP TRIGGER IF EXISTS update_watcher_track_count ON video_watchers;
+DROP FUNCTION IF EXISTS update_videos_tracked_count_of_watcher();
+
+DROP TABLE IF EXISTS video_watchers;
+
+-- only the first request for a video can be kept unique
+DELETE FROM video_requests vr
+USING video_requests first
+WHERE vr.youtube_id = first.youtube_id
+  AND (vr.created_at, vr.id) > (first.created_at, first.id);
+
+ALTER TABLE video_requests ADD CONSTRAINT video_requests_link_key UNIQUE (link);
+ALTER TABLE video_requests ADD CONSTRAINT video_requests_youtube_id_key UNIQUE (youtube_id);
+
+DELETE FROM videos WHERE user_id IS NULL;
+
+ALTER TABLE videos DROP CONSTRAINT IF EXISTS videos_user_id_fkey;
+ALTER TABLE videos ADD CONSTRAINT videos_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE videos ALTER COLUMN user_id SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION update_videos_tracked_count_of_user() RETURNS TRIGGER as $$
+BEGIN
+  IF (TG_OP = 'INSERT') AND NEW.is_active = TRUE THEN
+    UPDATE users SET videos_tracked = videos_tracked + 1 WHERE id = NEW.user_id;
+    RETURN NEW;
+  ELSIF (TG_OP = 'UPDATE') THEN
+    IF OLD.is_active = FALSE AND NEW.is_active = TRUE THEN
+      UPDATE users SET videos_tracked = videos_tracked + 1 WHERE id = NEW.user_id;
+    ELSIF OLD.is_active = TRUE AND NEW.is_active = FALSE THEN
+      UPDATE users SET videos_tracked = videos_tracked - 1 WHERE id = NEW.user_id;
+    END IF;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') AND OLD.is_active = TRUE THEN
+    UPDATE users SET videos_tracked = videos_tracked - 1 WHERE id = OLD.user_id;
+    RETURN OLD;
+  END IF;
+  RETURN COALESCE(NEW, OLD);
+
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_track_count
+  AFTER INSERT OR UPDATE OR DELETE ON videos
+  FOR EACH ROW
+  EXECUTE FUNCTION update_videos_tracked_count_of_user();
+
+UPDATE users u
+SET videos_tracked = (
+  SELECT COUNT(*) FROM videos v WHERE v.user_id = u.id AND v.is_active
+);
+
+-- +goose StatementEnd
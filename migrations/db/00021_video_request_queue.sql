@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the channel of the requested video, looked up when the request is made so
+-- the admin queue can be filtered by it
+ALTER TABLE video_requests ADD COLUMN channel_id VARCHAR(255);
+ALTER TABLE video_requests ADD COLUMN channel_title VARCHAR(255);
+
+UPDATE video_requests vr
+SET channel_id = v.channel_id, channel_title = v.channel_title
+FROM videos v
+WHERE v.youtube_id = vr.youtube_id AND vr.channel_id IS NULL;
+
+-- the admin queue pages through a status by creation time
+CREATE INDEX idx_video_requests_queue ON video_requests(status, created_at, id);
+CREATE INDEX idx_video_requests_channel ON video_requests(channel_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_video_requests_channel;
+DROP INDEX IF EXISTS idx_video_requests_queue;
+
+ALTER TABLE video_requests DROP COLUMN IF EXISTS channel_title;
+ALTER TABLE video_requests DROP COLUMN IF EXISTS channel_id;
+
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- when the rules are next tried on a pending request. Requests no rule
+-- matched are tried again later, since their requester's account and
+-- history age into the conditions, and requests whose approval failed are
+-- retried with a backoff until they run out of attempts.
+ALTER TABLE video_requests
+  ADD COLUMN auto_approval_next_check_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN auto_approval_failures INT NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS idx_video_requests_auto_approval_unchecked;
+
+CREATE INDEX idx_video_requests_auto_approval_due ON video_requests(created_at)
+  WHERE status = 'PENDING';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_video_requests_auto_approval_due;
+
+CREATE INDEX idx_video_requests_auto_approval_unchecked ON video_requests(created_at)
+  WHERE status = 'PENDING' AND auto_approval_checked_at IS NULL;
+
+ALTER TABLE video_requests DROP COLUMN IF EXISTS auto_approval_failures;
+ALTER TABLE video_requests DROP COLUMN IF EXISTS auto_approval_next_check_at;
+
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Deleting a rule only marks it deleted, so the requests it approved keep
+-- pointing at the rule that fired. Deleted rules are never tried again.
+ALTER TABLE auto_approval_rules ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE video_requests DROP CONSTRAINT IF EXISTS video_requests_auto_approval_rule_id_fkey;
+ALTER TABLE video_requests ADD CONSTRAINT video_requests_auto_approval_rule_id_fkey
+  FOREIGN KEY (auto_approval_rule_id) REFERENCES auto_approval_rules(id) ON DELETE RESTRICT;
+
+DROP INDEX IF EXISTS idx_auto_approval_rules_enabled;
+CREATE INDEX idx_auto_approval_rules_enabled ON auto_approval_rules(priority DESC, created_at)
+  WHERE enabled AND deleted_at IS NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS idx_auto_approval_rules_enabled;
+CREATE INDEX idx_auto_approval_rules_enabled ON auto_approval_rules(priority DESC, created_at) WHERE enabled;
+
+ALTER TABLE video_requests DROP CONSTRAINT IF EXISTS video_requests_auto_approval_rule_id_fkey;
+ALTER TABLE video_requests ADD CONSTRAINT video_requests_auto_approval_rule_id_fkey
+  FOREIGN KEY (auto_approval_rule_id) REFERENCES auto_approval_rules(id) ON DELETE SET NULL;
+
+-- the down migration can't keep deleted rules out of the rules tried
+DELETE FROM auto_approval_rules WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE auto_approval_rules DROP COLUMN IF EXISTS deleted_at;
+
+-- +goose StatementEnd